@@ -1,14 +1,55 @@
 package main
 
 import (
+	"flag"
 	"log"
+	"os"
 	"user_balance_microservice/internal/app/apiserver"
 )
 
 func main() {
 	config := apiserver.GetConfig()
 
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "reconcile":
+			reconcile(config, os.Args[2:])
+			return
+		case "migrate":
+			migrate(config, os.Args[2:])
+			return
+		}
+	}
+
 	if err := apiserver.Start(config); err != nil {
 		log.Fatal(err)
 	}
 }
+
+// reconcile compares the balances with the transaction history once and
+// prints the accounts that differ.
+func reconcile(config *apiserver.Config, args []string) {
+	flags := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	format := flags.String("format", "json", "output format: json or csv")
+	save := flags.Bool("save", false, "write the discrepancies to the discrepancies table")
+	flags.Parse(args)
+
+	if err := apiserver.Reconcile(config, os.Stdout, *format, *save); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// migrate applies or reverts the schema migrations: migrate up, migrate down
+// [-steps n] or migrate status.
+func migrate(config *apiserver.Config, args []string) {
+	if len(args) == 0 {
+		log.Fatal("usage: main migrate up|down|status")
+	}
+	flags := flag.NewFlagSet("migrate "+args[0], flag.ExitOnError)
+	steps := flags.Int("steps", 1, "number of migrations to revert with down")
+	flags.Parse(args[1:])
+
+	if err := apiserver.Migrate(config, os.Stdout, args[0], *steps); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -1,8 +1,12 @@
 package apiserver
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
+	"net"
 	"net/http"
+	"user_balance_microservice/internal/app/model"
 	"user_balance_microservice/internal/app/store/sqlstore"
 )
 
@@ -13,10 +17,64 @@ func Start(config *Config) error {
 	}
 
 	defer db.Close()
+
+	if config.MigrateOnStart {
+		migrator, err := newMigrator(db)
+		if err != nil {
+			return err
+		}
+		if _, err := migrator.Up(context.Background()); err != nil {
+			return err
+		}
+	}
+
+	rates, err := newRatesProvider(config)
+	if err != nil {
+		return err
+	}
+
+	reportStorage, err := newReportStorage(config)
+	if err != nil {
+		return err
+	}
+	if err := checkIdempotencyLease(config); err != nil {
+		return err
+	}
+	v := &validator{}
+	v.reportFormat(model.ReportFormat{Name: model.FormatCSV, Delimiter: config.Reports.CSVDelimiter})
+	if err := v.err(); err != nil {
+		return fmt.Errorf("reports csv_delimiter %q: %w", config.Reports.CSVDelimiter, err)
+	}
+
 	store := sqlstore.New(db)
-	srv := newServer(store)
+	srv := newServer(store, config)
+	srv.setRates(rates)
+	srv.reportStorage = reportStorage
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go srv.cleanIdempotencyKeys(ctx)
+	go srv.sweepReservations(ctx)
+	go srv.runReportJobs(ctx)
+	if config.Reports.Retention > 0 {
+		go srv.cleanReports(ctx)
+	}
+	if config.Reconciliation.Interval > 0 {
+		go srv.reconcilePeriodically(ctx)
+	}
+
+	lis, err := net.Listen("tcp", ":"+config.GRPC.Port)
+	if err != nil {
+		return err
+	}
+	grpcSrv := newGRPCServer(srv)
+	defer grpcSrv.Stop()
 
-	return http.ListenAndServe(":"+config.Listen.Port, srv)
+	// Whichever API stops first stops the service.
+	errs := make(chan error, 2)
+	go func() { errs <- grpcSrv.Serve(lis) }()
+	go func() { errs <- http.ListenAndServe(":"+config.Listen.Port, srv) }()
+	return <-errs
 }
 
 func newDB(databaseURL string) (*sql.DB, error) {
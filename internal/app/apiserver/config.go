@@ -4,6 +4,7 @@ import (
 	"github.com/ilyakaznacheev/cleanenv"
 	"log"
 	"sync"
+	"time"
 )
 
 type Config struct {
@@ -14,7 +15,74 @@ type Config struct {
 		BindIP string `yaml:"bind_ip" env-default:"127.0.0.1"`
 		Port   string `yaml:"port" env-default:"8080"`
 	} `yaml:"listen"`
-	DatabaseURL string `yaml:"database_url"`
+	GRPC struct {
+		Port string `yaml:"port" env-default:"9090"`
+	} `yaml:"grpc"`
+	// Timeouts bound the time a request runs, queries included. Endpoints
+	// are keyed by route template or gRPC method name.
+	Timeouts struct {
+		Default   time.Duration            `yaml:"default" env-default:"30s"`
+		Endpoints map[string]time.Duration `yaml:"endpoints"`
+	} `yaml:"timeouts"`
+	DatabaseURL    string `yaml:"database_url"`
+	MigrateOnStart bool   `yaml:"migrate_on_start" env-default:"false"`
+	// A key still without a response after Lease, for example because the
+	// service stopped while the request ran, is taken as abandoned and the
+	// request is run again. Requests are canceled when their lease ends, and
+	// the lease has to be longer than every timeout.
+	Idempotency struct {
+		TTL             time.Duration `yaml:"ttl" env-default:"24h"`
+		Lease           time.Duration `yaml:"lease" env-default:"5m"`
+		CleanupInterval time.Duration `yaml:"cleanup_interval" env-default:"1h"`
+	} `yaml:"idempotency"`
+	Reservations struct {
+		SweepInterval time.Duration `yaml:"sweep_interval" env-default:"1m"`
+		SweepBatch    int           `yaml:"sweep_batch" env-default:"100"`
+	} `yaml:"reservations"`
+	Reconciliation struct {
+		Interval time.Duration `yaml:"interval" env-default:"0"`
+		Save     bool          `yaml:"save" env-default:"true"`
+	} `yaml:"reconciliation"`
+	Exchange struct {
+		RatesFile string        `yaml:"rates_file" env:"RATES_FILE"`
+		RatesURL  string        `yaml:"rates_url" env:"RATES_URL"`
+		CacheTTL  time.Duration `yaml:"cache_ttl" env-default:"10m"`
+		Spread    float64       `yaml:"spread" env-default:"0.01"`
+	} `yaml:"exchange"`
+	// Reports are saved by report jobs, and by /get_report if Save is set
+	// instead of being streamed, into Dir or, when Storage is s3, into a
+	// bucket of S3. Saved reports are served by /reports/{name}, through
+	// links valid for PresignTTL if the storage gives them, and deleted
+	// after Retention. The links point to S3.PublicEndpoint when clients
+	// reach S3 at another address than the service. Jobs are run by Workers
+	// that look for new ones every PollInterval, and a job running longer
+	// than JobTimeout is canceled and run again. CSV reports start with a
+	// byte order mark if CSVBOM is set and separate fields with
+	// CSVDelimiter, unless a request asks otherwise.
+	Reports struct {
+		Storage string `yaml:"storage" env:"REPORTS_STORAGE" env-default:"local"`
+		Dir     string `yaml:"dir" env:"REPORTS_DIR" env-default:"reports"`
+		S3      struct {
+			Endpoint       string `yaml:"endpoint" env:"S3_ENDPOINT"`
+			PublicEndpoint string `yaml:"public_endpoint" env:"S3_PUBLIC_ENDPOINT"`
+			Region         string `yaml:"region" env-default:"us-east-1"`
+			Bucket         string `yaml:"bucket" env:"S3_BUCKET"`
+			AccessKey      string `yaml:"access_key" env:"S3_ACCESS_KEY"`
+			SecretKey      string `yaml:"secret_key" env:"S3_SECRET_KEY"`
+		} `yaml:"s3"`
+		PresignTTL      time.Duration `yaml:"presign_ttl" env-default:"15m"`
+		Save            bool          `yaml:"save" env-default:"false"`
+		Retention       time.Duration `yaml:"retention" env-default:"168h"`
+		CleanupInterval time.Duration `yaml:"cleanup_interval" env-default:"1h"`
+		Workers         int           `yaml:"workers" env-default:"2"`
+		PollInterval    time.Duration `yaml:"poll_interval" env-default:"5s"`
+		JobTimeout      time.Duration `yaml:"job_timeout" env-default:"10m"`
+		CSVBOM          bool          `yaml:"csv_bom" env-default:"false"`
+		CSVDelimiter    string        `yaml:"csv_delimiter" env-default:","`
+	} `yaml:"reports"`
+	Admin struct {
+		Token string `yaml:"token" env:"ADMIN_TOKEN"`
+	} `yaml:"admin"`
 }
 
 type StorageConfig struct {
@@ -0,0 +1,151 @@
+package apiserver
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"user_balance_microservice/internal/app/model"
+	"user_balance_microservice/internal/app/store/teststore"
+)
+
+func TestServer_Wallets(t *testing.T) {
+	ctx := context.Background()
+	store := teststore.New()
+	createAccount(t, store, 1, 100)
+	s := newServer(store, testConfig())
+
+	testCases := []struct {
+		name         string
+		path         string
+		payload      interface{}
+		expectedCode int
+	}{
+		{
+			name:         "deposit in usd",
+			path:         "/account/add",
+			payload:      map[string]interface{}{"id": 1, "amount": 50, "currency": "USD"},
+			expectedCode: http.StatusOK,
+		}, {
+			name:         "unknown currency",
+			path:         "/account/add",
+			payload:      map[string]interface{}{"id": 1, "amount": 50, "currency": "EUR"},
+			expectedCode: http.StatusBadRequest,
+		}, {
+			name:         "reserve without wallet",
+			path:         "/reserve_money",
+			payload:      map[string]interface{}{"id": 1, "serviceId": 1, "orderId": 1, "amount": 10, "currency": "KZT"},
+			expectedCode: http.StatusUnprocessableEntity,
+		}, {
+			name:         "reserve more than the wallet has",
+			path:         "/reserve_money",
+			payload:      map[string]interface{}{"id": 1, "serviceId": 1, "orderId": 1, "amount": 60, "currency": "USD"},
+			expectedCode: http.StatusUnprocessableEntity,
+		}, {
+			name:         "reserve in usd",
+			path:         "/reserve_money",
+			payload:      map[string]interface{}{"id": 1, "serviceId": 1, "orderId": 1, "amount": 20, "currency": "USD"},
+			expectedCode: http.StatusOK,
+		}, {
+			name:         "confirm in another currency",
+			path:         "/confirm_reserve",
+			payload:      map[string]interface{}{"id": 1, "serviceId": 1, "orderId": 1, "amount": 20},
+			expectedCode: http.StatusUnprocessableEntity,
+		}, {
+			name:         "transfer in usd",
+			path:         "/account/transfer",
+			payload:      map[string]interface{}{"idFrom": 1, "idTo": 2, "amount": 10, "currency": "USD"},
+			expectedCode: http.StatusOK,
+		}, {
+			name:         "transfer without wallet",
+			path:         "/account/transfer",
+			payload:      map[string]interface{}{"idFrom": 2, "idTo": 1, "amount": 10},
+			expectedCode: http.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, newRequest(t, tc.path, tc.payload))
+			assert.Equal(t, tc.expectedCode, rec.Code, rec.Body.String())
+		})
+	}
+
+	rub, err := store.UserAccount().FindById(ctx, 1, "RUB")
+	assert.NoError(t, err)
+	assert.Equal(t, model.Amount(10000), rub.Balance)
+	usd, err := store.UserAccount().FindById(ctx, 1, "USD")
+	assert.NoError(t, err)
+	assert.Equal(t, model.Amount(2000), usd.Balance)
+	assert.Equal(t, model.Amount(2000), usd.Reserved_balance)
+
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/account/balance?id=1", nil)
+	s.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"id":1,"wallets":[{"currency":"RUB","balance":"100.00"},{"currency":"USD","balance":"20.00"}]}`, rec.Body.String())
+
+	history, err := store.Transaction().GetAccountReport(ctx, 1, "USD", "amount", "DESC", 1, 10)
+	assert.NoError(t, err)
+	assert.Len(t, *history, 2)
+	for _, record := range *history {
+		assert.Equal(t, "USD", record.Currency)
+	}
+}
+
+func TestServer_handleGetHistoryCurrency(t *testing.T) {
+	s := newServer(teststore.New(), testConfig())
+	s.ServeHTTP(httptest.NewRecorder(), newRequest(t, "/account/add", map[string]interface{}{
+		"id": 1, "amount": 100,
+	}))
+	s.ServeHTTP(httptest.NewRecorder(), newRequest(t, "/account/add", map[string]interface{}{
+		"id": 1, "amount": 30, "currency": "KZT",
+	}))
+
+	testCases := []struct {
+		name         string
+		payload      interface{}
+		expectedCode int
+		expected     []string
+	}{
+		{
+			name:         "all currencies",
+			payload:      map[string]interface{}{"id": 1, "ordering": "amount", "page": 1},
+			expectedCode: http.StatusOK,
+			expected:     []string{"KZT", "RUB"},
+		}, {
+			name:         "kzt",
+			payload:      map[string]interface{}{"id": 1, "ordering": "amount", "page": 1, "currency": "KZT"},
+			expectedCode: http.StatusOK,
+			expected:     []string{"KZT"},
+		}, {
+			name:         "unknown currency",
+			payload:      map[string]interface{}{"id": 1, "ordering": "amount", "page": 1, "currency": "EUR"},
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, newRequest(t, "/account/history", tc.payload))
+			assert.Equal(t, tc.expectedCode, rec.Code)
+			if tc.expectedCode != http.StatusOK {
+				return
+			}
+
+			history := []struct {
+				Currency string `json:"currency"`
+			}{}
+			assert.NoError(t, json.NewDecoder(rec.Body).Decode(&history))
+			currencies := []string{}
+			for _, record := range history {
+				currencies = append(currencies, record.Currency)
+			}
+			assert.Equal(t, tc.expected, currencies)
+		})
+	}
+}
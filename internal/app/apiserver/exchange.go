@@ -0,0 +1,27 @@
+package apiserver
+
+import (
+	"fmt"
+	"user_balance_microservice/internal/app/exchange"
+)
+
+// newRatesProvider returns the rates provider set up in the config: the rates
+// service if its URL is given, else the rates file. Without either of them
+// transfers between currencies are refused.
+func newRatesProvider(config *Config) (exchange.RatesProvider, error) {
+	if spread := config.Exchange.Spread; spread < 0 || spread >= 1 {
+		return nil, fmt.Errorf("exchange spread %g is out of [0, 1)", spread)
+	}
+
+	switch {
+	case config.Exchange.RatesURL != "":
+		return exchange.NewHTTPProvider(config.Exchange.RatesURL, config.Exchange.CacheTTL), nil
+	case config.Exchange.RatesFile != "":
+		provider, err := exchange.LoadFile(config.Exchange.RatesFile)
+		if err != nil {
+			return nil, err
+		}
+		return provider, nil
+	}
+	return nil, nil
+}
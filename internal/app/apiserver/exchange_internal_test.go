@@ -0,0 +1,153 @@
+package apiserver
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"user_balance_microservice/internal/app/exchange"
+	"user_balance_microservice/internal/app/model"
+	"user_balance_microservice/internal/app/store/teststore"
+)
+
+func TestServer_handleTransferConversion(t *testing.T) {
+	ctx := context.Background()
+	store := teststore.New()
+	createAccount(t, store, 1, 1000)
+	config := testConfig()
+	config.Exchange.Spread = 0.01
+	s := newServer(store, config)
+	s.setRates(exchange.NewStaticProvider(exchange.Rates{
+		Base:  "RUB",
+		Rates: map[string]float64{"KZT": 5.2},
+	}))
+
+	testCases := []struct {
+		name         string
+		payload      interface{}
+		expectedCode int
+	}{
+		{
+			name:         "rub to kzt",
+			payload:      map[string]interface{}{"idFrom": 1, "idTo": 2, "amount": 500, "toCurrency": "KZT"},
+			expectedCode: http.StatusOK,
+		}, {
+			name:         "own wallet",
+			payload:      map[string]interface{}{"idFrom": 1, "idTo": 1, "amount": 100, "toCurrency": "KZT"},
+			expectedCode: http.StatusOK,
+		}, {
+			name:         "no rate",
+			payload:      map[string]interface{}{"idFrom": 1, "idTo": 2, "amount": 100, "toCurrency": "USD"},
+			expectedCode: http.StatusUnprocessableEntity,
+		}, {
+			name:         "too small",
+			payload:      map[string]interface{}{"idFrom": 2, "idTo": 1, "amount": "0.01", "currency": "KZT", "toCurrency": "RUB"},
+			expectedCode: http.StatusUnprocessableEntity,
+		}, {
+			name:         "not enough money",
+			payload:      map[string]interface{}{"idFrom": 1, "idTo": 2, "amount": 1000, "toCurrency": "KZT"},
+			expectedCode: http.StatusUnprocessableEntity,
+		}, {
+			name:         "unknown currency",
+			payload:      map[string]interface{}{"idFrom": 1, "idTo": 2, "amount": 100, "toCurrency": "EUR"},
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, newRequest(t, "/account/transfer", tc.payload))
+			assert.Equal(t, tc.expectedCode, rec.Code, rec.Body.String())
+		})
+	}
+
+	for _, tc := range []struct {
+		wallet  model.Wallet
+		balance model.Amount
+	}{
+		{model.Wallet{User_id: 1, Currency: "RUB"}, 40000},
+		{model.Wallet{User_id: 1, Currency: "KZT"}, 51480},
+		{model.Wallet{User_id: 2, Currency: "KZT"}, 257400},
+	} {
+		account, err := store.UserAccount().FindById(ctx, tc.wallet.User_id, tc.wallet.Currency)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.balance, account.Balance, tc.wallet)
+	}
+
+	exchanged := map[string]model.Amount{
+		model.ExchangeLedgerAccount("RUB"): 60000,
+		model.ExchangeLedgerAccount("KZT"): -308880,
+	}
+	for account, expected := range exchanged {
+		balance, err := store.Ledger().Balance(ctx, account)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, balance, account)
+	}
+
+	history, err := store.Transaction().GetAccountReport(ctx, 2, "", "amount", "ASC", 1, 10)
+	assert.NoError(t, err)
+	assert.Len(t, *history, 1)
+	assert.Equal(t, model.Amount(257400), (*history)[0].Amount)
+	assert.Equal(t, &model.Conversion{
+		Id:            (*history)[0].Conversion.Id,
+		From_currency: "RUB",
+		From_amount:   50000,
+		To_currency:   "KZT",
+		To_amount:     257400,
+		Rate:          5.148,
+		Spread:        0.01,
+		Created_at:    (*history)[0].Conversion.Created_at,
+	}, (*history)[0].Conversion)
+
+	history, err = store.Transaction().GetAccountReport(ctx, 1, "RUB", "amount", "DESC", 1, 10)
+	assert.NoError(t, err)
+	assert.Len(t, *history, 2)
+	assert.Equal(t, model.Amount(50000), (*history)[0].Amount)
+	assert.Equal(t, model.Amount(257400), (*history)[0].Conversion.To_amount)
+}
+
+func TestServer_handleTransferConversionResponse(t *testing.T) {
+	store := teststore.New()
+	createAccount(t, store, 1, 1000)
+	s := newServer(store, testConfig())
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, newRequest(t, "/account/transfer", map[string]interface{}{
+		"idFrom": 1, "idTo": 2, "amount": 100, "toCurrency": "USD",
+	}))
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"base":"USD","rates":{"RUB":100}}`))
+	}))
+	s.setRates(exchange.NewHTTPProvider(stub.URL, time.Hour))
+
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, newRequest(t, "/account/transfer", map[string]interface{}{
+		"idFrom": 1, "idTo": 2, "amount": 500, "toCurrency": "USD",
+	}))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	res := struct {
+		Success    string `json:"success"`
+		Conversion struct {
+			To_amount string  `json:"toAmount"`
+			Rate      float64 `json:"rate"`
+		} `json:"conversion"`
+	}{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+	assert.Equal(t, "Transfer completed", res.Success)
+	assert.Equal(t, "5.00", res.Conversion.To_amount)
+	assert.Equal(t, 0.01, res.Conversion.Rate)
+
+	stub.Close()
+	s.setRates(exchange.NewHTTPProvider(stub.URL, time.Hour))
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, newRequest(t, "/account/transfer", map[string]interface{}{
+		"idFrom": 1, "idTo": 2, "amount": 100, "toCurrency": "USD",
+	}))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+}
@@ -0,0 +1,407 @@
+package apiserver
+
+import (
+	"context"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"net/http"
+	"time"
+	"user_balance_microservice/internal/app/balance"
+	"user_balance_microservice/internal/app/model"
+	"user_balance_microservice/pkg/balancepb"
+)
+
+// exportBatch is the number of history records read at once while a
+// history export is streamed. Every batch starts after the last record of
+// the previous one, so records added meanwhile don't shift the batches.
+const exportBatch = 100
+
+// grpcCodes maps the statuses of problems to the gRPC codes answered for
+// them.
+var grpcCodes = map[int]codes.Code{
+	http.StatusBadRequest:          codes.InvalidArgument,
+	http.StatusUnauthorized:        codes.Unauthenticated,
+	http.StatusNotFound:            codes.NotFound,
+	http.StatusConflict:            codes.AlreadyExists,
+	http.StatusUnprocessableEntity: codes.FailedPrecondition,
+	http.StatusServiceUnavailable:  codes.Unavailable,
+}
+
+// grpcServer serves the gRPC API with the balance service of the HTTP one.
+type grpcServer struct {
+	balancepb.UnimplementedBalanceServiceServer
+	s *server
+}
+
+func newGRPCServer(s *server) *grpc.Server {
+	srv := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(s.grpcUnaryErrors, s.grpcIdempotent, s.grpcTimeout),
+		grpc.StreamInterceptor(s.grpcStreamErrors),
+	)
+	balancepb.RegisterBalanceServiceServer(srv, &grpcServer{s: s})
+	return srv
+}
+
+func (s *server) grpcUnaryErrors(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	res, err := handler(ctx, req)
+	if err != nil {
+		return nil, s.grpcStatus(err, info.FullMethod)
+	}
+	return res, nil
+}
+
+func (s *server) grpcStreamErrors(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := handler(srv, stream); err != nil {
+		return s.grpcStatus(err, info.FullMethod)
+	}
+	return nil
+}
+
+// grpcStatus maps an error to a gRPC status the way problemFor maps it to a
+// problem. The error code is the reason of the ErrorInfo in the details, and
+// the invalid fields of a request are listed in a BadRequest.
+func (s *server) grpcStatus(err error, method string) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	return problemStatus(s.problemOf(err, method)).Err()
+}
+
+// problemStatus is the gRPC status answered for a problem.
+func problemStatus(p *problem) *status.Status {
+	code, ok := grpcCodes[p.Status]
+	switch {
+	case p.Code == codeRequestTimeout:
+		code = codes.DeadlineExceeded
+	case p.Code == codeRequestCanceled:
+		code = codes.Canceled
+	case !ok:
+		code = codes.Internal
+	}
+	st := status.New(code, p.Detail)
+	info := &errdetails.ErrorInfo{Reason: string(p.Code), Domain: "user-balance"}
+	if len(p.Fields) > 0 {
+		badRequest := &errdetails.BadRequest{}
+		for _, field := range p.Fields {
+			badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+				Field:       field.Field,
+				Description: field.Rule + ": " + field.Message,
+			})
+		}
+		if detailed, err := st.WithDetails(info, badRequest); err == nil {
+			return detailed
+		}
+	} else if detailed, err := st.WithDetails(info); err == nil {
+		return detailed
+	}
+	return st
+}
+
+func (g *grpcServer) GetBalance(ctx context.Context, req *balancepb.GetBalanceRequest) (*balancepb.GetBalanceResponse, error) {
+	v := &validator{}
+	v.required("user_id", int(req.UserId))
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+	accounts, err := g.s.service.Balance(ctx, int(req.UserId))
+	if err != nil {
+		return nil, err
+	}
+
+	res := &balancepb.GetBalanceResponse{UserId: req.UserId}
+	for _, account := range accounts {
+		res.Wallets = append(res.Wallets, moneyPb(model.Money{Amount: account.Balance, Currency: account.Currency}))
+	}
+	return res, nil
+}
+
+func (g *grpcServer) Deposit(ctx context.Context, req *balancepb.DepositRequest) (*balancepb.Transaction, error) {
+	v := &validator{}
+	v.required("user_id", int(req.UserId))
+	currency := v.currency("currency", req.Currency)
+	amount := v.amount("amount", model.Decimal(req.Amount), currency)
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+
+	_, transaction, err := g.s.service.Deposit(ctx, model.Wallet{User_id: int(req.UserId), Currency: currency}, amount)
+	if err != nil {
+		return nil, err
+	}
+	return transactionPb(transaction), nil
+}
+
+func (g *grpcServer) Reserve(ctx context.Context, req *balancepb.ReserveRequest) (*balancepb.Reservation, error) {
+	var ttl *int
+	if req.TtlSeconds != nil {
+		seconds := int(*req.TtlSeconds)
+		ttl = &seconds
+	}
+	var expiresAt *time.Time
+	if req.ExpiresAt != nil {
+		at := req.ExpiresAt.AsTime()
+		expiresAt = &at
+	}
+
+	v := &validator{}
+	v.required("user_id", int(req.UserId))
+	v.required("service_id", int(req.ServiceId))
+	currency := v.currency("currency", req.Currency)
+	amount := v.amount("amount", model.Decimal(req.Amount), currency)
+	expiresAt = v.expiry("ttl_seconds", ttl, "expires_at", expiresAt)
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+
+	_, reservation, err := g.s.service.Reserve(ctx, balance.ReserveRequest{
+		Wallet:     model.Wallet{User_id: int(req.UserId), Currency: currency},
+		Service_id: int(req.ServiceId),
+		Order_id:   int(req.OrderId),
+		Amount:     amount,
+		Expires_at: expiresAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reservationPb(newReservationView(reservation, time.Now())), nil
+}
+
+func (g *grpcServer) Confirm(ctx context.Context, req *balancepb.ConfirmRequest) (*balancepb.ConfirmResponse, error) {
+	reservation, charged, err := g.reservationAmount(ctx, req.ReservationId, "charged_amount", req.ChargedAmount)
+	if err != nil {
+		return nil, err
+	}
+	if err := g.s.service.Confirm(ctx, balance.ById(reservation.Id), charged); err != nil {
+		return nil, reservationByIdError(err)
+	}
+	if charged == nil {
+		charged = &reservation.Amount
+	}
+	return &balancepb.ConfirmResponse{
+		ReservationId: req.ReservationId,
+		ChargedAmount: moneyPb(model.Money{Amount: *charged, Currency: reservation.Currency}),
+	}, nil
+}
+
+func (g *grpcServer) Abort(ctx context.Context, req *balancepb.AbortRequest) (*balancepb.AbortResponse, error) {
+	reservation, released, err := g.reservationAmount(ctx, req.ReservationId, "released_amount", req.ReleasedAmount)
+	if err != nil {
+		return nil, err
+	}
+	if err := g.s.service.Abort(ctx, balance.ById(reservation.Id), released); err != nil {
+		return nil, reservationByIdError(err)
+	}
+	open := released != nil && *released < reservation.Amount
+	if released == nil {
+		released = &reservation.Amount
+	}
+	return &balancepb.AbortResponse{
+		ReservationId:  req.ReservationId,
+		ReleasedAmount: moneyPb(model.Money{Amount: *released, Currency: reservation.Currency}),
+		Open:           open,
+	}, nil
+}
+
+// reservationAmount is server.reservationAmount for an amount that is not
+// set when empty.
+func (g *grpcServer) reservationAmount(ctx context.Context, id int64, field, amount string) (*model.Transaction, *model.Amount, error) {
+	v := &validator{}
+	v.required("reservation_id", int(id))
+	if err := v.err(); err != nil {
+		return nil, nil, err
+	}
+	var d *model.Decimal
+	if amount != "" {
+		decimal := model.Decimal(amount)
+		d = &decimal
+	}
+	return g.s.reservationAmount(ctx, int(id), field, d)
+}
+
+func (g *grpcServer) Transfer(ctx context.Context, req *balancepb.TransferRequest) (*balancepb.TransferResponse, error) {
+	v := &validator{}
+	v.required("from_user_id", int(req.FromUserId))
+	v.required("to_user_id", int(req.ToUserId))
+	currency := v.currency("currency", req.Currency)
+	toCurrency := currency
+	if req.ToCurrency != "" {
+		toCurrency = v.currency("to_currency", req.ToCurrency)
+	}
+	amount := v.amount("amount", model.Decimal(req.Amount), currency)
+	v.check(req.FromUserId != req.ToUserId || toCurrency != currency, "to_user_id", "distinct", "have to differ from from_user_id unless to_currency is another currency")
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+
+	res, err := g.s.service.Transfer(ctx,
+		model.Wallet{User_id: int(req.FromUserId), Currency: currency},
+		model.Wallet{User_id: int(req.ToUserId), Currency: toCurrency},
+		amount)
+	if err != nil {
+		return nil, err
+	}
+	return &balancepb.TransferResponse{
+		Out:        transactionPb(res.Out),
+		In:         transactionPb(res.In),
+		Conversion: conversionPb(res.Conversion),
+	}, nil
+}
+
+func (g *grpcServer) History(ctx context.Context, req *balancepb.HistoryRequest) (*balancepb.HistoryResponse, error) {
+	page, pageSize := int(req.Page), int(req.PageSize)
+	if page == 0 {
+		page = 1
+	}
+	if pageSize == 0 {
+		pageSize = defaultPageSize
+	}
+	v := &validator{}
+	validateHistory(v, req.UserId, req.Currency, req.Sort)
+	v.check(page > 0, "page", "positive", "have to be a positive page number")
+	v.check(pageSize > 0, "page_size", "positive", "have to be a positive number of records")
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+
+	records, err := g.s.service.History(ctx, balance.HistoryQuery{
+		User_id:  int(req.UserId),
+		Currency: req.Currency,
+		Sort:     req.Sort,
+		Page:     page,
+		PageSize: pageSize,
+	})
+	if err != nil {
+		return nil, err
+	}
+	res := &balancepb.HistoryResponse{}
+	for _, record := range *records {
+		res.Records = append(res.Records, historyRecordPb(record))
+	}
+	return res, nil
+}
+
+func (g *grpcServer) ExportHistory(req *balancepb.ExportHistoryRequest, stream balancepb.BalanceService_ExportHistoryServer) error {
+	v := &validator{}
+	validateHistory(v, req.UserId, req.Currency, req.Sort)
+	if err := v.err(); err != nil {
+		return err
+	}
+
+	var after *model.AccountTransaction
+	for {
+		if err := stream.Context().Err(); err != nil {
+			return status.FromContextError(err).Err()
+		}
+		records, err := g.s.service.History(stream.Context(), balance.HistoryQuery{
+			User_id:  int(req.UserId),
+			Currency: req.Currency,
+			Sort:     req.Sort,
+			Page:     1,
+			PageSize: exportBatch,
+			After:    after,
+		})
+		if err != nil {
+			return err
+		}
+		for _, record := range *records {
+			if err := stream.Send(historyRecordPb(record)); err != nil {
+				return err
+			}
+		}
+		if len(*records) < exportBatch {
+			return nil
+		}
+		after = &(*records)[len(*records)-1]
+	}
+}
+
+func validateHistory(v *validator, userId int64, currency, sort string) {
+	v.required("user_id", int(userId))
+	if currency != "" {
+		v.currency("currency", currency)
+	}
+	v.check(sort == "" || sort == "amount" || sort == "-amount" || sort == "date" || sort == "-date",
+		"sort", "enum", "have to be one of amount, -amount, date, -date")
+}
+
+func (g *grpcServer) MonthlyReport(ctx context.Context, req *balancepb.MonthlyReportRequest) (*balancepb.MonthlyReportResponse, error) {
+	v := &validator{}
+	v.check(req.Month >= 1 && req.Month <= 12, "month", "range", "have to be from 1 to 12")
+	v.check(req.Year > 0, "year", "required", "have to be a positive year")
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+
+	report, err := g.s.service.MonthlyReport(ctx, int(req.Month), int(req.Year))
+	if err != nil {
+		return nil, err
+	}
+	res := &balancepb.MonthlyReportResponse{}
+	for _, revenue := range report {
+		res.Services = append(res.Services, &balancepb.ServiceRevenue{
+			Service: revenue.Service,
+			Amount:  moneyPb(model.Money{Amount: revenue.Amount, Currency: revenue.Currency}),
+		})
+	}
+	return res, nil
+}
+
+func moneyPb(money model.Money) *balancepb.Money {
+	return &balancepb.Money{Amount: money.String(), Currency: money.Currency}
+}
+
+func timestampPb(t *time.Time) *timestamppb.Timestamp {
+	if t == nil || t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(*t)
+}
+
+func transactionPb(transaction *model.Transaction) *balancepb.Transaction {
+	return &balancepb.Transaction{
+		Id:          int64(transaction.Id),
+		UserId:      int64(transaction.User_id),
+		Amount:      moneyPb(model.Money{Amount: transaction.Amount, Currency: transaction.Currency}),
+		Description: transaction.Description,
+		CreatedAt:   timestampPb(&transaction.Created_at),
+	}
+}
+
+func reservationPb(view *reservationView) *balancepb.Reservation {
+	return &balancepb.Reservation{
+		Id:         int64(view.Id),
+		UserId:     int64(view.User_id),
+		ServiceId:  int64(view.Service_id),
+		OrderId:    int64(view.Order_id),
+		Amount:     moneyPb(view.Amount),
+		CreatedAt:  timestampPb(&view.Created_at),
+		ExpiresAt:  timestampPb(view.Expires_at),
+		AgeSeconds: view.Age,
+	}
+}
+
+func conversionPb(conversion *model.Conversion) *balancepb.Conversion {
+	if conversion == nil {
+		return nil
+	}
+	return &balancepb.Conversion{
+		From:   moneyPb(model.Money{Amount: conversion.From_amount, Currency: conversion.From_currency}),
+		To:     moneyPb(model.Money{Amount: conversion.To_amount, Currency: conversion.To_currency}),
+		Rate:   conversion.Rate,
+		Spread: conversion.Spread,
+	}
+}
+
+func historyRecordPb(record model.AccountTransaction) *balancepb.HistoryRecord {
+	return &balancepb.HistoryRecord{
+		Amount:      moneyPb(model.Money{Amount: record.Amount, Currency: record.Currency}),
+		Description: record.Description,
+		OrderId:     int64(record.Order_id),
+		Service:     record.Service,
+		ClosedAt:    timestampPb(&record.Closed_date),
+		Conversion:  conversionPb(record.Conversion),
+	}
+}
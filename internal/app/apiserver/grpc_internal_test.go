@@ -0,0 +1,181 @@
+package apiserver
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"io"
+	"net"
+	"testing"
+	"user_balance_microservice/internal/app/model"
+	"user_balance_microservice/internal/app/store/teststore"
+	"user_balance_microservice/pkg/balancepb"
+)
+
+func TestGRPCServer(t *testing.T) {
+	store := teststore.New()
+	createAccount(t, store, 1, 100)
+	client := newGRPCClient(t, newServer(store, testConfig()))
+	ctx := context.Background()
+
+	deposit, err := client.Deposit(ctx, &balancepb.DepositRequest{UserId: 1, Amount: "50"})
+	assert.NoError(t, err)
+	assert.Equal(t, "50.00", deposit.GetAmount().GetAmount())
+
+	reservation, err := client.Reserve(ctx, &balancepb.ReserveRequest{UserId: 1, ServiceId: 1, OrderId: 1, Amount: "30"})
+	assert.NoError(t, err)
+	assert.NotZero(t, reservation.GetId())
+	assert.Equal(t, "30.00", reservation.GetAmount().GetAmount())
+
+	confirmed, err := client.Confirm(ctx, &balancepb.ConfirmRequest{ReservationId: reservation.GetId(), ChargedAmount: "20"})
+	assert.NoError(t, err)
+	assert.Equal(t, "20.00", confirmed.GetChargedAmount().GetAmount())
+
+	_, err = client.Confirm(ctx, &balancepb.ConfirmRequest{ReservationId: reservation.GetId()})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	balance, err := client.GetBalance(ctx, &balancepb.GetBalanceRequest{UserId: 1})
+	assert.NoError(t, err)
+	if assert.Len(t, balance.GetWallets(), 1) {
+		// 100 + 50 deposited - 20 charged
+		assert.Equal(t, "130.00", balance.GetWallets()[0].GetAmount())
+	}
+	account, _ := store.UserAccount().FindById(ctx, 1, "RUB")
+	assert.Equal(t, model.Amount(13000), account.Balance)
+	assert.Equal(t, model.Amount(0), account.Reserved_balance)
+}
+
+func TestGRPCServer_errors(t *testing.T) {
+	store := teststore.New()
+	createAccount(t, store, 1, 100)
+	client := newGRPCClient(t, newServer(store, testConfig()))
+	ctx := context.Background()
+
+	_, err := client.Deposit(ctx, &balancepb.DepositRequest{UserId: 1, Amount: "-5", Currency: "XXX"})
+	st := status.Convert(err)
+	assert.Equal(t, codes.InvalidArgument, st.Code())
+	fields := []string{}
+	for _, detail := range st.Details() {
+		if badRequest, ok := detail.(*errdetails.BadRequest); ok {
+			for _, violation := range badRequest.GetFieldViolations() {
+				fields = append(fields, violation.GetField())
+			}
+		}
+	}
+	assert.ElementsMatch(t, []string{"currency", "amount"}, fields)
+	assert.Equal(t, string(codeValidationFailed), errorReason(st))
+
+	_, err = client.Reserve(ctx, &balancepb.ReserveRequest{UserId: 1, ServiceId: 1, OrderId: 1, Amount: "500"})
+	st = status.Convert(err)
+	assert.Equal(t, codes.FailedPrecondition, st.Code())
+	assert.Equal(t, string(codeInsufficientFunds), errorReason(st))
+
+	_, err = client.MonthlyReport(ctx, &balancepb.MonthlyReportRequest{Month: 13, Year: 2022})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestGRPCServer_idempotent(t *testing.T) {
+	store := teststore.New()
+	createAccount(t, store, 1, 100)
+	client := newGRPCClient(t, newServer(store, testConfig()))
+	ctx := metadata.AppendToOutgoingContext(context.Background(), idempotencyKeyMetadata, "key-1")
+
+	deposit, err := client.Deposit(ctx, &balancepb.DepositRequest{UserId: 1, Amount: "50"})
+	assert.NoError(t, err)
+	var header metadata.MD
+	replayed, err := client.Deposit(ctx, &balancepb.DepositRequest{UserId: 1, Amount: "50"}, grpc.Header(&header))
+	assert.NoError(t, err)
+	assert.Equal(t, deposit.GetId(), replayed.GetId())
+	assert.Equal(t, []string{"true"}, header.Get("idempotent-replayed"))
+
+	_, err = client.Deposit(ctx, &balancepb.DepositRequest{UserId: 1, Amount: "60"})
+	st := status.Convert(err)
+	assert.Equal(t, codes.AlreadyExists, st.Code())
+	assert.Equal(t, string(codeIdempotencyKeyReused), errorReason(st))
+
+	// Client errors are stored too.
+	ctx = metadata.AppendToOutgoingContext(context.Background(), idempotencyKeyMetadata, "key-2")
+	_, err = client.Reserve(ctx, &balancepb.ReserveRequest{UserId: 1, ServiceId: 1, OrderId: 1, Amount: "500"})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	_, err = client.Deposit(context.Background(), &balancepb.DepositRequest{UserId: 1, Amount: "1000"})
+	assert.NoError(t, err)
+	_, err = client.Reserve(ctx, &balancepb.ReserveRequest{UserId: 1, ServiceId: 1, OrderId: 1, Amount: "500"})
+	st = status.Convert(err)
+	assert.Equal(t, codes.FailedPrecondition, st.Code())
+	assert.Equal(t, string(codeInsufficientFunds), errorReason(st))
+
+	account, _ := store.UserAccount().FindById(context.Background(), 1, "RUB")
+	// 100 + 50 once + 1000
+	assert.Equal(t, model.Amount(115000), account.Balance)
+}
+
+func TestGRPCServer_ExportHistory(t *testing.T) {
+	store := teststore.New()
+	createAccount(t, store, 1, 1000)
+	client := newGRPCClient(t, newServer(store, testConfig()))
+	ctx := context.Background()
+
+	// Spans several pages of the export.
+	deposits := exportBatch + 5
+	for i := 0; i < deposits; i++ {
+		_, err := client.Deposit(ctx, &balancepb.DepositRequest{UserId: 1, Amount: "1"})
+		assert.NoError(t, err)
+	}
+
+	stream, err := client.ExportHistory(ctx, &balancepb.ExportHistoryRequest{UserId: 1, Sort: "-date"})
+	assert.NoError(t, err)
+	count := 0
+	for {
+		_, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if !assert.NoError(t, err) {
+			break
+		}
+		count++
+	}
+	assert.Equal(t, deposits, count)
+
+	stream, err = client.ExportHistory(ctx, &balancepb.ExportHistoryRequest{UserId: 2})
+	assert.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
+
+// newGRPCClient serves the gRPC API of s over an in-memory connection.
+func newGRPCClient(t *testing.T, s *server) balancepb.BalanceServiceClient {
+	t.Helper()
+
+	lis := bufconn.Listen(1 << 20)
+	srv := newGRPCServer(s)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return balancepb.NewBalanceServiceClient(conn)
+}
+
+func errorReason(st *status.Status) string {
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok {
+			return info.GetReason()
+		}
+	}
+	return ""
+}
@@ -0,0 +1,340 @@
+package apiserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"io"
+	"net/http"
+	"time"
+	"user_balance_microservice/internal/app/model"
+	"user_balance_microservice/internal/app/store"
+	"user_balance_microservice/pkg/balancepb"
+)
+
+const (
+	idempotencyKeyHeader = "Idempotency-Key"
+	// idempotencyKeyMetadata is the metadata key of the idempotency key of a
+	// gRPC call.
+	idempotencyKeyMetadata = "idempotency-key"
+)
+
+// idempotentMethods are the gRPC methods that move money and so accept an
+// idempotency key.
+var idempotentMethods = map[string]bool{
+	balancepb.BalanceService_Deposit_FullMethodName:  true,
+	balancepb.BalanceService_Reserve_FullMethodName:  true,
+	balancepb.BalanceService_Confirm_FullMethodName:  true,
+	balancepb.BalanceService_Abort_FullMethodName:    true,
+	balancepb.BalanceService_Transfer_FullMethodName: true,
+}
+
+var (
+	errIdempotencyKeyReused = &handlerError{http.StatusConflict, codeIdempotencyKeyReused, "Idempotency key was already used for another request"}
+	errRequestInProgress    = &handlerError{http.StatusConflict, codeRequestInProgress, "Request with this idempotency key is in progress"}
+)
+
+// idempotent makes a money-moving handler safe to retry. The first request
+// with a given Idempotency-Key is processed and its response is stored, a
+// replay of the same request gets the stored response back and the same key
+// with another request is rejected with 409, as is a replay while the first
+// request still runs.
+func (s *server) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if err := validateIdempotencyKey(idempotencyKeyHeader, key); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			s.fail(w, r, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		record := &model.IdempotencyKey{
+			Key:          key,
+			Request_hash: requestHash(r, body),
+		}
+		ctx, cancel := s.leaseContext(r.Context())
+		defer cancel()
+		stored, err := s.acquireIdempotencyKey(ctx, record)
+		if err != nil {
+			s.fail(w, r, err)
+			return
+		}
+		if stored != nil {
+			w.Header().Set("Idempotent-Replayed", "true")
+			if stored.Status_code >= http.StatusBadRequest {
+				w.Header().Set("Content-Type", "application/problem+json")
+			} else {
+				w.Header().Set("Content-Type", "application/json")
+			}
+			w.WriteHeader(stored.Status_code)
+			w.Write(stored.Response)
+			return
+		}
+
+		// A key left without a response would answer every retry with 409
+		// until its lease ends, so it is released unless the response is
+		// stored, also when the handler panics.
+		saved := false
+		defer func() {
+			if !saved {
+				s.releaseIdempotencyKey(record)
+			}
+		}()
+
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(rec, r.WithContext(ctx))
+
+		// Server errors are not stored, so the client can retry them.
+		if rec.status >= http.StatusInternalServerError {
+			return
+		}
+		record.Status_code = rec.status
+		record.Response = rec.body.Bytes()
+		saved = s.saveIdempotentResponse(record)
+	}
+}
+
+// grpcIdempotent is idempotent for the gRPC methods that move money, with
+// the key in the idempotency-key metadata. The response or the status of a
+// client error is stored and a replay gets it back with the
+// idempotent-replayed header set.
+func (s *server) grpcIdempotent(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	keys := md.Get(idempotencyKeyMetadata)
+	if !idempotentMethods[info.FullMethod] || len(keys) == 0 || keys[0] == "" {
+		return handler(ctx, req)
+	}
+	key := keys[0]
+	if err := validateIdempotencyKey(idempotencyKeyMetadata, key); err != nil {
+		return nil, err
+	}
+
+	body, err := proto.MarshalOptions{Deterministic: true}.Marshal(req.(proto.Message))
+	if err != nil {
+		return nil, err
+	}
+	record := &model.IdempotencyKey{
+		Key:          key,
+		Request_hash: hashRequest(info.FullMethod, body),
+	}
+	ctx, cancel := s.leaseContext(ctx)
+	defer cancel()
+	stored, err := s.acquireIdempotencyKey(ctx, record)
+	if err != nil {
+		return nil, err
+	}
+	if stored != nil {
+		grpc.SetHeader(ctx, metadata.Pairs("idempotent-replayed", "true"))
+		return replayGRPC(stored)
+	}
+
+	saved := false
+	defer func() {
+		if !saved {
+			s.releaseIdempotencyKey(record)
+		}
+	}()
+
+	res, err := handler(ctx, req)
+	if err != nil {
+		if _, ok := status.FromError(err); ok {
+			return nil, err
+		}
+		p := s.problemOf(err, info.FullMethod)
+		st := problemStatus(p)
+		// Server errors are not stored, so the client can retry them.
+		if p.Status >= http.StatusInternalServerError {
+			return nil, st.Err()
+		}
+		if record.Response, err = proto.Marshal(st.Proto()); err == nil {
+			record.Status_code = p.Status
+			saved = s.saveIdempotentResponse(record)
+		}
+		return nil, st.Err()
+	}
+
+	response, err := anypb.New(res.(proto.Message))
+	if err == nil {
+		record.Response, err = proto.Marshal(response)
+	}
+	if err != nil {
+		s.logger.Errorf("idempotency key %q: %v", record.Key, err)
+		return res, nil
+	}
+	record.Status_code = http.StatusOK
+	saved = s.saveIdempotentResponse(record)
+	return res, nil
+}
+
+// replayGRPC answers a gRPC call with the response or the status stored
+// under its idempotency key.
+func replayGRPC(stored *model.IdempotencyKey) (interface{}, error) {
+	if stored.Status_code >= http.StatusBadRequest {
+		st := &spb.Status{}
+		if err := proto.Unmarshal(stored.Response, st); err != nil {
+			return nil, err
+		}
+		return nil, status.FromProto(st).Err()
+	}
+	response := &anypb.Any{}
+	if err := proto.Unmarshal(stored.Response, response); err != nil {
+		return nil, err
+	}
+	return response.UnmarshalNew()
+}
+
+func validateIdempotencyKey(field, key string) error {
+	v := &validator{}
+	v.check(len(key) <= 255, field, "max", "can't be longer than 255 characters")
+	return v.err()
+}
+
+// leaseContext bounds a request by the lease of its idempotency key: once
+// the lease ends a retry may take the key over, so the request must not
+// commit anything after that.
+func (s *server) leaseContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, s.config.Idempotency.Lease)
+}
+
+// acquireIdempotencyKey takes the key of record for the request, creating
+// it or taking over one that expired or was abandoned. It returns the stored
+// key if the request was already answered, and nil if the request has to be
+// run.
+func (s *server) acquireIdempotencyKey(ctx context.Context, record *model.IdempotencyKey) (*model.IdempotencyKey, error) {
+	owner := make([]byte, 16)
+	if _, err := rand.Read(owner); err != nil {
+		return nil, err
+	}
+	record.Owner = hex.EncodeToString(owner)
+
+	now := time.Now()
+	expired := now.Add(-s.config.Idempotency.TTL)
+	abandoned := now.Add(-s.config.Idempotency.Lease)
+	stored, err := s.store.IdempotencyKey().FindByKey(ctx, record.Key)
+	switch {
+	case err == sql.ErrNoRows:
+		err = s.store.IdempotencyKey().Create(ctx, record)
+	case err != nil:
+		return nil, err
+	case stored.Created_at.Before(expired):
+		err = s.store.IdempotencyKey().TakeOver(ctx, record, expired, abandoned)
+	case stored.Request_hash != record.Request_hash:
+		return nil, errIdempotencyKeyReused
+	case stored.Status_code != 0:
+		return stored, nil
+	case stored.Created_at.Before(abandoned):
+		err = s.store.IdempotencyKey().TakeOver(ctx, record, expired, abandoned)
+	default:
+		return nil, errRequestInProgress
+	}
+	// Another request created or took over the key meanwhile.
+	if err == store.RecordExists {
+		return nil, errRequestInProgress
+	}
+	return nil, err
+}
+
+// saveIdempotentResponse stores the response of the request holding the key
+// and tells if it was stored. The request may be past its deadline by now,
+// so its context is not used.
+func (s *server) saveIdempotentResponse(record *model.IdempotencyKey) bool {
+	if err := s.store.IdempotencyKey().SaveResponse(context.Background(), record); err != nil {
+		s.logger.Errorf("idempotency key %q: %v", record.Key, err)
+		return false
+	}
+	return true
+}
+
+// releaseIdempotencyKey deletes the key of a request that got no response
+// to store, unless a retry took it over.
+func (s *server) releaseIdempotencyKey(record *model.IdempotencyKey) {
+	if err := s.store.IdempotencyKey().Release(context.Background(), record); err != nil {
+		s.logger.Errorf("idempotency key %q: %v", record.Key, err)
+	}
+}
+
+// checkIdempotencyLease makes sure that requests time out before the leases
+// of their idempotency keys end, so that a retry never takes over the key of
+// a request that still runs.
+func checkIdempotencyLease(config *Config) error {
+	longest := config.Timeouts.Default
+	for _, timeout := range config.Timeouts.Endpoints {
+		if timeout > longest {
+			longest = timeout
+		}
+	}
+	if config.Idempotency.Lease <= longest {
+		return fmt.Errorf("idempotency lease %s has to be longer than the longest request timeout %s", config.Idempotency.Lease, longest)
+	}
+	return nil
+}
+
+// cleanIdempotencyKeys periodically removes expired keys until ctx is done.
+func (s *server) cleanIdempotencyKeys(ctx context.Context) {
+	ticker := time.NewTicker(s.config.Idempotency.CleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := s.store.IdempotencyKey().DeleteExpired(ctx, time.Now().Add(-s.config.Idempotency.TTL))
+			if err != nil {
+				s.logger.Error(err)
+				continue
+			}
+			s.logger.Debugf("deleted %d expired idempotency keys", deleted)
+		}
+	}
+}
+
+func requestHash(r *http.Request, body []byte) string {
+	return hashRequest(r.Method+" "+r.URL.Path, body)
+}
+
+// hashRequest hashes the body of a request together with its target, so
+// that a key used for one endpoint or method can't be replayed on another.
+func hashRequest(target string, body []byte) string {
+	hash := sha256.New()
+	io.WriteString(hash, target+"\n")
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// responseRecorder passes the response through and keeps a copy of it.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
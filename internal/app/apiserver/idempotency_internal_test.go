@@ -0,0 +1,154 @@
+package apiserver
+
+import (
+	"context"
+	"database/sql"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"user_balance_microservice/internal/app/model"
+	"user_balance_microservice/internal/app/store/teststore"
+)
+
+func TestServer_idempotent(t *testing.T) {
+	ctx := context.Background()
+	store := teststore.New()
+	s := newServer(store, testConfig())
+
+	deposit := func(key string, amount int) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := newRequest(t, "/account/add", map[string]int{"id": 1, "amount": amount})
+		req.Header.Set(idempotencyKeyHeader, key)
+		s.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := deposit("key-1", 100)
+	assert.Equal(t, http.StatusOK, first.Code)
+
+	replay := deposit("key-1", 100)
+	assert.Equal(t, http.StatusOK, replay.Code)
+	assert.Equal(t, "true", replay.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, first.Body.String(), replay.Body.String())
+
+	assert.Equal(t, http.StatusConflict, deposit("key-1", 200).Code)
+	assert.Equal(t, http.StatusOK, deposit("key-2", 100).Code)
+
+	account, err := store.UserAccount().FindById(ctx, 1, "RUB")
+	assert.Nil(t, err)
+	assert.Equal(t, model.Amount(20000), account.Balance)
+}
+
+func TestServer_idempotentExpired(t *testing.T) {
+	ctx := context.Background()
+	store := teststore.New()
+	config := testConfig()
+	s := newServer(store, config)
+
+	assert.Nil(t, store.IdempotencyKey().Create(ctx, &model.IdempotencyKey{Key: "key-1", Request_hash: "other"}))
+
+	rec := httptest.NewRecorder()
+	req := newRequest(t, "/account/add", map[string]int{"id": 1, "amount": 100})
+	req.Header.Set(idempotencyKeyHeader, "key-1")
+	s.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	config.Idempotency.TTL = -time.Second
+	rec = httptest.NewRecorder()
+	req = newRequest(t, "/account/add", map[string]int{"id": 1, "amount": 100})
+	req.Header.Set(idempotencyKeyHeader, "key-1")
+	s.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	deleted, err := store.IdempotencyKey().DeleteExpired(ctx, time.Now().Add(time.Second))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, deleted)
+}
+
+func TestServer_idempotentAbandoned(t *testing.T) {
+	ctx := context.Background()
+	store := teststore.New()
+	config := testConfig()
+	s := newServer(store, config)
+
+	deposit := func() int {
+		rec := httptest.NewRecorder()
+		req := newRequest(t, "/account/add", map[string]int{"id": 1, "amount": 100})
+		req.Header.Set(idempotencyKeyHeader, "key-1")
+		s.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// The first request left the key without a response.
+	req := newRequest(t, "/account/add", map[string]int{"id": 1, "amount": 100})
+	assert.Nil(t, store.IdempotencyKey().Create(ctx, &model.IdempotencyKey{Key: "key-1", Request_hash: requestHash(req, []byte(`{"amount":100,"id":1}`+"\n")), Owner: "first"}))
+	assert.Equal(t, http.StatusConflict, deposit())
+
+	config.Idempotency.Lease = 50 * time.Millisecond
+	time.Sleep(config.Idempotency.Lease)
+	assert.Equal(t, http.StatusOK, deposit())
+	assert.Equal(t, http.StatusOK, deposit())
+
+	account, err := store.UserAccount().FindById(ctx, 1, "RUB")
+	assert.Nil(t, err)
+	assert.Equal(t, model.Amount(10000), account.Balance)
+}
+
+// A request whose key was taken over by a retry neither stores its response
+// nor releases the key of the retry.
+func TestServer_idempotentTakenOver(t *testing.T) {
+	ctx := context.Background()
+	store := teststore.New()
+	s := newServer(store, testConfig())
+
+	for _, status := range []int{http.StatusOK, http.StatusInternalServerError} {
+		handler := s.idempotent(func(w http.ResponseWriter, r *http.Request) {
+			stored, err := store.IdempotencyKey().FindByKey(ctx, "key-1")
+			assert.NoError(t, err)
+			retry := &model.IdempotencyKey{Key: "key-1", Request_hash: stored.Request_hash, Owner: "retry"}
+			later := time.Now().Add(time.Second)
+			assert.NoError(t, store.IdempotencyKey().TakeOver(ctx, retry, later, later))
+			assert.Error(t, store.IdempotencyKey().TakeOver(ctx, retry, time.Now().Add(-time.Hour), time.Now().Add(-time.Minute)))
+			w.WriteHeader(status)
+		})
+
+		req := newRequest(t, "/account/add", map[string]int{"id": 1, "amount": 100})
+		req.Header.Set(idempotencyKeyHeader, "key-1")
+		handler(httptest.NewRecorder(), req)
+
+		stored, err := store.IdempotencyKey().FindByKey(ctx, "key-1")
+		if assert.NoError(t, err) {
+			assert.Equal(t, "retry", stored.Owner)
+			assert.Equal(t, 0, stored.Status_code)
+		}
+		assert.NoError(t, store.IdempotencyKey().Release(ctx, stored))
+	}
+}
+
+func TestServer_idempotentPanic(t *testing.T) {
+	store := teststore.New()
+	s := newServer(store, testConfig())
+	handler := s.idempotent(func(w http.ResponseWriter, r *http.Request) {
+		panic("handler failed")
+	})
+
+	req := newRequest(t, "/account/add", map[string]int{"id": 1, "amount": 100})
+	req.Header.Set(idempotencyKeyHeader, "key-1")
+	assert.Panics(t, func() { handler(httptest.NewRecorder(), req) })
+
+	_, err := store.IdempotencyKey().FindByKey(context.Background(), "key-1")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func TestCheckIdempotencyLease(t *testing.T) {
+	config := testConfig()
+	config.Timeouts.Default = 30 * time.Second
+	config.Timeouts.Endpoints = map[string]time.Duration{"/get_report": 2 * time.Minute}
+
+	config.Idempotency.Lease = 2 * time.Minute
+	assert.Error(t, checkIdempotencyLease(config))
+	config.Idempotency.Lease = 5 * time.Minute
+	assert.NoError(t, checkIdempotencyLease(config))
+}
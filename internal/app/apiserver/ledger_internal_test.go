@@ -0,0 +1,77 @@
+package apiserver
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"user_balance_microservice/internal/app/model"
+	"user_balance_microservice/internal/app/store/teststore"
+)
+
+func TestServer_Ledger(t *testing.T) {
+	ctx := context.Background()
+	store := teststore.New()
+	s := newServer(store, testConfig())
+
+	requests := []struct {
+		path    string
+		payload interface{}
+	}{
+		{"/account/add", map[string]int{"id": 1, "amount": 200}},
+		{"/account/transfer", map[string]int{"idFrom": 1, "idTo": 2, "amount": 50}},
+		{"/reserve_money", map[string]int{"id": 1, "serviceId": 1, "orderId": 1, "amount": 100}},
+		{"/confirm_reserve", map[string]int{"id": 1, "serviceId": 1, "orderId": 1, "amount": 100, "chargedAmount": 70}},
+		{"/reserve_money", map[string]int{"id": 2, "serviceId": 2, "orderId": 2, "amount": 30}},
+		{"/abort_reserve", map[string]int{"id": 2, "serviceId": 2, "orderId": 2, "amount": 30, "releasedAmount": 10}},
+		{"/refund", map[string]int{"id": 1, "serviceId": 1, "orderId": 1, "amount": 20}},
+	}
+	for _, req := range requests {
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, newRequest(t, req.path, req.payload))
+		assert.Equal(t, http.StatusOK, rec.Code, req.path)
+	}
+
+	user1 := model.Wallet{User_id: 1, Currency: "RUB"}
+	user2 := model.Wallet{User_id: 2, Currency: "RUB"}
+	expected := map[string]model.Amount{
+		model.ExternalLedgerAccount("RUB"):   -20000,
+		model.UserLedgerAccount(user1):       10000,
+		model.ReservedLedgerAccount(user1):   0,
+		model.UserLedgerAccount(user2):       3000,
+		model.ReservedLedgerAccount(user2):   2000,
+		model.RevenueLedgerAccount(1, "RUB"): 5000,
+		model.RevenueLedgerAccount(2, "RUB"): 0,
+	}
+	for account, balance := range expected {
+		actual, err := store.Ledger().Balance(ctx, account)
+		assert.Nil(t, err)
+		assert.Equal(t, balance, actual, account)
+	}
+}
+
+func TestServer_LedgerMismatch(t *testing.T) {
+	ctx := context.Background()
+	store := teststore.New()
+	// The wallet has no opening entry, so its balance differs from the ledger.
+	assert.Nil(t, store.UserAccount().Create(ctx, &model.UserAccount{User_id: 1, Currency: "RUB", Balance: 10000}))
+	s := newServer(store, testConfig())
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, newRequest(t, "/account/add", map[string]int{"id": 1, "amount": 50}))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	discrepancies, err := s.reconcile(ctx, false)
+	assert.Nil(t, err)
+	ledger := []model.Discrepancy{}
+	for _, d := range discrepancies {
+		if d.Source == model.DiscrepancyLedger {
+			ledger = append(ledger, d)
+		}
+	}
+	if assert.Len(t, ledger, 1) {
+		assert.Equal(t, model.Amount(15000), ledger[0].Balance)
+		assert.Equal(t, model.Amount(5000), ledger[0].Expected_balance)
+	}
+}
@@ -0,0 +1,50 @@
+package apiserver
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// metrics is a minimal registry of counters exposed in the Prometheus text
+// format on /metrics.
+type metrics struct {
+	mu       sync.Mutex
+	counters []*counter
+}
+
+type counter struct {
+	name  string
+	help  string
+	value int64
+}
+
+func (m *metrics) newCounter(name, help string) *counter {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := &counter{name: name, help: help}
+	m.counters = append(m.counters, c)
+	return c
+}
+
+func (c *counter) Add(n int) {
+	atomic.AddInt64(&c.value, int64(n))
+}
+
+func (c *counter) Value() int {
+	return int(atomic.LoadInt64(&c.value))
+}
+
+func (s *server) handleMetrics() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.metrics.mu.Lock()
+		defer s.metrics.mu.Unlock()
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		for _, c := range s.metrics.counters {
+			fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", c.name, c.help, c.name, c.name, c.Value())
+		}
+	}
+}
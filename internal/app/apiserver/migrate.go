@@ -0,0 +1,64 @@
+package apiserver
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"user_balance_microservice/internal/app/migrate"
+)
+
+// Migrate runs a migrate subcommand against the configured database: up
+// applies the pending migrations, down reverts the last steps of them and
+// status lists them all.
+func Migrate(config *Config, out io.Writer, command string, steps int) error {
+	db, err := newDB(config.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	migrator, err := newMigrator(db)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	switch command {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		for _, m := range applied {
+			fmt.Fprintf(out, "applied %04d_%s\n", m.Version, m.Name)
+		}
+		return err
+	case "down":
+		reverted, err := migrator.Down(ctx, steps)
+		for _, m := range reverted {
+			fmt.Fprintf(out, "reverted %04d_%s\n", m.Version, m.Name)
+		}
+		return err
+	case "status":
+		status, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range status {
+			applied := "pending"
+			if s.Applied() {
+				applied = s.Applied_at.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(out, "%04d_%s\t%s\n", s.Version, s.Name, applied)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command %q, use up, down or status", command)
+	}
+}
+
+func newMigrator(db *sql.DB) (*migrate.Migrator, error) {
+	migrations, err := migrate.Load()
+	if err != nil {
+		return nil, err
+	}
+	return migrate.New(db, migrations), nil
+}
@@ -0,0 +1,177 @@
+package apiserver
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/lib/pq"
+	"net/http"
+	"strings"
+	"user_balance_microservice/internal/app/balance"
+	"user_balance_microservice/internal/app/store"
+)
+
+// errorCode is a stable machine-readable code of an error. Clients branch on
+// it, so a code is never renamed or reused for another condition.
+type errorCode string
+
+const (
+	codeValidationFailed     errorCode = "VALIDATION_FAILED"
+	codeInsufficientFunds    errorCode = "INSUFFICIENT_FUNDS"
+	codeAccountNotFound      errorCode = "ACCOUNT_NOT_FOUND"
+	codeAccountExists        errorCode = "ACCOUNT_EXISTS"
+	codeServiceNotFound      errorCode = "SERVICE_NOT_FOUND"
+	codeReservationNotFound  errorCode = "RESERVATION_NOT_FOUND"
+	codeDuplicateReservation errorCode = "DUPLICATE_RESERVATION"
+	codeChargeNotFound       errorCode = "CHARGE_NOT_FOUND"
+	codeAlreadyRefunded      errorCode = "ALREADY_REFUNDED"
+	codeRefundExceedsCharge  errorCode = "REFUND_EXCEEDS_CHARGE"
+	codeExchangeDisabled     errorCode = "EXCHANGE_DISABLED"
+	codeRateNotFound         errorCode = "RATE_NOT_FOUND"
+	codeAmountTooSmall       errorCode = "AMOUNT_TOO_SMALL"
+	codeRatesUnavailable     errorCode = "RATES_UNAVAILABLE"
+	codeBalanceOverflow      errorCode = "BALANCE_OVERFLOW"
+	codeReportEmpty          errorCode = "REPORT_EMPTY"
+	codeReportNotFound       errorCode = "REPORT_NOT_FOUND"
+	codeIdempotencyKeyReused errorCode = "IDEMPOTENCY_KEY_REUSED"
+	codeRequestInProgress    errorCode = "REQUEST_IN_PROGRESS"
+	codeRequestTimeout       errorCode = "REQUEST_TIMEOUT"
+	codeRequestCanceled      errorCode = "REQUEST_CANCELED"
+	codeUnauthorized         errorCode = "UNAUTHORIZED"
+	codeNotFound             errorCode = "NOT_FOUND"
+	codeNotAcceptable        errorCode = "NOT_ACCEPTABLE"
+	codeInternal             errorCode = "INTERNAL_ERROR"
+)
+
+// Postgres error codes of the constraint violations mapped to error codes.
+const (
+	pqCheckViolation      = "23514"
+	pqUniqueViolation     = "23505"
+	pqForeignKeyViolation = "23503"
+	pqQueryCanceled       = "57014"
+)
+
+// constraintCodes maps the constraints of the schema that a valid request
+// can still break to the error codes they mean.
+var constraintCodes = map[string]*handlerError{
+	"user_accounts_balance_check":          {http.StatusUnprocessableEntity, codeInsufficientFunds, "Not enough money on the balance"},
+	"user_accounts_reserved_balance_check": {http.StatusUnprocessableEntity, codeInsufficientFunds, "Not enough money in the reserve"},
+	"user_accounts_pkey":                   {http.StatusConflict, codeAccountExists, "Wallet already exists"},
+	"transactions_reserve_key":             {http.StatusConflict, codeDuplicateReservation, "Reservation with such data already exists"},
+	"transactions_account_fkey":            {http.StatusUnprocessableEntity, codeAccountNotFound, "No wallet of the user in this currency"},
+	"transactions_service_id_fkey":         {http.StatusUnprocessableEntity, codeServiceNotFound, "No service with such id"},
+}
+
+// balanceCodes maps the kinds of errors of the balance service to the
+// statuses and codes they are answered with. The message is the one of the
+// error.
+var balanceCodes = map[error]*handlerError{
+	balance.ErrAccountNotFound:     {http.StatusUnprocessableEntity, codeAccountNotFound, ""},
+	balance.ErrInsufficientFunds:   {http.StatusUnprocessableEntity, codeInsufficientFunds, ""},
+	balance.ErrReservationNotFound: {http.StatusUnprocessableEntity, codeReservationNotFound, ""},
+	balance.ErrExceedsReservation:  {http.StatusBadRequest, codeValidationFailed, ""},
+	balance.ErrChargeNotFound:      {http.StatusUnprocessableEntity, codeChargeNotFound, ""},
+	balance.ErrAlreadyRefunded:     {http.StatusUnprocessableEntity, codeAlreadyRefunded, ""},
+	balance.ErrRefundExceedsCharge: {http.StatusUnprocessableEntity, codeRefundExceedsCharge, ""},
+	balance.ErrExchangeDisabled:    {http.StatusUnprocessableEntity, codeExchangeDisabled, ""},
+	balance.ErrRateNotFound:        {http.StatusUnprocessableEntity, codeRateNotFound, ""},
+	balance.ErrAmountTooSmall:      {http.StatusUnprocessableEntity, codeAmountTooSmall, ""},
+	balance.ErrRatesUnavailable:    {http.StatusServiceUnavailable, codeRatesUnavailable, ""},
+	balance.ErrBalanceOverflow:     {http.StatusUnprocessableEntity, codeBalanceOverflow, ""},
+}
+
+// handlerError is returned from inside a transaction to roll it back and
+// answer with the given status, code and message.
+type handlerError struct {
+	status  int
+	code    errorCode
+	message string
+}
+
+func (e *handlerError) Error() string {
+	return e.message
+}
+
+// problem is an RFC 7807 problem details body. Code is the stable error
+// code, and Fields lists the invalid fields of a request that failed
+// validation.
+type problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Code     errorCode    `json:"code"`
+	Fields   []fieldError `json:"fields,omitempty"`
+}
+
+func newProblem(status int, code errorCode, detail string) *problem {
+	title := strings.ReplaceAll(strings.ToLower(string(code)), "_", " ")
+	return &problem{
+		Type:   "urn:user-balance:error:" + strings.ReplaceAll(strings.ToLower(string(code)), "_", "-"),
+		Title:  strings.ToUpper(title[:1]) + title[1:],
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// problemFor maps an error to the problem answered for it. Errors that mean
+// nothing to the client are logged and answered with 500, so that database
+// messages don't leak.
+func (s *server) problemFor(r *http.Request, err error) *problem {
+	p := s.problemOf(err, r.Method+" "+r.URL.Path)
+	p.Instance = r.URL.Path
+	return p
+}
+
+// problemOf is problemFor for any API; where names the request in the log.
+func (s *server) problemOf(err error, where string) *problem {
+	var (
+		validationErr *validationError
+		handlerErr    *handlerError
+		balanceErr    *balance.Error
+		pqErr         *pq.Error
+	)
+	switch {
+	case errors.As(err, &validationErr):
+		p := newProblem(http.StatusBadRequest, codeValidationFailed, "Request is invalid")
+		p.Fields = validationErr.Fields
+		return p
+	case errors.As(err, &handlerErr):
+		return newProblem(handlerErr.status, handlerErr.code, handlerErr.message)
+	case errors.As(err, &balanceErr) && balanceCodes[balanceErr.Kind] != nil:
+		if balanceErr.Err != nil {
+			s.logger.Errorf("%s: %v", where, balanceErr.Err)
+		}
+		code := balanceCodes[balanceErr.Kind]
+		return newProblem(code.status, code.code, balanceErr.Message)
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &pqErr) && pqErr.Code == pqQueryCanceled:
+		return newProblem(http.StatusServiceUnavailable, codeRequestTimeout, "Request took too long")
+	case errors.Is(err, context.Canceled):
+		return newProblem(http.StatusServiceUnavailable, codeRequestCanceled, "Request was canceled")
+	case errors.Is(err, sql.ErrNoRows), errors.Is(err, store.RecordNotFound):
+		return newProblem(http.StatusNotFound, codeNotFound, "Record not found")
+	case errors.As(err, &pqErr) && isConstraintViolation(pqErr):
+		if handlerErr, ok := constraintCodes[pqErr.Constraint]; ok {
+			return newProblem(handlerErr.status, handlerErr.code, handlerErr.message)
+		}
+	}
+	s.logger.Errorf("%s: %v", where, err)
+	return newProblem(http.StatusInternalServerError, codeInternal, "Internal server error")
+}
+
+func isConstraintViolation(err *pq.Error) bool {
+	switch err.Code {
+	case pqCheckViolation, pqUniqueViolation, pqForeignKeyViolation:
+		return true
+	}
+	return false
+}
+
+// fail answers with the problem the error maps to.
+func (s *server) fail(w http.ResponseWriter, r *http.Request, err error) {
+	p := s.problemFor(r, err)
+	w.Header().Set("Content-Type", "application/problem+json")
+	s.respond(w, r, p.Status, p)
+}
@@ -0,0 +1,134 @@
+package apiserver
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"user_balance_microservice/internal/app/store/teststore"
+)
+
+func TestServer_problems(t *testing.T) {
+	store := teststore.New()
+	createAccount(t, store, 1, 100)
+	s := newServer(store, testConfig())
+
+	reserve := map[string]int{"id": 1, "serviceId": 1, "orderId": 1, "amount": 10}
+	testCases := []struct {
+		name           string
+		path           string
+		payload        interface{}
+		expectedStatus int
+		expectedCode   errorCode
+	}{
+		{
+			name:           "reserve",
+			path:           "/reserve_money",
+			payload:        reserve,
+			expectedStatus: http.StatusOK,
+		}, {
+			name:           "duplicate reservation",
+			path:           "/reserve_money",
+			payload:        reserve,
+			expectedStatus: http.StatusConflict,
+			expectedCode:   codeDuplicateReservation,
+		}, {
+			name:           "insufficient funds",
+			path:           "/account/transfer",
+			payload:        map[string]int{"idFrom": 1, "idTo": 2, "amount": 1000},
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedCode:   codeInsufficientFunds,
+		}, {
+			name:           "account not found",
+			path:           "/account/history",
+			payload:        map[string]int{"id": 2, "page": 1},
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedCode:   codeAccountNotFound,
+		}, {
+			name:           "reservation not found",
+			path:           "/confirm_reserve",
+			payload:        map[string]int{"id": 1, "serviceId": 1, "orderId": 2, "amount": 10},
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedCode:   codeReservationNotFound,
+		}, {
+			name:           "validation",
+			path:           "/refund",
+			payload:        map[string]int{"id": 1, "orderId": 1},
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   codeValidationFailed,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, newRequest(t, tc.path, tc.payload))
+			assert.Equal(t, tc.expectedStatus, rec.Code)
+			if tc.expectedCode == "" {
+				return
+			}
+
+			assert.Equal(t, "application/problem+json", rec.Header().Get("Content-Type"))
+			res := &problem{}
+			assert.NoError(t, json.NewDecoder(rec.Body).Decode(res))
+			assert.Equal(t, tc.expectedCode, res.Code)
+			assert.Equal(t, tc.expectedStatus, res.Status)
+			assert.Equal(t, tc.path, res.Instance)
+			assert.NotEmpty(t, res.Type)
+			assert.NotEmpty(t, res.Title)
+		})
+	}
+}
+
+func TestServer_problemFor(t *testing.T) {
+	s := newServer(teststore.New(), testConfig())
+	r := httptest.NewRequest(http.MethodPost, "/account/add", nil)
+
+	testCases := []struct {
+		name           string
+		err            error
+		expectedStatus int
+		expectedCode   errorCode
+	}{
+		{
+			name:           "no rows",
+			err:            fmt.Errorf("find account: %w", sql.ErrNoRows),
+			expectedStatus: http.StatusNotFound,
+			expectedCode:   codeNotFound,
+		}, {
+			name:           "check violation",
+			err:            &pq.Error{Code: pqCheckViolation, Constraint: "user_accounts_balance_check"},
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedCode:   codeInsufficientFunds,
+		}, {
+			name:           "unknown service",
+			err:            &pq.Error{Code: pqForeignKeyViolation, Constraint: "transactions_service_id_fkey"},
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedCode:   codeServiceNotFound,
+		}, {
+			name:           "unmapped constraint",
+			err:            &pq.Error{Code: pqCheckViolation, Constraint: "transactions_type_check", Message: "secret"},
+			expectedStatus: http.StatusInternalServerError,
+			expectedCode:   codeInternal,
+		}, {
+			name:           "other",
+			err:            errors.New("secret"),
+			expectedStatus: http.StatusInternalServerError,
+			expectedCode:   codeInternal,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := s.problemFor(r, tc.err)
+			assert.Equal(t, tc.expectedStatus, p.Status)
+			assert.Equal(t, tc.expectedCode, p.Code)
+			assert.NotContains(t, p.Detail, "secret")
+		})
+	}
+}
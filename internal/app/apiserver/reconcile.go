@@ -0,0 +1,115 @@
+package apiserver
+
+import (
+	"context"
+	"crypto/subtle"
+	"io"
+	"net/http"
+	"time"
+	"user_balance_microservice/internal/app/model"
+	"user_balance_microservice/internal/app/reconcile"
+	"user_balance_microservice/internal/app/store/sqlstore"
+)
+
+// Reconcile runs a single reconciliation against the configured database and
+// writes the discrepancies to out.
+func Reconcile(config *Config, out io.Writer, format string, save bool) error {
+	if format != reconcile.FormatJSON && format != reconcile.FormatCSV {
+		return reconcile.ErrUnknownFormat
+	}
+
+	db, err := newDB(config.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	discrepancies, err := reconcile.Run(context.Background(), sqlstore.New(db), save)
+	if err != nil {
+		return err
+	}
+	return reconcile.Write(out, format, discrepancies)
+}
+
+// reconcilePeriodically reconciles the balances until ctx is done, logging
+// the accounts that drifted from their history or the ledger.
+func (s *server) reconcilePeriodically(ctx context.Context) {
+	ticker := time.NewTicker(s.config.Reconciliation.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.reconcile(ctx, s.config.Reconciliation.Save); err != nil {
+				s.logger.Error(err)
+			}
+		}
+	}
+}
+
+func (s *server) reconcile(ctx context.Context, save bool) ([]model.Discrepancy, error) {
+	discrepancies, err := reconcile.Run(ctx, s.store, save)
+	if err != nil {
+		return nil, err
+	}
+	s.discrepanciesFound.Add(len(discrepancies))
+	for _, d := range discrepancies {
+		s.logger.Warnf(
+			"%s balance of user %d differs from %s: %s/%s stored, %s/%s expected",
+			d.Currency, d.User_id, d.Source,
+			model.Money{Amount: d.Balance, Currency: d.Currency},
+			model.Money{Amount: d.Reserved_balance, Currency: d.Currency},
+			model.Money{Amount: d.Expected_balance, Currency: d.Currency},
+			model.Money{Amount: d.Expected_reserved, Currency: d.Currency},
+		)
+	}
+	return discrepancies, nil
+}
+
+// handleReconcile runs a reconciliation on demand. The format query parameter
+// selects json (the default) or csv, and save=true stores the discrepancies.
+func (s *server) handleReconcile() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = reconcile.FormatJSON
+		}
+		v := &validator{}
+		v.check(format == reconcile.FormatJSON || format == reconcile.FormatCSV, "format", "enum", "have to be json or csv")
+		if err := v.err(); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+
+		discrepancies, err := s.reconcile(r.Context(), r.URL.Query().Get("save") == "true")
+		if err != nil {
+			s.fail(w, r, err)
+			return
+		}
+
+		if format == reconcile.FormatCSV {
+			w.Header().Set("Content-Type", "text/csv")
+		} else {
+			w.Header().Set("Content-Type", "application/json")
+		}
+		if err := reconcile.Write(w, format, discrepancies); err != nil {
+			s.logger.Error(err)
+		}
+	}
+}
+
+// adminOnly requires the admin token as a bearer token. An empty token
+// matches nothing.
+func (s *server) adminOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := s.config.Admin.Token
+		expected := "Bearer " + token
+		if token == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(expected)) != 1 {
+			s.fail(w, r, &handlerError{http.StatusUnauthorized, codeUnauthorized, "Admin token required"})
+			return
+		}
+		next(w, r)
+	}
+}
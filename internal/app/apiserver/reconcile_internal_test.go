@@ -0,0 +1,83 @@
+package apiserver
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"user_balance_microservice/internal/app/model"
+	"user_balance_microservice/internal/app/store/teststore"
+)
+
+func TestServer_HandleReconcile(t *testing.T) {
+	ctx := context.Background()
+	store := teststore.New()
+	config := testConfig()
+	config.Admin.Token = "secret"
+	s := newServer(store, config)
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, newRequest(t, "/account/add", map[string]int{"id": 1, "amount": 100}))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Nil(t, store.UserAccount().Create(ctx, &model.UserAccount{User_id: 2, Currency: "RUB", Balance: 5050}))
+
+	testCases := []struct {
+		name         string
+		query        string
+		token        string
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:         "no token",
+			expectedCode: http.StatusUnauthorized,
+		}, {
+			name:         "wrong token",
+			token:        "Bearer wrong",
+			expectedCode: http.StatusUnauthorized,
+		}, {
+			name:         "unknown format",
+			query:        "?format=xml",
+			token:        "Bearer secret",
+			expectedCode: http.StatusBadRequest,
+		}, {
+			name:         "json",
+			token:        "Bearer secret",
+			expectedCode: http.StatusOK,
+			expectedBody: `[{"userId":2,"currency":"RUB","balance":"50.50","expectedBalance":"0.00",`,
+		}, {
+			name:         "csv",
+			query:        "?format=csv&save=true",
+			token:        "Bearer secret",
+			expectedCode: http.StatusOK,
+			expectedBody: "user_id,currency,balance,expected_balance,reserved_balance,expected_reserved,source,found_at\n2,RUB,50.50,0.00,0.00,0.00,history,",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := newRequest(t, "/admin/reconcile"+tc.query, nil)
+			if tc.token != "" {
+				req.Header.Set("Authorization", tc.token)
+			}
+			s.ServeHTTP(rec, req)
+			assert.Equal(t, tc.expectedCode, rec.Code)
+			assert.True(t, strings.HasPrefix(rec.Body.String(), tc.expectedBody), rec.Body.String())
+		})
+	}
+
+	// User 2 has neither history nor postings, so it differs from both.
+	assert.Len(t, store.Discrepancies(), 2)
+	assert.Equal(t, 4, s.discrepanciesFound.Value())
+}
+
+func TestServer_HandleReconcileWithoutToken(t *testing.T) {
+	s := newServer(teststore.New(), testConfig())
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, newRequest(t, "/admin/reconcile", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
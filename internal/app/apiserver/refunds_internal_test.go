@@ -0,0 +1,110 @@
+package apiserver
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"user_balance_microservice/internal/app/model"
+	"user_balance_microservice/internal/app/store/teststore"
+)
+
+func TestServer_HandleRefund(t *testing.T) {
+	ctx := context.Background()
+	store := teststore.New()
+	createAccount(t, store, 1, 100)
+	s := newServer(store, testConfig())
+
+	testCases := []struct {
+		name         string
+		path         string
+		payload      interface{}
+		expectedCode int
+	}{
+		{
+			name:         "nothing charged",
+			path:         "/refund",
+			payload:      map[string]int{"id": 1, "serviceId": 1, "orderId": 1},
+			expectedCode: http.StatusUnprocessableEntity,
+		}, {
+			name:         "reserve",
+			path:         "/reserve_money",
+			payload:      map[string]int{"id": 1, "serviceId": 1, "orderId": 1, "amount": 30},
+			expectedCode: http.StatusOK,
+		}, {
+			name:         "confirm",
+			path:         "/confirm_reserve",
+			payload:      map[string]int{"id": 1, "serviceId": 1, "orderId": 1, "amount": 30},
+			expectedCode: http.StatusOK,
+		}, {
+			name:         "reserve again",
+			path:         "/reserve_money",
+			payload:      map[string]int{"id": 1, "serviceId": 1, "orderId": 1, "amount": 50},
+			expectedCode: http.StatusOK,
+		}, {
+			name:         "confirm a part",
+			path:         "/confirm_reserve",
+			payload:      map[string]int{"id": 1, "serviceId": 1, "orderId": 1, "amount": 50, "chargedAmount": 40},
+			expectedCode: http.StatusOK,
+		}, {
+			name:         "refund nothing",
+			path:         "/refund",
+			payload:      map[string]int{"id": 1, "serviceId": 1, "orderId": 1, "amount": 0},
+			expectedCode: http.StatusBadRequest,
+		}, {
+			name:         "refund more than charged",
+			path:         "/refund",
+			payload:      map[string]int{"id": 1, "serviceId": 1, "orderId": 1, "amount": 71},
+			expectedCode: http.StatusUnprocessableEntity,
+		}, {
+			name:         "refund a part",
+			path:         "/refund",
+			payload:      map[string]int{"id": 1, "serviceId": 1, "orderId": 1, "amount": 50},
+			expectedCode: http.StatusOK,
+		}, {
+			name:         "refund more than left",
+			path:         "/refund",
+			payload:      map[string]int{"id": 1, "serviceId": 1, "orderId": 1, "amount": 21},
+			expectedCode: http.StatusUnprocessableEntity,
+		}, {
+			name:         "refund the rest",
+			path:         "/refund",
+			payload:      map[string]int{"id": 1, "serviceId": 1, "orderId": 1},
+			expectedCode: http.StatusOK,
+		}, {
+			name:         "refund again",
+			path:         "/refund",
+			payload:      map[string]int{"id": 1, "serviceId": 1, "orderId": 1},
+			expectedCode: http.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, newRequest(t, tc.path, tc.payload))
+			assert.Equal(t, tc.expectedCode, rec.Code)
+		})
+	}
+
+	account, _ := store.UserAccount().FindById(ctx, 1, "RUB")
+	assert.Equal(t, model.Amount(10000), account.Balance)
+	assert.Equal(t, model.Amount(0), account.Reserved_balance)
+
+	history, err := store.Transaction().GetAccountReport(ctx, 1, "", "closed_date", "ASC", 1, 10)
+	assert.Nil(t, err)
+	refunds := []model.Amount{}
+	for _, record := range *history {
+		if record.Description == "Возврат средств за услугу" {
+			refunds = append(refunds, record.Amount)
+		}
+	}
+	assert.Equal(t, []model.Amount{3000, 2000, 2000}, refunds)
+
+	now := time.Now()
+	report, err := store.Transaction().GetMonthReport(ctx, int(now.Month()), now.Year())
+	assert.Nil(t, err)
+	assert.Equal(t, []model.ServiceRevenue{{Service: "услуга 1", Currency: "RUB", Amount: 0}}, report)
+}
@@ -0,0 +1,218 @@
+package apiserver
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+	"sync"
+	"time"
+	"user_balance_microservice/internal/app/model"
+	"user_balance_microservice/internal/app/reports"
+)
+
+var errReportJobNotFound = &handlerError{http.StatusNotFound, codeReportNotFound, "No report job with such id"}
+
+// reportExpired is the status answered for a done job whose report is past
+// the retention and so deleted. It is never stored.
+const reportExpired = "expired"
+
+// reportJobError is the error of a failed step of a report job. Its reason
+// is stored in the job for the client, the cause is only logged.
+type reportJobError struct {
+	reason string
+	err    error
+}
+
+func (e *reportJobError) Error() string {
+	return e.reason + ": " + e.err.Error()
+}
+
+func (e *reportJobError) Unwrap() error {
+	return e.err
+}
+
+// reportJob is a report job as its status is answered. File is the link to
+// the report once the job is done.
+type reportJob struct {
+	Id          int        `json:"id"`
+	Status      string     `json:"status"`
+	From        string     `json:"from"`
+	To          string     `json:"to"`
+	GroupBy     []string   `json:"groupBy"`
+	Format      string     `json:"format"`
+	File        string     `json:"file,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	Created_at  time.Time  `json:"createdAt"`
+	Finished_at *time.Time `json:"finishedAt,omitempty"`
+}
+
+// newReportJob answers the job as expired, without the link, once its
+// report is older than the retention.
+func (s *server) newReportJob(job *model.ReportJob) *reportJob {
+	res := &reportJob{
+		Id:          job.Id,
+		Status:      job.Status,
+		From:        job.Query.From.Format("2006-01-02"),
+		To:          job.Query.To.AddDate(0, 0, -1).Format("2006-01-02"),
+		GroupBy:     job.Query.GroupBy,
+		Format:      job.Format.Name,
+		Error:       job.Error,
+		Created_at:  job.Created_at,
+		Finished_at: job.Finished_at,
+	}
+	retention := s.config.Reports.Retention
+	if job.Status == model.ReportDone && retention > 0 && job.Finished_at != nil && job.Finished_at.Before(time.Now().Add(-retention)) {
+		res.Status = reportExpired
+	} else if job.File != "" {
+		res.File = "/reports/" + job.File
+	}
+	return res
+}
+
+// handleCreateReportJob queues a report for the workers and answers with
+// the job, whose status is served by handleGetReportJob.
+func (s *server) handleCreateReportJob() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &reportRequest{}
+
+		if err := decode(r, req); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+		v := &validator{}
+		job := &model.ReportJob{Query: req.query(v), Format: req.format(v, s.config, model.FormatCSV)}
+		if err := v.err(); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+
+		if err := s.store.ReportJob().Create(r.Context(), job); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+		// A waiting worker takes the job at once, a busy one on its next
+		// look for jobs.
+		select {
+		case s.reportJobs <- struct{}{}:
+		default:
+		}
+		w.Header().Set("Location", fmt.Sprintf("/reports/%d", job.Id))
+		s.respond(w, r, http.StatusAccepted, s.newReportJob(job))
+	}
+}
+
+func (s *server) handleGetReportJob() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		v := &validator{}
+		id := v.id("id", mux.Vars(r)["id"])
+		if err := v.err(); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+
+		job, err := s.store.ReportJob().Find(r.Context(), id)
+		if errors.Is(err, sql.ErrNoRows) {
+			s.fail(w, r, errReportJobNotFound)
+			return
+		}
+		if err != nil {
+			s.fail(w, r, err)
+			return
+		}
+		s.respond(w, r, http.StatusOK, s.newReportJob(job))
+	}
+}
+
+// runReportJobs runs the report jobs with Reports.Workers workers until ctx
+// is done.
+func (s *server) runReportJobs(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < s.config.Reports.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.reportWorker(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+// reportWorker runs jobs until none is left, then waits for a new one.
+func (s *server) reportWorker(ctx context.Context) {
+	ticker := time.NewTicker(s.config.Reports.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			ran, err := s.runReportJob(ctx)
+			if err != nil {
+				s.logger.Error(err)
+			}
+			if !ran || ctx.Err() != nil {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.reportJobs:
+		}
+	}
+}
+
+// runReportJob claims a job, saves its report and records how it ended. It
+// returns false when there was no job to run. A job interrupted by ctx is
+// left running, to be run again once it times out.
+func (s *server) runReportJob(ctx context.Context) (bool, error) {
+	job, err := s.store.ReportJob().Claim(ctx, time.Now().Add(-s.config.Reports.JobTimeout))
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	jobCtx, cancel := context.WithTimeout(ctx, s.config.Reports.JobTimeout)
+	defer cancel()
+	file, err := s.generateReport(jobCtx, job)
+	if ctx.Err() != nil {
+		return true, nil
+	}
+	if err != nil {
+		s.reportJobsFailed.Add(1)
+		s.logger.Errorf("report job %d: %v", job.Id, err)
+		job.Status = model.ReportFailed
+		var jobErr *reportJobError
+		switch {
+		case errors.Is(jobCtx.Err(), context.DeadlineExceeded):
+			job.Error = "Report took longer than the job timeout"
+		case errors.As(err, &jobErr):
+			job.Error = jobErr.reason
+		default:
+			job.Error = "Report could not be generated"
+		}
+	} else {
+		job.Status = model.ReportDone
+		job.File = file
+	}
+	return true, s.store.ReportJob().Finish(ctx, job)
+}
+
+// generateReport saves the report of the job and returns the name of its
+// file.
+func (s *server) generateReport(ctx context.Context, job *model.ReportJob) (string, error) {
+	rows, err := s.service.Report(ctx, job.Query)
+	if err != nil {
+		return "", &reportJobError{"Report data could not be read", err}
+	}
+	file, err := s.saveReport(ctx, reportPrefix(job.Query), job.Format, &reports.Report{Query: job.Query, Rows: rows})
+	if err != nil {
+		return "", &reportJobError{"Report could not be saved to the storage", err}
+	}
+	return file, nil
+}
@@ -0,0 +1,147 @@
+package apiserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestServer_ReportJobs(t *testing.T) {
+	ctx := context.Background()
+	config := testConfig()
+	config.Reports.Dir = t.TempDir()
+	config.Reports.JobTimeout = time.Minute
+	s := newReportServer(t, config)
+	now := time.Now()
+
+	ran, err := s.runReportJob(ctx)
+	assert.NoError(t, err)
+	assert.False(t, ran)
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, newRequest(t, "/reports", map[string]int{"month": int(now.Month()), "year": now.Year()}))
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	job := &reportJob{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(job))
+	assert.Equal(t, fmt.Sprintf("/reports/%d", job.Id), rec.Header().Get("Location"))
+	assert.Equal(t, "pending", job.Status)
+	assert.Equal(t, []string{"service"}, job.GroupBy)
+
+	ran, err = s.runReportJob(ctx)
+	assert.NoError(t, err)
+	assert.True(t, ran)
+
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, newMethodRequest(t, http.MethodGet, fmt.Sprintf("/reports/%d", job.Id), nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	job = &reportJob{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(job))
+	assert.Equal(t, "done", job.Status)
+	assert.NotNil(t, job.Finished_at)
+
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, newMethodRequest(t, http.MethodGet, job.File, nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "service,currency,amount\nуслуга 1,RUB,60.00\n", rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, newMethodRequest(t, http.MethodGet, "/reports/100", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	// Past the retention the report is deleted, so the job loses its link.
+	s.config.Reports.Retention = time.Nanosecond
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, newMethodRequest(t, http.MethodGet, fmt.Sprintf("/reports/%d", job.Id), nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	expired := &reportJob{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(expired))
+	assert.Equal(t, "expired", expired.Status)
+	assert.Empty(t, expired.File)
+	s.config.Reports.Retention = 0
+
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, newRequest(t, "/reports", map[string]interface{}{"month": int(now.Month()), "year": now.Year(), "format": "xlsx"}))
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	job = &reportJob{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(job))
+	assert.Equal(t, "xlsx", job.Format)
+	_, err = s.runReportJob(ctx)
+	assert.NoError(t, err)
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, newMethodRequest(t, http.MethodGet, fmt.Sprintf("/reports/%d", job.Id), nil))
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(job))
+	assert.Equal(t, ".xlsx", filepath.Ext(job.File))
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, newMethodRequest(t, http.MethodGet, job.File, nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", rec.Header().Get("Content-Type"))
+
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, newRequest(t, "/reports", map[string]interface{}{"from": "2022-11-01", "groupBy": []string{"year"}}))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestServer_ReportJobFailed(t *testing.T) {
+	ctx := context.Background()
+	config := testConfig()
+	// The reports can't be saved into a file.
+	config.Reports.Dir = filepath.Join(t.TempDir(), "file")
+	assert.NoError(t, os.WriteFile(config.Reports.Dir, nil, 0644))
+	config.Reports.JobTimeout = time.Minute
+	s := newReportServer(t, config)
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, newRequest(t, "/reports", map[string]interface{}{"from": "2022-11-01", "to": "2022-11-30"}))
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	job := &reportJob{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(job))
+
+	ran, err := s.runReportJob(ctx)
+	assert.NoError(t, err)
+	assert.True(t, ran)
+
+	found, err := s.store.ReportJob().Find(ctx, job.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, "failed", found.Status)
+	assert.Equal(t, "Report could not be saved to the storage", found.Error)
+	assert.Empty(t, found.File)
+	assert.Equal(t, 1, s.reportJobsFailed.Value())
+}
+
+func TestServer_ReportWorkers(t *testing.T) {
+	config := testConfig()
+	config.Reports.Dir = t.TempDir()
+	config.Reports.Workers = 2
+	config.Reports.PollInterval = time.Hour
+	config.Reports.JobTimeout = time.Minute
+	s := newReportServer(t, config)
+	now := time.Now()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.runReportJobs(ctx)
+		close(done)
+	}()
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, newRequest(t, "/reports", map[string]int{"month": int(now.Month()), "year": now.Year()}))
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	job := &reportJob{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(job))
+
+	assert.Eventually(t, func() bool {
+		found, err := s.store.ReportJob().Find(context.Background(), job.Id)
+		return err == nil && found.Status == "done"
+	}, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	<-done
+}
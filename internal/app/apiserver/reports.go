@@ -0,0 +1,300 @@
+package apiserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"user_balance_microservice/internal/app/model"
+	"user_balance_microservice/internal/app/reports"
+)
+
+var errReportNotFound = &handlerError{http.StatusNotFound, codeReportNotFound, "No report with such name"}
+
+// reportName matches the names of the saved reports, so that a download can
+// not reach outside of the reports directory.
+var reportName = regexp.MustCompile(`^[0-9A-Za-z_-]+\.(csv|xlsx|json)$`)
+
+var errNotAcceptable = &handlerError{http.StatusNotAcceptable, codeNotAcceptable, "The report can only be given as text/csv, application/vnd.openxmlformats-officedocument.spreadsheetml.sheet or application/json"}
+
+// reportRequest asks for a report for a month or, when from or to are set,
+// for a range of days.
+type reportRequest struct {
+	Month   int      `json:"month"`
+	Year    int      `json:"year"`
+	From    string   `json:"from"`
+	To      string   `json:"to"`
+	GroupBy []string `json:"groupBy"`
+	formatRequest
+}
+
+func (req *reportRequest) query(v *validator) model.ReportQuery {
+	if req.From != "" || req.To != "" {
+		v.check(req.Month == 0 && req.Year == 0, "month", "exclusive", "can't be set together with from and to")
+		return v.reportQuery(req.From, req.To, req.GroupBy)
+	}
+	v.check(req.Month >= 1 && req.Month <= 12, "month", "range", "have to be from 1 to 12")
+	v.check(req.Year > 0, "year", "required", "have to be a positive year")
+	from := time.Date(req.Year, time.Month(req.Month), 1, 0, 0, 0, 0, time.UTC)
+	return model.ReportQuery{From: from, To: from.AddDate(0, 1, 0), GroupBy: v.groupBy(req.GroupBy)}
+}
+
+func (req *reportRequest) emptyMessage() string {
+	if req.From != "" || req.To != "" {
+		return "No data for this period"
+	}
+	return "No data for this month"
+}
+
+// formatRequest asks for the format of a report file. The CSV options not
+// given are taken from the config.
+type formatRequest struct {
+	Format    string  `json:"format"`
+	BOM       *bool   `json:"bom"`
+	Delimiter *string `json:"delimiter"`
+}
+
+// negotiate picks the format by the Accept header of the request, unless
+// one is asked for. It fails with errNotAcceptable if the header accepts
+// none of the formats.
+func (req *formatRequest) negotiate(r *http.Request, fallback string) error {
+	if req.Format != "" {
+		return nil
+	}
+	format, ok := negotiateFormat(r.Header.Get("Accept"), fallback)
+	if !ok {
+		return errNotAcceptable
+	}
+	req.Format = format
+	return nil
+}
+
+// format returns the format asked for, or fallback, and checks it.
+func (req *formatRequest) format(v *validator, config *Config, fallback string) model.ReportFormat {
+	format := model.ReportFormat{
+		Name:      req.Format,
+		BOM:       config.Reports.CSVBOM,
+		Delimiter: config.Reports.CSVDelimiter,
+	}
+	if format.Name == "" {
+		format.Name = fallback
+	}
+	if req.BOM != nil {
+		format.BOM = *req.BOM
+	}
+	if req.Delimiter != nil {
+		format.Delimiter = *req.Delimiter
+	}
+	return v.reportFormat(format)
+}
+
+// reportMediaTypes maps the media types of an Accept header to the report
+// formats they ask for.
+var reportMediaTypes = map[string]string{
+	"text/csv":         model.FormatCSV,
+	"application/json": model.FormatJSON,
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet": model.FormatXLSX,
+}
+
+// negotiateFormat returns the format of the highest quality in an Accept
+// header, the earliest one of equal quality. A range like text/* or */*
+// gives fallback if it covers it and the first format it covers
+// otherwise. An empty header accepts fallback.
+func negotiateFormat(accept, fallback string) (string, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return fallback, true
+	}
+	best, bestQuality := "", 0.0
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(mediaRange)
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			if quality, err = strconv.ParseFloat(q, 64); err != nil {
+				continue
+			}
+		}
+
+		format := reportMediaTypes[mediaType]
+		if strings.HasSuffix(mediaType, "/*") {
+			prefix := strings.TrimSuffix(mediaType, "*")
+			if mediaType == "*/*" {
+				prefix = ""
+			}
+			for _, candidate := range []string{fallback, model.FormatCSV, model.FormatXLSX, model.FormatJSON} {
+				if strings.HasPrefix(reports.ContentType(candidate), prefix) {
+					format = candidate
+					break
+				}
+			}
+		}
+		if format != "" && quality > bestQuality {
+			best, bestQuality = format, quality
+		}
+	}
+	return best, best != ""
+}
+
+// reportPrefix names the file of a report after its month, like
+// 11_2022_report, or after its first and last days.
+func reportPrefix(query model.ReportQuery) string {
+	if query.From.Day() == 1 && query.To.Equal(query.From.AddDate(0, 1, 0)) {
+		return fmt.Sprintf("%d_%d_report", query.From.Month(), query.From.Year())
+	}
+	return fmt.Sprintf("%s_%s_report", query.From.Format("2006-01-02"), query.To.AddDate(0, 0, -1).Format("2006-01-02"))
+}
+
+// streamReport answers with the report in the format, as an attachment
+// named after prefix unless it is JSON.
+func (s *server) streamReport(w http.ResponseWriter, r *http.Request, prefix string, format model.ReportFormat, report *reports.Report) {
+	w.Header().Set("Content-Type", reports.ContentType(format.Name))
+	if format.Name != model.FormatJSON {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": prefix + "." + format.Name}))
+	}
+	w.WriteHeader(http.StatusOK)
+	// The status is sent, so a failed write can only be logged.
+	if err := reports.Write(w, format, report); err != nil {
+		s.logger.Errorf("%s: write report: %v", r.URL.Path, err)
+	}
+}
+
+// saveReport stores the report in the format and returns the name of its
+// file, which starts with prefix. Every report gets a file of its own, so
+// reports for the same period requested at once do not overwrite each
+// other.
+func (s *server) saveReport(ctx context.Context, prefix string, format model.ReportFormat, report *reports.Report) (string, error) {
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("%s_%x.%s", prefix, suffix, format.Name)
+
+	b := &bytes.Buffer{}
+	if err := reports.Write(b, format, report); err != nil {
+		return "", err
+	}
+	return name, s.reportStorage.Put(ctx, name, b)
+}
+
+// handleDownloadReport answers with a report saved by saveReport, or sends
+// the client to download it right from the storage when it gives links.
+func (s *server) handleDownloadReport() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := mux.Vars(r)["name"]
+		if !reportName.MatchString(name) {
+			s.fail(w, r, errReportNotFound)
+			return
+		}
+		if s.config.Reports.PresignTTL > 0 {
+			link, err := s.reportStorage.URL(r.Context(), name, s.config.Reports.PresignTTL)
+			if err != nil {
+				s.fail(w, r, err)
+				return
+			}
+			if link != "" {
+				http.Redirect(w, r, link, http.StatusFound)
+				return
+			}
+		}
+
+		body, object, err := s.reportStorage.Get(r.Context(), name)
+		if errors.Is(err, reports.ErrNotFound) {
+			s.fail(w, r, errReportNotFound)
+			return
+		}
+		if err != nil {
+			s.fail(w, r, err)
+			return
+		}
+		defer body.Close()
+
+		w.Header().Set("Content-Type", reports.ContentType(strings.TrimPrefix(path.Ext(name), ".")))
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+		if object.Size >= 0 {
+			w.Header().Set("Content-Length", strconv.FormatInt(object.Size, 10))
+		}
+		if !object.Modified.IsZero() {
+			w.Header().Set("Last-Modified", object.Modified.UTC().Format(http.TimeFormat))
+		}
+		w.WriteHeader(http.StatusOK)
+		if _, err := io.Copy(w, body); err != nil {
+			s.logger.Errorf("%s: download report: %v", r.URL.Path, err)
+		}
+	}
+}
+
+// cleanReports periodically deletes the saved reports older than the
+// retention until ctx is done.
+func (s *server) cleanReports(ctx context.Context) {
+	ticker := time.NewTicker(s.config.Reports.CleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := s.deleteExpiredReports(ctx, time.Now().Add(-s.config.Reports.Retention))
+			if err != nil {
+				s.logger.Error(err)
+			}
+			s.logger.Debugf("deleted %d expired reports", deleted)
+		}
+	}
+}
+
+// deleteExpiredReports deletes the saved reports last modified before the
+// given time and returns how many were deleted.
+func (s *server) deleteExpiredReports(ctx context.Context, before time.Time) (int, error) {
+	objects, err := s.reportStorage.List(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, object := range objects {
+		if !reportName.MatchString(object.Name) || !object.Modified.Before(before) {
+			continue
+		}
+		if err := s.reportStorage.Delete(ctx, object.Name); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// newReportStorage returns the report storage set up in the config: a
+// bucket of an S3-compatible service or the reports directory.
+func newReportStorage(config *Config) (reports.ReportStorage, error) {
+	switch config.Reports.Storage {
+	case "s3":
+		s3 := config.Reports.S3
+		if s3.Endpoint == "" || s3.Bucket == "" {
+			return nil, fmt.Errorf("reports storage s3 needs an endpoint and a bucket")
+		}
+		return reports.NewS3Storage(reports.S3Config{
+			Endpoint:       s3.Endpoint,
+			PublicEndpoint: s3.PublicEndpoint,
+			Region:         s3.Region,
+			Bucket:         s3.Bucket,
+			AccessKey:      s3.AccessKey,
+			SecretKey:      s3.SecretKey,
+		}), nil
+	case "local", "":
+		return reports.NewLocalStorage(config.Reports.Dir), nil
+	}
+	return nil, fmt.Errorf("unknown reports storage %q", config.Reports.Storage)
+}
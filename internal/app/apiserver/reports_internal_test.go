@@ -0,0 +1,337 @@
+package apiserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+	"user_balance_microservice/internal/app/reports"
+	"user_balance_microservice/internal/app/store/teststore"
+)
+
+// newReportServer returns a server with 60 roubles charged for service 1
+// in the current month.
+func newReportServer(t *testing.T, config *Config) *server {
+	t.Helper()
+
+	store := teststore.New()
+	createAccount(t, store, 1, 100)
+	s := newServer(store, config)
+	for _, req := range []struct {
+		path    string
+		payload interface{}
+	}{
+		{"/reserve_money", map[string]int{"id": 1, "serviceId": 1, "orderId": 1, "amount": 60}},
+		{"/confirm_reserve", map[string]int{"id": 1, "serviceId": 1, "orderId": 1, "amount": 60}},
+	} {
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, newRequest(t, req.path, req.payload))
+		assert.Equal(t, http.StatusOK, rec.Code, req.path)
+	}
+	return s
+}
+
+func TestServer_HandleGetReport(t *testing.T) {
+	s := newReportServer(t, testConfig())
+	now := time.Now()
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, newRequest(t, "/get_report", map[string]int{"month": int(now.Month()), "year": now.Year()}))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/csv; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.Equal(t, fmt.Sprintf("attachment; filename=%d_%d_report.csv", now.Month(), now.Year()), rec.Header().Get("Content-Disposition"))
+	assert.Equal(t, "service,currency,amount\nуслуга 1,RUB,60.00\n", rec.Body.String())
+}
+
+func TestServer_ReportFormats(t *testing.T) {
+	s := newReportServer(t, testConfig())
+	now := time.Now()
+	month := map[string]interface{}{"month": int(now.Month()), "year": now.Year()}
+	with := func(fields map[string]interface{}) map[string]interface{} {
+		res := map[string]interface{}{"month": int(now.Month()), "year": now.Year()}
+		for name, value := range fields {
+			res[name] = value
+		}
+		return res
+	}
+	today := now.Format("2006-01-02")
+	revenue := fmt.Sprintf("/v2/reports/revenue?from=%s&to=%s", today, today)
+
+	testCases := []struct {
+		name                string
+		method              string
+		path                string
+		payload             interface{}
+		accept              string
+		expectedStatus      int
+		expectedType        string
+		expectedDisposition string
+		expectedBody        string
+	}{
+		{
+			name:                "csv for excel",
+			method:              http.MethodPost,
+			path:                "/get_report",
+			payload:             with(map[string]interface{}{"format": "csv", "bom": true, "delimiter": ";"}),
+			expectedStatus:      http.StatusOK,
+			expectedType:        "text/csv; charset=utf-8",
+			expectedDisposition: fmt.Sprintf("attachment; filename=%d_%d_report.csv", now.Month(), now.Year()),
+			expectedBody:        "\ufeffservice;currency;amount\nуслуга 1;RUB;60.00\n",
+		}, {
+			name:                "xlsx",
+			method:              http.MethodPost,
+			path:                "/get_report",
+			payload:             with(map[string]interface{}{"format": "xlsx"}),
+			expectedStatus:      http.StatusOK,
+			expectedType:        "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+			expectedDisposition: fmt.Sprintf("attachment; filename=%d_%d_report.xlsx", now.Month(), now.Year()),
+		}, {
+			name:           "json by accept",
+			method:         http.MethodPost,
+			path:           "/get_report",
+			payload:        month,
+			accept:         "application/json",
+			expectedStatus: http.StatusOK,
+			expectedType:   "application/json",
+		}, {
+			name:                "format over accept",
+			method:              http.MethodPost,
+			path:                "/get_report",
+			payload:             with(map[string]interface{}{"format": "csv"}),
+			accept:              "application/json",
+			expectedStatus:      http.StatusOK,
+			expectedType:        "text/csv; charset=utf-8",
+			expectedDisposition: fmt.Sprintf("attachment; filename=%d_%d_report.csv", now.Month(), now.Year()),
+		}, {
+			name:                "browser",
+			method:              http.MethodPost,
+			path:                "/get_report",
+			payload:             month,
+			accept:              "text/html,application/xhtml+xml,*/*;q=0.8",
+			expectedStatus:      http.StatusOK,
+			expectedType:        "text/csv; charset=utf-8",
+			expectedDisposition: fmt.Sprintf("attachment; filename=%d_%d_report.csv", now.Month(), now.Year()),
+		}, {
+			name:           "not acceptable",
+			method:         http.MethodPost,
+			path:           "/get_report",
+			payload:        month,
+			accept:         "application/pdf",
+			expectedStatus: http.StatusNotAcceptable,
+		}, {
+			name:           "unknown format",
+			method:         http.MethodPost,
+			path:           "/get_report",
+			payload:        with(map[string]interface{}{"format": "pdf"}),
+			expectedStatus: http.StatusBadRequest,
+		}, {
+			name:           "long delimiter",
+			method:         http.MethodPost,
+			path:           "/get_report",
+			payload:        with(map[string]interface{}{"delimiter": ";;"}),
+			expectedStatus: http.StatusBadRequest,
+		}, {
+			name:           "quote delimiter",
+			method:         http.MethodPost,
+			path:           "/get_report",
+			payload:        with(map[string]interface{}{"delimiter": `"`}),
+			expectedStatus: http.StatusBadRequest,
+		}, {
+			name:                "v2 csv",
+			method:              http.MethodGet,
+			path:                revenue + "&format=csv&delimiter=%09&bom=false",
+			expectedStatus:      http.StatusOK,
+			expectedType:        "text/csv; charset=utf-8",
+			expectedDisposition: fmt.Sprintf("attachment; filename=%s_%s_report.csv", today, today),
+			expectedBody:        "service\tcurrency\tamount\nуслуга 1\tRUB\t60.00\n",
+		}, {
+			name:                "v2 xlsx by accept",
+			method:              http.MethodGet,
+			path:                revenue,
+			accept:              "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+			expectedStatus:      http.StatusOK,
+			expectedType:        "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+			expectedDisposition: fmt.Sprintf("attachment; filename=%s_%s_report.xlsx", today, today),
+		}, {
+			name:           "v2 invalid bom",
+			method:         http.MethodGet,
+			path:           revenue + "&bom=maybe",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := newMethodRequest(t, tc.method, tc.path, tc.payload)
+			if tc.accept != "" {
+				req.Header.Set("Accept", tc.accept)
+			}
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, req)
+			assert.Equal(t, tc.expectedStatus, rec.Code)
+			if tc.expectedStatus != http.StatusOK {
+				return
+			}
+			assert.Equal(t, tc.expectedType, rec.Header().Get("Content-Type"))
+			assert.Equal(t, tc.expectedDisposition, rec.Header().Get("Content-Disposition"))
+			if tc.expectedBody != "" {
+				assert.Equal(t, tc.expectedBody, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestNegotiateFormat(t *testing.T) {
+	testCases := []struct {
+		accept   string
+		fallback string
+		expected string
+	}{
+		{"", "csv", "csv"},
+		{"*/*", "json", "json"},
+		{"text/csv", "json", "csv"},
+		{"application/json, text/csv", "csv", "json"},
+		{"application/json;q=0.5, text/csv", "json", "csv"},
+		{"text/*", "json", "csv"},
+		{"application/*", "json", "json"},
+		{"application/*", "csv", "xlsx"},
+		{"text/csv;q=0, application/json;q=0.1", "csv", "json"},
+		{"image/png", "csv", ""},
+		{"text/csv;q=0", "csv", ""},
+	}
+
+	for _, tc := range testCases {
+		format, ok := negotiateFormat(tc.accept, tc.fallback)
+		assert.Equal(t, tc.expected, format, tc.accept)
+		assert.Equal(t, tc.expected != "", ok, tc.accept)
+	}
+}
+
+func TestServer_RevenueReport(t *testing.T) {
+	s := newReportServer(t, testConfig())
+	today := time.Now().Format("2006-01-02")
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, newRequest(t, "/get_report", map[string]interface{}{"from": today, "to": today, "groupBy": []string{"day", "service", "user"}}))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, fmt.Sprintf("attachment; filename=%s_%s_report.csv", today, today), rec.Header().Get("Content-Disposition"))
+	assert.Equal(t, fmt.Sprintf("day,service,user,currency,amount\n%s,услуга 1,1,RUB,60.00\n", today), rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, newRequest(t, "/get_report", map[string]interface{}{"from": "2022-01-01", "to": "2022-03-31"}))
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, newMethodRequest(t, http.MethodGet, fmt.Sprintf("/v2/reports/revenue?from=%s&to=%s&groupBy=month,user", today, today), nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	res := &struct {
+		GroupBy []string `json:"groupBy"`
+		Rows    []struct {
+			User_id  int    `json:"userId"`
+			Period   string `json:"period"`
+			Currency string `json:"currency"`
+			Amount   string `json:"amount"`
+		} `json:"rows"`
+	}{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(res))
+	assert.Equal(t, []string{"month", "user"}, res.GroupBy)
+	assert.Len(t, res.Rows, 1)
+	assert.Equal(t, today[:8]+"01", res.Rows[0].Period)
+	assert.Equal(t, 1, res.Rows[0].User_id)
+	assert.Equal(t, "60.00", res.Rows[0].Amount)
+
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, newMethodRequest(t, http.MethodGet, "/v2/reports/revenue?from=2022-11-01", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestServer_SavedReports(t *testing.T) {
+	config := testConfig()
+	config.Reports.Dir = t.TempDir()
+	config.Reports.Save = true
+	s := newReportServer(t, config)
+	now := time.Now()
+
+	files := []string{}
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, newRequest(t, "/get_report", map[string]int{"month": int(now.Month()), "year": now.Year()}))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		res := map[string]string{}
+		assert.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+		files = append(files, res["file"])
+	}
+	assert.NotEqual(t, files[0], files[1])
+
+	for _, file := range files {
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, newMethodRequest(t, http.MethodGet, file, nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "text/csv; charset=utf-8", rec.Header().Get("Content-Type"))
+		assert.Contains(t, rec.Header().Get("Content-Disposition"), "attachment")
+		assert.Equal(t, "service,currency,amount\nуслуга 1,RUB,60.00\n", rec.Body.String())
+	}
+
+	for _, path := range []string{"/reports/unknown.csv", "/reports/config.yml", "/reports/.csv"} {
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, newMethodRequest(t, http.MethodGet, path, nil))
+		assert.Equal(t, http.StatusNotFound, rec.Code, path)
+	}
+
+	deleted, err := s.deleteExpiredReports(context.Background(), now.Add(-time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, deleted)
+	deleted, err = s.deleteExpiredReports(context.Background(), now.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+	entries, err := os.ReadDir(config.Reports.Dir)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, newMethodRequest(t, http.MethodGet, files[0], nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestServer_S3Reports(t *testing.T) {
+	config := testConfig()
+	config.Reports.Save = true
+	s := newReportServer(t, config)
+	s.reportStorage = reports.NewS3Storage(reports.TestS3(t))
+	now := time.Now()
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, newRequest(t, "/get_report", map[string]int{"month": int(now.Month()), "year": now.Year()}))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	res := map[string]string{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+
+	// Without links the report is proxied by the service.
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, newMethodRequest(t, http.MethodGet, res["file"], nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "service,currency,amount\nуслуга 1,RUB,60.00\n", rec.Body.String())
+
+	config.Reports.PresignTTL = time.Minute
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, newMethodRequest(t, http.MethodGet, res["file"], nil))
+	assert.Equal(t, http.StatusFound, rec.Code)
+	link := rec.Header().Get("Location")
+	assert.Contains(t, link, "X-Amz-Signature=")
+
+	download, err := http.Get(link)
+	assert.NoError(t, err)
+	defer download.Body.Close()
+	body, _ := io.ReadAll(download.Body)
+	assert.Equal(t, "service,currency,amount\nуслуга 1,RUB,60.00\n", string(body))
+
+	deleted, err := s.deleteExpiredReports(context.Background(), now.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+}
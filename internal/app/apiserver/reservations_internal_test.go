@@ -0,0 +1,131 @@
+package apiserver
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"user_balance_microservice/internal/app/model"
+	"user_balance_microservice/internal/app/store/teststore"
+)
+
+func TestServer_partialConfirm(t *testing.T) {
+	ctx := context.Background()
+	store := teststore.New()
+	createAccount(t, store, 1, 100)
+	s := newServer(store, testConfig())
+
+	testCases := []struct {
+		name         string
+		path         string
+		payload      interface{}
+		expectedCode int
+	}{
+		{
+			name:         "reserve",
+			path:         "/reserve_money",
+			payload:      map[string]int{"id": 1, "serviceId": 1, "orderId": 1, "amount": 100},
+			expectedCode: http.StatusOK,
+		}, {
+			name:         "charge more than reserved",
+			path:         "/confirm_reserve",
+			payload:      map[string]int{"id": 1, "serviceId": 1, "orderId": 1, "amount": 100, "chargedAmount": 101},
+			expectedCode: http.StatusBadRequest,
+		}, {
+			name:         "charge nothing",
+			path:         "/confirm_reserve",
+			payload:      map[string]int{"id": 1, "serviceId": 1, "orderId": 1, "amount": 100, "chargedAmount": 0},
+			expectedCode: http.StatusBadRequest,
+		}, {
+			name:         "charge a part",
+			path:         "/confirm_reserve",
+			payload:      map[string]int{"id": 1, "serviceId": 1, "orderId": 1, "amount": 100, "chargedAmount": 60},
+			expectedCode: http.StatusOK,
+		}, {
+			name:         "charge again",
+			path:         "/confirm_reserve",
+			payload:      map[string]int{"id": 1, "serviceId": 1, "orderId": 1, "amount": 100, "chargedAmount": 40},
+			expectedCode: http.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, newRequest(t, tc.path, tc.payload))
+			assert.Equal(t, tc.expectedCode, rec.Code)
+		})
+	}
+
+	account, _ := store.UserAccount().FindById(ctx, 1, "RUB")
+	assert.Equal(t, model.Amount(4000), account.Balance)
+	assert.Equal(t, model.Amount(0), account.Reserved_balance)
+
+	history, err := store.Transaction().GetAccountReport(ctx, 1, "", "amount", "DESC", 1, 10)
+	assert.Nil(t, err)
+	assert.Len(t, *history, 2)
+	assert.Equal(t, model.Amount(6000), (*history)[0].Amount)
+	assert.Equal(t, "Списание средств за услугу", (*history)[0].Description)
+	assert.Equal(t, model.Amount(4000), (*history)[1].Amount)
+	assert.Equal(t, "Возврат зарезервированных средств", (*history)[1].Description)
+
+	now := time.Now()
+	report, err := store.Transaction().GetMonthReport(ctx, int(now.Month()), now.Year())
+	assert.Nil(t, err)
+	assert.Equal(t, []model.ServiceRevenue{{Service: "услуга 1", Currency: "RUB", Amount: 6000}}, report)
+}
+
+func TestServer_partialAbort(t *testing.T) {
+	ctx := context.Background()
+	store := teststore.New()
+	createAccount(t, store, 1, 100)
+	s := newServer(store, testConfig())
+
+	testCases := []struct {
+		name         string
+		path         string
+		payload      interface{}
+		expectedCode int
+	}{
+		{
+			name:         "reserve",
+			path:         "/reserve_money",
+			payload:      map[string]int{"id": 1, "serviceId": 1, "orderId": 1, "amount": 50},
+			expectedCode: http.StatusOK,
+		}, {
+			name:         "release more than reserved",
+			path:         "/abort_reserve",
+			payload:      map[string]int{"id": 1, "serviceId": 1, "orderId": 1, "amount": 50, "releasedAmount": 51},
+			expectedCode: http.StatusBadRequest,
+		}, {
+			name:         "release a part",
+			path:         "/abort_reserve",
+			payload:      map[string]int{"id": 1, "serviceId": 1, "orderId": 1, "amount": 50, "releasedAmount": 20},
+			expectedCode: http.StatusOK,
+		}, {
+			name:         "old amount",
+			path:         "/confirm_reserve",
+			payload:      map[string]int{"id": 1, "serviceId": 1, "orderId": 1, "amount": 50},
+			expectedCode: http.StatusUnprocessableEntity,
+		}, {
+			name:         "confirm the rest",
+			path:         "/confirm_reserve",
+			payload:      map[string]int{"id": 1, "serviceId": 1, "orderId": 1, "amount": 30},
+			expectedCode: http.StatusOK,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, newRequest(t, tc.path, tc.payload))
+			assert.Equal(t, tc.expectedCode, rec.Code)
+		})
+	}
+
+	account, _ := store.UserAccount().FindById(ctx, 1, "RUB")
+	assert.Equal(t, model.Amount(7000), account.Balance)
+	assert.Equal(t, model.Amount(0), account.Reserved_balance)
+}
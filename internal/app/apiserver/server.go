@@ -1,449 +1,439 @@
 package apiserver
 
 import (
-	"encoding/csv"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
 	"net/http"
-	"os"
-	"strconv"
-	"strings"
 	"time"
+	"user_balance_microservice/internal/app/balance"
+	"user_balance_microservice/internal/app/exchange"
 	"user_balance_microservice/internal/app/model"
+	"user_balance_microservice/internal/app/reports"
 	"user_balance_microservice/internal/app/store"
 )
 
 type server struct {
-	router *mux.Router
-	logger *logrus.Logger
-	store  store.Store
+	router  *mux.Router
+	logger  *logrus.Logger
+	store   store.Store
+	config  *Config
+	metrics *metrics
+	service *balance.Service
+
+	reportStorage reports.ReportStorage
+	// reportJobs wakes a report worker when a job is queued.
+	reportJobs chan struct{}
+
+	reservationsSwept      *counter
+	reservationSweepErrors *counter
+	discrepanciesFound     *counter
+	reportJobsFailed       *counter
 }
 
-func newServer(store store.Store) *server {
+func newServer(store store.Store, config *Config) *server {
 	server := &server{
-		router: mux.NewRouter(),
-		logger: logrus.New(),
-		store:  store,
+		router:  mux.NewRouter(),
+		logger:  logrus.New(),
+		store:   store,
+		config:  config,
+		metrics: &metrics{},
+		service: balance.New(store, nil, config.Exchange.Spread),
+
+		reportStorage: reports.NewLocalStorage(config.Reports.Dir),
+		reportJobs:    make(chan struct{}, 1),
 	}
+	server.reservationsSwept = server.metrics.newCounter(
+		"user_balance_reservations_swept_total",
+		"Number of expired reservations aborted by the sweeper.",
+	)
+	server.reservationSweepErrors = server.metrics.newCounter(
+		"user_balance_reservation_sweep_errors_total",
+		"Number of errors while aborting expired reservations.",
+	)
+	server.discrepanciesFound = server.metrics.newCounter(
+		"user_balance_reconciliation_discrepancies_total",
+		"Number of accounts found by reconciliation to differ from their history.",
+	)
+	server.reportJobsFailed = server.metrics.newCounter(
+		"user_balance_report_jobs_failed_total",
+		"Number of report jobs that failed.",
+	)
 
 	server.configureRouter()
 	return server
 }
 
+// setRates makes transfers between currencies convert at the rates.
+func (s *server) setRates(rates exchange.RatesProvider) {
+	s.service = balance.New(s.store, rates, s.config.Exchange.Spread)
+}
+
 func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	s.router.ServeHTTP(w, r)
 }
 func (s *server) configureRouter() {
-	s.router.HandleFunc("/account/balance", s.getBalance()).Queries("id", "{[0-9]*?}").Methods("GET")
-	s.router.HandleFunc("/account/add", s.handleBalanceAdd()).Methods("POST")
-	s.router.HandleFunc("/reserve_money", s.handleReserveMoney()).Methods("POST")
-	s.router.HandleFunc("/confirm_reserve", s.handleConfirm()).Methods("POST")
-	s.router.HandleFunc("/abort_reserve", s.handleAbort()).Methods("POST")
-	s.router.HandleFunc("/get_report", s.handleGetReport()).Methods("POST")
-	s.router.HandleFunc("/account/transfer", s.handleTransfer()).Methods("POST")
-	s.router.HandleFunc("/account/history", s.handleGetHistory()).Methods("POST")
+	s.router.HandleFunc("/account/balance", s.deprecated("/v2/accounts/{id}", s.getBalance())).Queries("id", "{[0-9]*?}").Methods("GET")
+	s.router.HandleFunc("/account/add", s.deprecated("/v2/accounts/{id}/deposits", s.idempotent(s.handleBalanceAdd()))).Methods("POST")
+	s.router.HandleFunc("/reserve_money", s.deprecated("/v2/reservations", s.idempotent(s.handleReserveMoney()))).Methods("POST")
+	s.router.HandleFunc("/confirm_reserve", s.deprecated("/v2/reservations/{id}/confirm", s.idempotent(s.handleConfirm()))).Methods("POST")
+	s.router.HandleFunc("/abort_reserve", s.deprecated("/v2/reservations/{id}/abort", s.idempotent(s.handleAbort()))).Methods("POST")
+	s.router.HandleFunc("/refund", s.deprecated("/v2/refunds", s.idempotent(s.handleRefund()))).Methods("POST")
+	s.router.HandleFunc("/get_report", s.deprecated("/v2/reports/monthly", s.handleGetReport())).Methods("POST")
+	s.router.HandleFunc("/account/transfer", s.deprecated("/v2/transfers", s.idempotent(s.handleTransfer()))).Methods("POST")
+	s.router.HandleFunc("/account/history", s.deprecated("/v2/accounts/{id}/transactions", s.handleGetHistory())).Methods("POST")
+	s.router.HandleFunc("/reports", s.handleCreateReportJob()).Methods("POST")
+	s.router.HandleFunc("/reports/{id:[0-9]+}", s.handleGetReportJob()).Methods("GET")
+	s.router.HandleFunc("/reports/{name}", s.handleDownloadReport()).Methods("GET")
+	s.router.HandleFunc("/metrics", s.handleMetrics()).Methods("GET")
+	// Without a token the admin routes would be open to anyone, so they
+	// are left out.
+	if s.config.Admin.Token != "" {
+		s.router.HandleFunc("/admin/reconcile", s.adminOnly(s.handleReconcile())).Methods("POST")
+	}
+	s.configureRouterV2()
+	s.router.Use(s.withTimeout)
 }
 
 func (s *server) getBalance() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		v := r.URL.Query()
-		user_id_str := v.Get("id")
-		user_id, err := strconv.Atoi(user_id_str)
-		if err != nil {
-			s.respond(w, r, http.StatusBadRequest, map[string]string{"error": "User ID have to be a number"})
+		v := &validator{}
+		user_id := v.id("id", r.URL.Query().Get("id"))
+		if err := v.err(); err != nil {
+			s.fail(w, r, err)
 			return
 		}
-		account, err := s.store.UserAccount().FindById(user_id)
+		accounts, err := s.service.Balance(r.Context(), user_id)
 		if err != nil {
-			err_str := fmt.Sprintf("No user with id = %s", user_id_str)
-			s.respond(w, r, http.StatusUnprocessableEntity, map[string]string{"error": err_str})
+			s.fail(w, r, err)
 			return
 		}
-
-		s.respond(w, r, http.StatusOK, account)
+		s.respond(w, r, http.StatusOK, newAccountBalance(user_id, accounts))
 	}
 }
 
 func (s *server) handleBalanceAdd() http.HandlerFunc {
 	type request struct {
-		User_id int `json:"id"`
-		Amount  int `json:"amount"`
+		User_id  int           `json:"id"`
+		Amount   model.Decimal `json:"amount"`
+		Currency string        `json:"currency"`
 	}
 	return func(w http.ResponseWriter, r *http.Request) {
 		req := &request{}
 
-		tx, err := s.store.BeginTx()
-		if err != nil {
-			s.error(w, r, http.StatusBadRequest, err)
+		if err := decode(r, req); err != nil {
+			s.fail(w, r, err)
 			return
 		}
-
-		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
-			s.error(w, r, http.StatusBadRequest, err)
+		v := &validator{}
+		v.required("id", req.User_id)
+		currency := v.currency("currency", req.Currency)
+		amount := v.amount("amount", req.Amount, currency)
+		if err := v.err(); err != nil {
+			s.fail(w, r, err)
 			return
 		}
 
-		created := true
-		if _, err := s.store.UserAccount().FindById(req.User_id); err != nil {
-			created = false
-		}
-
-		account := &model.UserAccount{
-			User_id: req.User_id,
-			Balance: req.Amount,
-		}
-
-		transaction := &model.Transaction{
-			User_id:     req.User_id,
-			Amount:      req.Amount,
-			Description: "Пополнение счета",
-			Closed_date: time.Now(),
-			Success_flg: true,
-			Type:        "add",
-		}
-
-		if !created {
-			if err := s.store.UserAccount().Create(tx, account); err != nil {
-				tx.Rollback()
-				s.error(w, r, http.StatusUnprocessableEntity, err)
-				return
-			}
-		} else {
-			account, err = s.store.UserAccount().Add(tx, account)
-			if err != nil {
-				tx.Rollback()
-				s.error(w, r, http.StatusUnprocessableEntity, err)
-				return
-			}
-		}
-
-		if err := s.store.Transaction().CreateAddTransaction(tx, transaction); err != nil {
-			tx.Rollback()
-			s.error(w, r, http.StatusUnprocessableEntity, err)
+		account, _, err := s.service.Deposit(r.Context(), model.Wallet{User_id: req.User_id, Currency: currency}, amount)
+		if err != nil {
+			s.fail(w, r, err)
 			return
 		}
-		tx.Commit()
 		s.respond(w, r, http.StatusOK, account)
 	}
 }
 
 func (s *server) handleTransfer() http.HandlerFunc {
 	type request struct {
-		IdFrom int `json:"idFrom"`
-		IdTo   int `json:"idTo"`
-		Amount int `json:"amount"`
+		IdFrom     int           `json:"idFrom"`
+		IdTo       int           `json:"idTo"`
+		Amount     model.Decimal `json:"amount"`
+		Currency   string        `json:"currency"`
+		ToCurrency string        `json:"toCurrency"`
 	}
 	return func(w http.ResponseWriter, r *http.Request) {
 		req := &request{}
 
-		tx, err := s.store.BeginTx()
-		if err != nil {
-			s.error(w, r, http.StatusBadRequest, err)
-			return
-		}
-
-		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
-			s.error(w, r, http.StatusBadRequest, err)
+		if err := decode(r, req); err != nil {
+			s.fail(w, r, err)
 			return
 		}
-
-		accountFrom, err := s.store.UserAccount().FindById(req.IdFrom)
-		if err != nil {
-			err_str := fmt.Sprintf("No user with id = %d", req.IdFrom)
-			s.respond(w, r, http.StatusUnprocessableEntity, map[string]string{"error": err_str})
-			return
+		v := &validator{}
+		v.required("idFrom", req.IdFrom)
+		v.required("idTo", req.IdTo)
+		currency := v.currency("currency", req.Currency)
+		toCurrency := currency
+		if req.ToCurrency != "" {
+			toCurrency = v.currency("toCurrency", req.ToCurrency)
 		}
-
-		if accountFrom.Balance < req.Amount {
-			err_str := fmt.Sprintf("Not enough money for transfer. Current balance is %d", accountFrom.Balance)
-			s.respond(w, r, http.StatusUnprocessableEntity, map[string]string{"error": err_str})
+		amount := v.amount("amount", req.Amount, currency)
+		v.check(req.IdFrom != req.IdTo || toCurrency != currency, "idTo", "distinct", "have to differ from idFrom unless toCurrency is another currency")
+		if err := v.err(); err != nil {
+			s.fail(w, r, err)
 			return
 		}
+		from := model.Wallet{User_id: req.IdFrom, Currency: currency}
+		to := model.Wallet{User_id: req.IdTo, Currency: toCurrency}
 
-		transactionFrom := &model.Transaction{
-			User_id:     req.IdFrom,
-			Amount:      req.Amount,
-			Description: fmt.Sprintf("Перевод средств пользователю id=%d", req.IdTo),
-			Closed_date: time.Now(),
-			Success_flg: true,
-			Type:        "reserve",
-		}
-
-		transactionTo := &model.Transaction{
-			User_id:     req.IdTo,
-			Amount:      req.Amount,
-			Description: fmt.Sprintf("Перевод средств от пользователя id=%d", req.IdFrom),
-			Closed_date: time.Now(),
-			Success_flg: true,
-			Type:        "add",
-		}
-
-		created := true
-		if _, err := s.store.UserAccount().FindById(req.IdTo); err != nil {
-			created = false
-		}
-
-		if !created {
-			accountTo := &model.UserAccount{
-				User_id: req.IdTo,
-				Balance: 0,
-			}
-			if err := s.store.UserAccount().Create(tx, accountTo); err != nil {
-				tx.Rollback()
-				s.error(w, r, http.StatusUnprocessableEntity, err)
-				return
-			}
-		}
-		_, err = s.store.UserAccount().Transfer(tx, req.IdFrom, req.IdTo, req.Amount)
+		res, err := s.service.Transfer(r.Context(), from, to, amount)
 		if err != nil {
-			tx.Rollback()
-			s.error(w, r, http.StatusUnprocessableEntity, err)
+			s.fail(w, r, err)
 			return
 		}
-
-		if err := s.store.Transaction().CreateAddTransaction(tx, transactionTo); err != nil {
-			tx.Rollback()
-			s.error(w, r, http.StatusUnprocessableEntity, err)
+		if res.Conversion != nil {
+			s.respond(w, r, http.StatusOK, map[string]interface{}{"success": "Transfer completed", "conversion": res.Conversion})
 			return
 		}
-		if err := s.store.Transaction().CreateAddTransaction(tx, transactionFrom); err != nil {
-			tx.Rollback()
-			s.error(w, r, http.StatusUnprocessableEntity, err)
-			return
-		}
-		tx.Commit()
 		s.respond(w, r, http.StatusOK, map[string]string{"success": "Transfer completed"})
 	}
 }
 
 func (s *server) handleReserveMoney() http.HandlerFunc {
 	type request struct {
-		User_id    int `json:"id"`
-		Service_id int `json:"serviceId"`
-		Order_id   int `json:"orderId"`
-		Amount     int `json:"amount"`
+		User_id    int           `json:"id"`
+		Service_id int           `json:"serviceId"`
+		Order_id   int           `json:"orderId"`
+		Amount     model.Decimal `json:"amount"`
+		Currency   string        `json:"currency"`
+		Ttl        *int          `json:"ttl,omitempty"`
+		Expires_at *time.Time    `json:"expiresAt,omitempty"`
+	}
+	type response struct {
+		User_id        int         `json:"id"`
+		Currency       string      `json:"currency"`
+		Balance        model.Money `json:"balance"`
+		Reservation_id int         `json:"reservationId"`
 	}
 	return func(w http.ResponseWriter, r *http.Request) {
 		req := &request{}
 
-		tx, err := s.store.BeginTx()
-		if err != nil {
-			s.error(w, r, http.StatusBadRequest, err)
-			return
-		}
-
-		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
-			s.error(w, r, http.StatusBadRequest, err)
-			return
-		}
-
-		account, err := s.store.UserAccount().FindById(req.User_id)
-		if err != nil {
-			err_str := fmt.Sprintf("No user with id = %d", req.User_id)
-			s.respond(w, r, http.StatusUnprocessableEntity, map[string]string{"error": err_str})
+		if err := decode(r, req); err != nil {
+			s.fail(w, r, err)
 			return
 		}
-		if account.Balance < req.Amount {
-			err_str := fmt.Sprintf("Not enough money for reserve. Current balance is %d", account.Balance)
-			s.respond(w, r, http.StatusUnprocessableEntity, map[string]string{"error": err_str})
+		v := &validator{}
+		v.required("id", req.User_id)
+		v.required("serviceId", req.Service_id)
+		currency := v.currency("currency", req.Currency)
+		amount := v.amount("amount", req.Amount, currency)
+		expiresAt := v.expiry("ttl", req.Ttl, "expiresAt", req.Expires_at)
+		if err := v.err(); err != nil {
+			s.fail(w, r, err)
 			return
 		}
-		reserve := &model.UserAccount{
-			User_id: req.User_id,
-			Balance: req.Amount,
-		}
 
-		reserve, err = s.store.UserAccount().Reserve(tx, reserve)
+		account, transaction, err := s.service.Reserve(r.Context(), balance.ReserveRequest{
+			Wallet:     model.Wallet{User_id: req.User_id, Currency: currency},
+			Service_id: req.Service_id,
+			Order_id:   req.Order_id,
+			Amount:     amount,
+			Expires_at: expiresAt,
+		})
 		if err != nil {
-			tx.Rollback()
-			s.error(w, r, http.StatusUnprocessableEntity, err)
-			return
-		}
-
-		transaction := &model.Transaction{
-			User_id:     req.User_id,
-			Amount:      req.Amount,
-			Description: "Списание средств за услугу",
-			Service_id:  req.Service_id,
-			Order_id:    req.Order_id,
-			Type:        "reserve",
-		}
-		if err := s.store.Transaction().CreateReserveTransaction(tx, transaction); err != nil {
-			tx.Rollback()
-			s.error(w, r, http.StatusUnprocessableEntity, err)
+			s.fail(w, r, err)
 			return
 		}
-		tx.Commit()
-		s.respond(w, r, http.StatusOK, reserve)
+		s.respond(w, r, http.StatusOK, &response{
+			User_id:        req.User_id,
+			Currency:       currency,
+			Balance:        model.Money{Amount: account.Balance, Currency: currency},
+			Reservation_id: transaction.Id,
+		})
 	}
 }
 
 func (s *server) handleConfirm() http.HandlerFunc {
 	type request struct {
-		User_id    int `json:"id"`
-		Service_id int `json:"serviceId"`
-		Order_id   int `json:"orderId"`
-		Amount     int `json:"amount"`
+		User_id        int            `json:"id"`
+		Service_id     int            `json:"serviceId"`
+		Order_id       int            `json:"orderId"`
+		Amount         model.Decimal  `json:"amount"`
+		Currency       string         `json:"currency"`
+		Charged_amount *model.Decimal `json:"chargedAmount,omitempty"`
 	}
 	return func(w http.ResponseWriter, r *http.Request) {
 		req := &request{}
 
-		tx, err := s.store.BeginTx()
-		if err != nil {
-			s.error(w, r, http.StatusBadRequest, err)
+		if err := decode(r, req); err != nil {
+			s.fail(w, r, err)
 			return
 		}
-
-		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
-			s.error(w, r, http.StatusBadRequest, err)
+		v := &validator{}
+		v.required("id", req.User_id)
+		v.required("serviceId", req.Service_id)
+		currency := v.currency("currency", req.Currency)
+		amount := v.amount("amount", req.Amount, currency)
+		charged := amount
+		if req.Charged_amount != nil {
+			charged = v.amount("chargedAmount", *req.Charged_amount, currency)
+			v.check(charged <= amount, "chargedAmount", "max", "can't be greater than the reserved amount")
+		}
+		if err := v.err(); err != nil {
+			s.fail(w, r, err)
 			return
 		}
 		transactionSearch := &model.Transaction{
 			User_id:    req.User_id,
-			Amount:     req.Amount,
+			Amount:     amount,
+			Currency:   currency,
 			Service_id: req.Service_id,
 			Order_id:   req.Order_id,
 		}
-		transaction, err := s.store.Transaction().GetTransaction(transactionSearch)
-		if err != nil {
-			s.respond(w, r, http.StatusUnprocessableEntity, map[string]string{"error": "No open reservation with such data"})
-			return
-		}
 
-		reserve := &model.UserAccount{
-			User_id: req.User_id,
-			Balance: req.Amount,
-		}
-
-		reserve, err = s.store.UserAccount().ConfirmReserve(tx, reserve)
-		if err != nil {
-			tx.Rollback()
-			s.error(w, r, http.StatusUnprocessableEntity, err)
+		if err := s.service.Confirm(r.Context(), balance.ByOrder(transactionSearch), &charged); err != nil {
+			s.fail(w, r, err)
 			return
 		}
-
-		if err := s.store.Transaction().ConfirmReserveTransaction(tx, transaction.Id); err != nil {
-			tx.Rollback()
-			s.error(w, r, http.StatusUnprocessableEntity, err)
-			return
-		}
-		tx.Commit()
 		s.respond(w, r, http.StatusOK, map[string]string{"success": "Money reserve confirmed"})
 	}
 }
 
 func (s *server) handleAbort() http.HandlerFunc {
 	type request struct {
-		User_id    int `json:"id"`
-		Service_id int `json:"serviceId"`
-		Order_id   int `json:"orderId"`
-		Amount     int `json:"amount"`
+		User_id         int            `json:"id"`
+		Service_id      int            `json:"serviceId"`
+		Order_id        int            `json:"orderId"`
+		Amount          model.Decimal  `json:"amount"`
+		Currency        string         `json:"currency"`
+		Released_amount *model.Decimal `json:"releasedAmount,omitempty"`
 	}
 	return func(w http.ResponseWriter, r *http.Request) {
 		req := &request{}
 
-		tx, err := s.store.BeginTx()
-		if err != nil {
-			s.error(w, r, http.StatusBadRequest, err)
+		if err := decode(r, req); err != nil {
+			s.fail(w, r, err)
 			return
 		}
-
-		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
-			s.error(w, r, http.StatusBadRequest, err)
+		v := &validator{}
+		v.required("id", req.User_id)
+		v.required("serviceId", req.Service_id)
+		currency := v.currency("currency", req.Currency)
+		amount := v.amount("amount", req.Amount, currency)
+		released := amount
+		if req.Released_amount != nil {
+			released = v.amount("releasedAmount", *req.Released_amount, currency)
+			v.check(released <= amount, "releasedAmount", "max", "can't be greater than the reserved amount")
+		}
+		if err := v.err(); err != nil {
+			s.fail(w, r, err)
 			return
 		}
 		transactionSearch := &model.Transaction{
 			User_id:    req.User_id,
-			Amount:     req.Amount,
+			Amount:     amount,
+			Currency:   currency,
 			Service_id: req.Service_id,
 			Order_id:   req.Order_id,
 		}
-		transaction, err := s.store.Transaction().GetTransaction(transactionSearch)
-		if err != nil {
-			s.respond(w, r, http.StatusUnprocessableEntity, map[string]string{"error": "No open reservation with such data"})
-			return
-		}
-
-		reserve := &model.UserAccount{
-			User_id: req.User_id,
-			Balance: req.Amount,
-		}
-
-		reserve, err = s.store.UserAccount().AbortReserve(tx, reserve)
-		if err != nil {
-			tx.Rollback()
-			s.error(w, r, http.StatusUnprocessableEntity, err)
-			return
-		}
 
-		if err := s.store.Transaction().AbortReserveTransaction(tx, transaction.Id); err != nil {
-			tx.Rollback()
-			s.error(w, r, http.StatusUnprocessableEntity, err)
+		if err := s.service.Abort(r.Context(), balance.ByOrder(transactionSearch), &released); err != nil {
+			s.fail(w, r, err)
 			return
 		}
-		tx.Commit()
 		s.respond(w, r, http.StatusOK, map[string]string{"success": "Money reserve aborted"})
 	}
 }
 
-func (s *server) handleGetReport() http.HandlerFunc {
+func (s *server) handleRefund() http.HandlerFunc {
 	type request struct {
-		Month int `json:"month"`
-		Year  int `json:"year"`
+		User_id    int            `json:"id"`
+		Service_id int            `json:"serviceId"`
+		Order_id   int            `json:"orderId"`
+		Amount     *model.Decimal `json:"amount,omitempty"`
+		Currency   string         `json:"currency"`
 	}
 	return func(w http.ResponseWriter, r *http.Request) {
 		req := &request{}
 
-		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
-			s.error(w, r, http.StatusBadRequest, err)
+		if err := decode(r, req); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+		v := &validator{}
+		v.required("id", req.User_id)
+		v.required("serviceId", req.Service_id)
+		currency := v.currency("currency", req.Currency)
+		var amount *model.Amount
+		if req.Amount != nil {
+			parsed := v.amount("amount", *req.Amount, currency)
+			amount = &parsed
+		}
+		if err := v.err(); err != nil {
+			s.fail(w, r, err)
 			return
 		}
 
-		report, err := s.store.Transaction().GetMonthReport(req.Month, req.Year)
+		refunded, err := s.service.Refund(r.Context(), balance.RefundRequest{
+			User_id:    req.User_id,
+			Service_id: req.Service_id,
+			Order_id:   req.Order_id,
+			Currency:   currency,
+			Amount:     amount,
+		})
 		if err != nil {
-			s.error(w, r, http.StatusUnprocessableEntity, err)
+			s.fail(w, r, err)
 			return
 		}
-		if len(report) == 0 {
-			s.respond(w, r, http.StatusUnprocessableEntity, map[string]string{"error": "No data for this month"})
+		s.respond(w, r, http.StatusOK, map[string]interface{}{
+			"success": "Money refunded",
+			"amount":  model.Money{Amount: refunded, Currency: currency},
+		})
+	}
+}
+
+func (s *server) handleGetReport() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &reportRequest{}
+
+		if err := decode(r, req); err != nil {
+			s.fail(w, r, err)
 			return
 		}
-		dir := "csvreports"
-		_, err = os.Stat(dir)
-		if os.IsNotExist(err) {
-			err = os.Mkdir(dir, 0777)
-			if err != nil {
-				s.error(w, r, http.StatusInternalServerError, err)
+		// A saved report is answered with a link, so only a streamed one
+		// follows the Accept header.
+		if !s.config.Reports.Save {
+			if err := req.negotiate(r, model.FormatCSV); err != nil {
+				s.fail(w, r, err)
 				return
 			}
 		}
+		v := &validator{}
+		query := req.query(v)
+		format := req.format(v, s.config, model.FormatCSV)
+		if err := v.err(); err != nil {
+			s.fail(w, r, err)
+			return
+		}
 
-		path := fmt.Sprintf("%s/%d_%d_report.csv", dir, req.Month, req.Year)
-		file, err := os.Create(path)
+		rows, err := s.service.Report(r.Context(), query)
 		if err != nil {
-			s.error(w, r, http.StatusInternalServerError, err)
+			s.fail(w, r, err)
+			return
+		}
+		if len(rows) == 0 {
+			s.fail(w, r, &handlerError{http.StatusUnprocessableEntity, codeReportEmpty, req.emptyMessage()})
+			return
+		}
+		report := &reports.Report{Query: query, Rows: rows}
+		if !s.config.Reports.Save {
+			s.streamReport(w, r, reportPrefix(query), format, report)
 			return
 		}
-		defer file.Close()
 
-		csvWriter := csv.NewWriter(file)
-		defer csvWriter.Flush()
-		for key, value := range report {
-			row := []string{key, strconv.Itoa(value)}
-			if err := csvWriter.Write(row); err != nil {
-				s.error(w, r, http.StatusInternalServerError, err)
-				return
-			}
+		file, err := s.saveReport(r.Context(), reportPrefix(query), format, report)
+		if err != nil {
+			s.fail(w, r, err)
+			return
 		}
-		s.respond(w, r, http.StatusOK, map[string]string{"file": fmt.Sprintf("./%s", file.Name())})
+		s.respond(w, r, http.StatusOK, map[string]string{"file": "/reports/" + file})
 	}
 }
 
 func (s *server) handleGetHistory() http.HandlerFunc {
 	type request struct {
 		User_id   int    `json:"id"`
+		Currency  string `json:"currency"`
 		Ordering  string `json:"ordering"`
 		Page      int    `json:"page"`
 		Page_size *int   `json:"pageSize,omitempty"`
@@ -451,32 +441,34 @@ func (s *server) handleGetHistory() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		req := &request{}
 
-		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
-			s.error(w, r, http.StatusBadRequest, err)
-			return
-		}
-
-		_, err := s.store.UserAccount().FindById(req.User_id)
-		if err != nil {
-			err_str := fmt.Sprintf("No user with id = %d", req.User_id)
-			s.respond(w, r, http.StatusUnprocessableEntity, map[string]string{"error": err_str})
+		if err := decode(r, req); err != nil {
+			s.fail(w, r, err)
 			return
 		}
-		orderDir := "ASC"
-		if strings.HasPrefix(req.Ordering, "-") {
-			orderDir = "DESC"
+		v := &validator{}
+		v.required("id", req.User_id)
+		if req.Currency != "" {
+			v.currency("currency", req.Currency)
 		}
-		orderCol := "amount"
-		if strings.Contains(req.Ordering, "date") {
-			orderCol = "closed_date"
+		v.check(req.Page > 0, "page", "positive", "have to be a positive page number")
+		v.check(req.Page_size == nil || *req.Page_size > 0, "pageSize", "positive", "have to be a positive number of records")
+		if err := v.err(); err != nil {
+			s.fail(w, r, err)
+			return
 		}
-		pageSize := 3
+		pageSize := defaultPageSize
 		if req.Page_size != nil {
 			pageSize = *req.Page_size
 		}
-		report, err := s.store.Transaction().GetAccountReport(req.User_id, orderCol, orderDir, req.Page, pageSize)
+		report, err := s.service.History(r.Context(), balance.HistoryQuery{
+			User_id:  req.User_id,
+			Currency: req.Currency,
+			Sort:     req.Ordering,
+			Page:     req.Page,
+			PageSize: pageSize,
+		})
 		if err != nil {
-			s.error(w, r, http.StatusInternalServerError, err)
+			s.fail(w, r, err)
 			return
 		}
 
@@ -484,12 +476,28 @@ func (s *server) handleGetHistory() http.HandlerFunc {
 	}
 }
 
-func (s *server) error(w http.ResponseWriter, r *http.Request, code int, err error) {
-	s.respond(w, r, code, map[string]string{"error": err.Error()})
+var errUnknownCurrency = errors.New("Unknown currency, use one of RUB, KZT, USD")
 
+// parseCurrency returns the currency named in a request, or the default one
+// when the name is empty.
+func parseCurrency(currency string) (string, error) {
+	if currency == "" {
+		return model.DefaultCurrency, nil
+	}
+	if _, ok := model.Currencies[currency]; !ok {
+		return "", errUnknownCurrency
+	}
+	return currency, nil
 }
 
+// defaultPageSize is the number of records on a page of the history when
+// the request doesn't set it.
+const defaultPageSize = 3
+
 func (s *server) respond(w http.ResponseWriter, r *http.Request, code int, data interface{}) {
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
 	w.WriteHeader(code)
 	if data != nil {
 		json.NewEncoder(w).Encode(data)
@@ -2,20 +2,25 @@ package apiserver
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"github.com/stretchr/testify/assert"
 	"log"
+	"math/rand"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"sync"
 	"testing"
+	"time"
+	"user_balance_microservice/internal/app/model"
+	"user_balance_microservice/internal/app/store"
 	"user_balance_microservice/internal/app/store/sqlstore"
+	"user_balance_microservice/internal/app/store/teststore"
 )
 
 func TestServer_handleBalanceAdd(t *testing.T) {
-	config := GetConfig()
-	db, err := newDB(config.DatabaseURL)
-	assert.Nil(t, err)
-	s := newServer(sqlstore.New(db))
+	s := newServer(teststore.New(), testConfig())
 
 	testCases := []struct {
 		name         string
@@ -36,6 +41,27 @@ func TestServer_handleBalanceAdd(t *testing.T) {
 				"amount": "100",
 			},
 			expectedCode: http.StatusBadRequest,
+		}, {
+			name: "decimal",
+			payload: map[string]interface{}{
+				"id":     1,
+				"amount": "100.50",
+			},
+			expectedCode: http.StatusOK,
+		}, {
+			name: "too precise",
+			payload: map[string]interface{}{
+				"id":     1,
+				"amount": "100.505",
+			},
+			expectedCode: http.StatusBadRequest,
+		}, {
+			name: "exponent",
+			payload: map[string]interface{}{
+				"id":     1,
+				"amount": "1e2",
+			},
+			expectedCode: http.StatusBadRequest,
 		},
 	}
 
@@ -52,11 +78,38 @@ func TestServer_handleBalanceAdd(t *testing.T) {
 	}
 }
 
+func TestServer_handleBalanceAddReturnsBalance(t *testing.T) {
+	s := newServer(teststore.New(), testConfig())
+
+	for _, expected := range []string{"100.00", "200.00"} {
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, newRequest(t, "/account/add", map[string]int{"id": 1, "amount": 100}))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		res := &struct {
+			Balance string `json:"balance"`
+		}{}
+		assert.NoError(t, json.NewDecoder(rec.Body).Decode(res))
+		assert.Equal(t, expected, res.Balance)
+	}
+}
+
+func TestServer_handleBalanceAddOverflow(t *testing.T) {
+	s := newServer(teststore.New(), testConfig())
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, newRequest(t, "/account/add", map[string]interface{}{"id": 1, "amount": "100.00"}))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, newRequest(t, "/account/add", map[string]interface{}{"id": 1, "amount": "92233720368547758.07"}))
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"code":"BALANCE_OVERFLOW"`)
+}
+
 func TestServer_handleReserveMoney(t *testing.T) {
-	config := GetConfig()
-	db, err := newDB(config.DatabaseURL)
-	assert.Nil(t, err)
-	s := newServer(sqlstore.New(db))
+	store := teststore.New()
+	createAccount(t, store, 1, 100)
+	s := newServer(store, testConfig())
 
 	testCases := []struct {
 		name         string
@@ -88,11 +141,33 @@ func TestServer_handleReserveMoney(t *testing.T) {
 	}
 }
 
+func TestServer_handleReserveMoneyReturnsBalance(t *testing.T) {
+	store := teststore.New()
+	createAccount(t, store, 1, 100)
+	s := newServer(store, testConfig())
+
+	for i, expected := range []string{"70.00", "40.00"} {
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, newRequest(t, "/reserve_money", map[string]int{"id": 1, "serviceId": 1, "orderId": i + 1, "amount": 30}))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		res := &struct {
+			Balance string `json:"balance"`
+		}{}
+		assert.NoError(t, json.NewDecoder(rec.Body).Decode(res))
+		assert.Equal(t, expected, res.Balance)
+	}
+}
+
 func TestServer_handleConfirm(t *testing.T) {
-	config := GetConfig()
-	db, err := newDB(config.DatabaseURL)
-	assert.Nil(t, err)
-	s := newServer(sqlstore.New(db))
+	store := teststore.New()
+	createAccount(t, store, 1, 100)
+	s := newServer(store, testConfig())
+	s.ServeHTTP(httptest.NewRecorder(), newRequest(t, "/reserve_money", map[string]int{
+		"id":        1,
+		"serviceId": 1,
+		"orderId":   1234,
+		"amount":    100,
+	}))
 
 	testCases := []struct {
 		name         string
@@ -123,3 +198,146 @@ func TestServer_handleConfirm(t *testing.T) {
 		})
 	}
 }
+
+func testConfig() *Config {
+	config := &Config{}
+	config.Idempotency.TTL = time.Hour
+	config.Idempotency.Lease = time.Minute
+	config.Idempotency.CleanupInterval = time.Minute
+	config.Reservations.SweepInterval = time.Minute
+	config.Reservations.SweepBatch = 2
+	config.Reports.CSVDelimiter = ","
+	return config
+}
+
+// createAccount opens the RUB wallet of a user with the given whole roubles on it.
+func createAccount(t *testing.T, s store.Store, id, rubles int) {
+	t.Helper()
+
+	ctx := context.Background()
+	balance := model.Amount(rubles) * 100
+	assert.Nil(t, s.UserAccount().Create(ctx, &model.UserAccount{User_id: id, Currency: "RUB", Balance: balance}))
+	assert.Nil(t, s.Ledger().Post(ctx, &model.JournalEntry{
+		Description: "Входящий остаток",
+		Postings: []model.Posting{
+			{Account: model.ExternalLedgerAccount("RUB"), Amount: -balance},
+			{Account: model.UserLedgerAccount(model.Wallet{User_id: id, Currency: "RUB"}), Amount: balance},
+		},
+	}))
+}
+
+func newRequest(t *testing.T, path string, payload interface{}) *http.Request {
+	t.Helper()
+
+	b := &bytes.Buffer{}
+	json.NewEncoder(b).Encode(payload)
+	req, err := http.NewRequest(http.MethodPost, path, b)
+	assert.Nil(t, err)
+	return req
+}
+
+func TestServer_Concurrency(t *testing.T) {
+	testConcurrency(t, teststore.New())
+}
+
+// TestServer_ConcurrencySQL runs the same load against PostgreSQL, where the
+// row locks are real. It needs DATABASE_URL and is skipped without it.
+func TestServer_ConcurrencySQL(t *testing.T) {
+	db, teardown := sqlstore.TestDB(t, os.Getenv("DATABASE_URL"))
+	defer teardown("postings", "journal_entries", "discrepancies", "conversions", "transactions", "user_accounts", "idempotency_keys")
+
+	migrator, err := newMigrator(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("TRUNCATE postings, journal_entries, discrepancies, conversions, transactions, user_accounts, idempotency_keys CASCADE"); err != nil {
+		t.Fatal(err)
+	}
+
+	testConcurrency(t, sqlstore.New(db))
+}
+
+// testConcurrency fires reserves and transfers at random accounts in parallel
+// and checks that no money is lost or created and no balance goes negative.
+func testConcurrency(t *testing.T, store store.Store) {
+	ctx := context.Background()
+	const (
+		accounts = 10
+		balance  = 1000
+		requests = 400
+	)
+
+	for id := 1; id <= accounts; id++ {
+		createAccount(t, store, id, balance)
+	}
+	s := newServer(store, testConfig())
+
+	var (
+		mu       sync.Mutex
+		reserved model.Amount
+		expected = make(map[int]model.Amount)
+		wg       sync.WaitGroup
+	)
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < requests; i++ {
+		from := random.Intn(accounts) + 1
+		to := (from+random.Intn(accounts-1))%accounts + 1
+		amount := random.Intn(100) + 1
+		reserve := i%2 == 0
+		order := i
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			rec := httptest.NewRecorder()
+			if reserve {
+				s.ServeHTTP(rec, newRequest(t, "/reserve_money", map[string]int{
+					"id":        from,
+					"serviceId": 1,
+					"orderId":   order,
+					"amount":    amount,
+				}))
+			} else {
+				s.ServeHTTP(rec, newRequest(t, "/account/transfer", map[string]int{
+					"idFrom": from,
+					"idTo":   to,
+					"amount": amount,
+				}))
+			}
+			if rec.Code != http.StatusOK {
+				assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+				assert.Contains(t, rec.Body.String(), "Not enough money")
+				return
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			minor := model.Amount(amount) * 100
+			expected[from] -= minor
+			if reserve {
+				reserved += minor
+			} else {
+				expected[to] += minor
+			}
+		}()
+	}
+	wg.Wait()
+
+	var total, totalReserved model.Amount
+	for id := 1; id <= accounts; id++ {
+		account, err := store.UserAccount().FindById(ctx, id, "RUB")
+		assert.Nil(t, err)
+		assert.GreaterOrEqual(t, account.Balance, model.Amount(0))
+		assert.GreaterOrEqual(t, account.Reserved_balance, model.Amount(0))
+		assert.Equal(t, balance*100+expected[id], account.Balance)
+
+		total += account.Balance + account.Reserved_balance
+		totalReserved += account.Reserved_balance
+	}
+	assert.Equal(t, model.Amount(accounts*balance*100), total)
+	assert.Equal(t, reserved, totalReserved)
+}
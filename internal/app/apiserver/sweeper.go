@@ -0,0 +1,66 @@
+package apiserver
+
+import (
+	"context"
+	"errors"
+	"time"
+	"user_balance_microservice/internal/app/balance"
+)
+
+// sweepReservations periodically aborts expired reservations until ctx is
+// done, returning the money to the users' balances.
+func (s *server) sweepReservations(ctx context.Context) {
+	ticker := time.NewTicker(s.config.Reservations.SweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			swept, err := s.sweepExpiredReservations(ctx, time.Now())
+			if err != nil {
+				s.logger.Error(err)
+			}
+			if swept > 0 {
+				s.logger.Infof("aborted %d expired reservations", swept)
+			}
+		}
+	}
+}
+
+// sweepExpiredReservations aborts the reservations that expired before now
+// and returns how many were aborted. Reservations confirmed or aborted
+// concurrently are skipped.
+func (s *server) sweepExpiredReservations(ctx context.Context, now time.Time) (int, error) {
+	swept := 0
+	for {
+		reservations, err := s.store.Transaction().GetExpiredReservations(ctx, now, s.config.Reservations.SweepBatch)
+		if err != nil {
+			s.reservationSweepErrors.Add(1)
+			return swept, err
+		}
+
+		var sweepErr error
+		for i := range reservations {
+			reservation := &reservations[i]
+			err := s.service.Expire(ctx, reservation)
+			switch {
+			case errors.Is(err, balance.ErrReservationNotFound):
+			case err != nil:
+				s.reservationSweepErrors.Add(1)
+				s.logger.Errorf("abort expired reservation %d: %v", reservation.Id, err)
+				sweepErr = err
+			default:
+				swept++
+				s.reservationsSwept.Add(1)
+			}
+		}
+
+		// Failed reservations would be fetched again, so they are left for
+		// the next run instead of being retried in a loop.
+		if sweepErr != nil || len(reservations) < s.config.Reservations.SweepBatch {
+			return swept, sweepErr
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package apiserver
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"user_balance_microservice/internal/app/model"
+	"user_balance_microservice/internal/app/store/teststore"
+)
+
+func TestServer_sweepExpiredReservations(t *testing.T) {
+	ctx := context.Background()
+	store := teststore.New()
+	createAccount(t, store, 1, 100)
+	s := newServer(store, testConfig())
+
+	testCases := []struct {
+		name         string
+		payload      interface{}
+		expectedCode int
+	}{
+		{
+			name:         "ttl",
+			payload:      map[string]int{"id": 1, "serviceId": 1, "orderId": 1, "amount": 10, "ttl": 60},
+			expectedCode: http.StatusOK,
+		}, {
+			name:         "expiresAt",
+			payload:      map[string]interface{}{"id": 1, "serviceId": 1, "orderId": 2, "amount": 20, "expiresAt": time.Now().Add(time.Minute)},
+			expectedCode: http.StatusOK,
+		}, {
+			name:         "later",
+			payload:      map[string]int{"id": 1, "serviceId": 1, "orderId": 3, "amount": 30, "ttl": 3600},
+			expectedCode: http.StatusOK,
+		}, {
+			name:         "without expiry",
+			payload:      map[string]int{"id": 1, "serviceId": 1, "orderId": 4, "amount": 40},
+			expectedCode: http.StatusOK,
+		}, {
+			name:         "negative ttl",
+			payload:      map[string]int{"id": 1, "serviceId": 1, "orderId": 5, "amount": 1, "ttl": -1},
+			expectedCode: http.StatusBadRequest,
+		}, {
+			name:         "expired",
+			payload:      map[string]interface{}{"id": 1, "serviceId": 1, "orderId": 6, "amount": 1, "expiresAt": time.Now().Add(-time.Minute)},
+			expectedCode: http.StatusBadRequest,
+		}, {
+			name:         "ttl and expiresAt",
+			payload:      map[string]interface{}{"id": 1, "serviceId": 1, "orderId": 7, "amount": 1, "ttl": 60, "expiresAt": time.Now().Add(time.Minute)},
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, newRequest(t, "/reserve_money", tc.payload))
+			assert.Equal(t, tc.expectedCode, rec.Code)
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, newRequest(t, "/abort_reserve", map[string]int{"id": 1, "serviceId": 1, "orderId": 1, "amount": 10}))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	swept, err := s.sweepExpiredReservations(context.Background(), time.Now().Add(2*time.Minute))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, swept)
+
+	account, _ := store.UserAccount().FindById(ctx, 1, "RUB")
+	assert.Equal(t, model.Amount(3000), account.Balance)
+	assert.Equal(t, model.Amount(7000), account.Reserved_balance)
+
+	swept, err = s.sweepExpiredReservations(context.Background(), time.Now().Add(2*time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, swept)
+
+	rec = httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/metrics", nil)
+	s.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "user_balance_reservations_swept_total 2\n")
+	assert.Contains(t, rec.Body.String(), "user_balance_reservation_sweep_errors_total 0\n")
+}
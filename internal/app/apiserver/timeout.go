@@ -0,0 +1,55 @@
+package apiserver
+
+import (
+	"context"
+	"github.com/gorilla/mux"
+	"google.golang.org/grpc"
+	"net/http"
+	"time"
+)
+
+// timeoutFor returns the timeout of an endpoint: a route template like
+// "/v2/reports/monthly" or a gRPC method like
+// "/balance.v1.BalanceService/MonthlyReport". Endpoints without their own
+// timeout get the default one, and zero means no timeout.
+func (s *server) timeoutFor(endpoint string) time.Duration {
+	if timeout, ok := s.config.Timeouts.Endpoints[endpoint]; ok {
+		return timeout
+	}
+	return s.config.Timeouts.Default
+}
+
+// withTimeout cancels the context of a request, and so its queries, once the
+// timeout of its route passes.
+func (s *server) withTimeout(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		endpoint := r.URL.Path
+		if route := mux.CurrentRoute(r); route != nil {
+			if template, err := route.GetPathTemplate(); err == nil {
+				endpoint = template
+			}
+		}
+		timeout := s.timeoutFor(endpoint)
+		if timeout <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// grpcTimeout is withTimeout for unary gRPC calls. A shorter deadline set
+// by the client is kept.
+func (s *server) grpcTimeout(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	timeout := s.timeoutFor(info.FullMethod)
+	if timeout <= 0 {
+		return handler(ctx, req)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return handler(ctx, req)
+}
@@ -0,0 +1,47 @@
+package apiserver
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"user_balance_microservice/internal/app/store/teststore"
+	"user_balance_microservice/pkg/balancepb"
+)
+
+func TestServer_Timeouts(t *testing.T) {
+	store := teststore.New()
+	createAccount(t, store, 1, 100)
+	config := testConfig()
+	config.Timeouts.Default = time.Minute
+	config.Timeouts.Endpoints = map[string]time.Duration{
+		"/v2/accounts/{id}":                     time.Nanosecond,
+		"/balance.v1.BalanceService/GetBalance": time.Nanosecond,
+	}
+	s := newServer(store, config)
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, newMethodRequest(t, http.MethodGet, "/v2/accounts/1", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	res := &problem{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(res))
+	assert.Equal(t, codeRequestTimeout, res.Code)
+
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, newMethodRequest(t, http.MethodGet, "/account/balance?id=1", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	client := newGRPCClient(t, s)
+	_, err := client.GetBalance(context.Background(), &balancepb.GetBalanceRequest{UserId: 1})
+	st := status.Convert(err)
+	assert.Equal(t, codes.DeadlineExceeded, st.Code())
+	assert.Equal(t, string(codeRequestTimeout), errorReason(st))
+
+	_, err = client.Deposit(context.Background(), &balancepb.DepositRequest{UserId: 1, Amount: "5"})
+	assert.NoError(t, err)
+}
@@ -0,0 +1,511 @@
+package apiserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"user_balance_microservice/internal/app/balance"
+	"user_balance_microservice/internal/app/model"
+	"user_balance_microservice/internal/app/reports"
+)
+
+var errReservationNotFound = &handlerError{http.StatusNotFound, codeReservationNotFound, "No open reservation with such id"}
+
+// configureRouterV2 routes the resource-oriented API under /v2. It shares
+// the balance service, validation and errors with the routes of v1.
+func (s *server) configureRouterV2() {
+	v2 := s.router.PathPrefix("/v2").Subrouter()
+	v2.HandleFunc("/accounts/{id}", s.handleGetAccountV2()).Methods("GET")
+	v2.HandleFunc("/accounts/{id}/deposits", s.idempotent(s.handleCreateDepositV2())).Methods("POST")
+	v2.HandleFunc("/accounts/{id}/transactions", s.handleListTransactionsV2()).Methods("GET")
+	v2.HandleFunc("/accounts/{id}/reservations", s.handleListReservationsV2()).Methods("GET")
+	v2.HandleFunc("/transfers", s.idempotent(s.handleCreateTransferV2())).Methods("POST")
+	v2.HandleFunc("/reservations", s.idempotent(s.handleCreateReservationV2())).Methods("POST")
+	v2.HandleFunc("/reservations/{id}", s.handleGetReservationV2()).Methods("GET")
+	v2.HandleFunc("/reservations/{id}/confirm", s.idempotent(s.handleConfirmReservationV2())).Methods("POST")
+	v2.HandleFunc("/reservations/{id}/abort", s.idempotent(s.handleAbortReservationV2())).Methods("POST")
+	v2.HandleFunc("/refunds", s.idempotent(s.handleCreateRefundV2())).Methods("POST")
+	v2.HandleFunc("/reports/monthly", s.handleGetMonthlyReportV2()).Methods("GET")
+	v2.HandleFunc("/reports/revenue", s.handleGetRevenueReportV2()).Methods("GET")
+}
+
+// deprecated marks the responses of a v1 route as deprecated in favour of
+// its successor in v2.
+func (s *server) deprecated(successor string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "<"+successor+`>; rel="successor-version"`)
+		next(w, r)
+	}
+}
+
+func (s *server) handleGetAccountV2() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		v := &validator{}
+		userId := v.id("id", mux.Vars(r)["id"])
+		if err := v.err(); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+		accounts, err := s.service.Balance(r.Context(), userId)
+		if err != nil {
+			s.fail(w, r, err)
+			return
+		}
+		s.respond(w, r, http.StatusOK, newAccountBalance(userId, accounts))
+	}
+}
+
+func (s *server) handleCreateDepositV2() http.HandlerFunc {
+	type request struct {
+		Amount   model.Decimal `json:"amount"`
+		Currency string        `json:"currency"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &request{}
+
+		if err := decode(r, req); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+		v := &validator{}
+		userId := v.id("id", mux.Vars(r)["id"])
+		currency := v.currency("currency", req.Currency)
+		amount := v.amount("amount", req.Amount, currency)
+		if err := v.err(); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+
+		_, transaction, err := s.service.Deposit(r.Context(), model.Wallet{User_id: userId, Currency: currency}, amount)
+		if err != nil {
+			s.fail(w, r, err)
+			return
+		}
+		s.respond(w, r, http.StatusCreated, transaction)
+	}
+}
+
+func (s *server) handleListTransactionsV2() http.HandlerFunc {
+	type response struct {
+		Page         int                         `json:"page"`
+		PageSize     int                         `json:"pageSize"`
+		Transactions *[]model.AccountTransaction `json:"transactions"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		v := &validator{}
+		userId := v.id("id", mux.Vars(r)["id"])
+		currency := query.Get("currency")
+		if currency != "" {
+			v.currency("currency", currency)
+		}
+		sort := query.Get("sort")
+		v.check(sort == "" || sort == "amount" || sort == "-amount" || sort == "date" || sort == "-date",
+			"sort", "enum", "have to be one of amount, -amount, date, -date")
+		page := v.number("page", query.Get("page"), 1)
+		v.check(page > 0, "page", "positive", "have to be a positive page number")
+		pageSize := v.number("pageSize", query.Get("pageSize"), defaultPageSize)
+		v.check(pageSize > 0, "pageSize", "positive", "have to be a positive number of records")
+		if err := v.err(); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+
+		transactions, err := s.service.History(r.Context(), balance.HistoryQuery{
+			User_id:  userId,
+			Currency: currency,
+			Sort:     sort,
+			Page:     page,
+			PageSize: pageSize,
+		})
+		if err != nil {
+			s.fail(w, r, err)
+			return
+		}
+		s.respond(w, r, http.StatusOK, &response{Page: page, PageSize: pageSize, Transactions: transactions})
+	}
+}
+
+func (s *server) handleCreateTransferV2() http.HandlerFunc {
+	type request struct {
+		FromUserId int           `json:"fromUserId"`
+		ToUserId   int           `json:"toUserId"`
+		Amount     model.Decimal `json:"amount"`
+		Currency   string        `json:"currency"`
+		ToCurrency string        `json:"toCurrency"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &request{}
+
+		if err := decode(r, req); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+		v := &validator{}
+		v.required("fromUserId", req.FromUserId)
+		v.required("toUserId", req.ToUserId)
+		currency := v.currency("currency", req.Currency)
+		toCurrency := currency
+		if req.ToCurrency != "" {
+			toCurrency = v.currency("toCurrency", req.ToCurrency)
+		}
+		amount := v.amount("amount", req.Amount, currency)
+		v.check(req.FromUserId != req.ToUserId || toCurrency != currency, "toUserId", "distinct", "have to differ from fromUserId unless toCurrency is another currency")
+		if err := v.err(); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+
+		res, err := s.service.Transfer(r.Context(),
+			model.Wallet{User_id: req.FromUserId, Currency: currency},
+			model.Wallet{User_id: req.ToUserId, Currency: toCurrency},
+			amount)
+		if err != nil {
+			s.fail(w, r, err)
+			return
+		}
+		s.respond(w, r, http.StatusCreated, newTransferResult(res))
+	}
+}
+
+func (s *server) handleCreateReservationV2() http.HandlerFunc {
+	type request struct {
+		User_id    int           `json:"userId"`
+		Service_id int           `json:"serviceId"`
+		Order_id   int           `json:"orderId"`
+		Amount     model.Decimal `json:"amount"`
+		Currency   string        `json:"currency"`
+		Ttl        *int          `json:"ttl,omitempty"`
+		Expires_at *time.Time    `json:"expiresAt,omitempty"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &request{}
+
+		if err := decode(r, req); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+		v := &validator{}
+		v.required("userId", req.User_id)
+		v.required("serviceId", req.Service_id)
+		currency := v.currency("currency", req.Currency)
+		amount := v.amount("amount", req.Amount, currency)
+		expiresAt := v.expiry("ttl", req.Ttl, "expiresAt", req.Expires_at)
+		if err := v.err(); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+
+		_, reservation, err := s.service.Reserve(r.Context(), balance.ReserveRequest{
+			Wallet:     model.Wallet{User_id: req.User_id, Currency: currency},
+			Service_id: req.Service_id,
+			Order_id:   req.Order_id,
+			Amount:     amount,
+			Expires_at: expiresAt,
+		})
+		if err != nil {
+			s.fail(w, r, err)
+			return
+		}
+		w.Header().Set("Location", fmt.Sprintf("/v2/reservations/%d", reservation.Id))
+		s.respond(w, r, http.StatusCreated, newReservationView(reservation, time.Now()))
+	}
+}
+
+// reservationView is an open reservation with its age, the number of whole
+// seconds since it was made.
+type reservationView struct {
+	Id         int         `json:"id"`
+	User_id    int         `json:"userId"`
+	Service_id int         `json:"serviceId"`
+	Order_id   int         `json:"orderId"`
+	Amount     model.Money `json:"amount"`
+	Currency   string      `json:"currency"`
+	Created_at time.Time   `json:"createdAt"`
+	Expires_at *time.Time  `json:"expiresAt,omitempty"`
+	Age        int64       `json:"ageSeconds"`
+}
+
+func newReservationView(reservation *model.Transaction, now time.Time) *reservationView {
+	age := now.Sub(reservation.Created_at)
+	if age < 0 {
+		age = 0
+	}
+	return &reservationView{
+		Id:         reservation.Id,
+		User_id:    reservation.User_id,
+		Service_id: reservation.Service_id,
+		Order_id:   reservation.Order_id,
+		Amount:     model.Money{Amount: reservation.Amount, Currency: reservation.Currency},
+		Currency:   reservation.Currency,
+		Created_at: reservation.Created_at,
+		Expires_at: reservation.Expires_at,
+		Age:        int64(age / time.Second),
+	}
+}
+
+func (s *server) handleGetReservationV2() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		v := &validator{}
+		id := v.id("id", mux.Vars(r)["id"])
+		if err := v.err(); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+		reservation, err := s.service.Reservation(r.Context(), id)
+		if err != nil {
+			s.fail(w, r, reservationByIdError(err))
+			return
+		}
+		s.respond(w, r, http.StatusOK, newReservationView(reservation, time.Now()))
+	}
+}
+
+func (s *server) handleListReservationsV2() http.HandlerFunc {
+	type response struct {
+		Reservations []*reservationView `json:"reservations"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		v := &validator{}
+		userId := v.id("id", mux.Vars(r)["id"])
+		if err := v.err(); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+		reservations, err := s.service.OpenReservations(r.Context(), userId)
+		if err != nil {
+			s.fail(w, r, err)
+			return
+		}
+
+		now := time.Now()
+		res := &response{Reservations: []*reservationView{}}
+		for i := range reservations {
+			res.Reservations = append(res.Reservations, newReservationView(&reservations[i], now))
+		}
+		s.respond(w, r, http.StatusOK, res)
+	}
+}
+
+func (s *server) handleConfirmReservationV2() http.HandlerFunc {
+	type request struct {
+		Charged_amount *model.Decimal `json:"chargedAmount,omitempty"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &request{}
+
+		if err := decodeOptional(r, req); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+		v := &validator{}
+		id := v.id("id", mux.Vars(r)["id"])
+		if err := v.err(); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+		reservation, charged, err := s.reservationAmount(r.Context(), id, "chargedAmount", req.Charged_amount)
+		if err != nil {
+			s.fail(w, r, err)
+			return
+		}
+
+		if err := s.service.Confirm(r.Context(), balance.ById(reservation.Id), charged); err != nil {
+			s.fail(w, r, reservationByIdError(err))
+			return
+		}
+		if charged == nil {
+			charged = &reservation.Amount
+		}
+		s.respond(w, r, http.StatusOK, map[string]interface{}{
+			"id":            reservation.Id,
+			"status":        "confirmed",
+			"chargedAmount": model.Money{Amount: *charged, Currency: reservation.Currency},
+		})
+	}
+}
+
+func (s *server) handleAbortReservationV2() http.HandlerFunc {
+	type request struct {
+		Released_amount *model.Decimal `json:"releasedAmount,omitempty"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &request{}
+
+		if err := decodeOptional(r, req); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+		v := &validator{}
+		id := v.id("id", mux.Vars(r)["id"])
+		if err := v.err(); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+		reservation, released, err := s.reservationAmount(r.Context(), id, "releasedAmount", req.Released_amount)
+		if err != nil {
+			s.fail(w, r, err)
+			return
+		}
+
+		if err := s.service.Abort(r.Context(), balance.ById(reservation.Id), released); err != nil {
+			s.fail(w, r, reservationByIdError(err))
+			return
+		}
+		status := "aborted"
+		if released == nil {
+			released = &reservation.Amount
+		} else if *released < reservation.Amount {
+			status = "open"
+		}
+		s.respond(w, r, http.StatusOK, map[string]interface{}{
+			"id":             reservation.Id,
+			"status":         status,
+			"releasedAmount": model.Money{Amount: *released, Currency: reservation.Currency},
+		})
+	}
+}
+
+// reservationAmount returns the open reservation with the id and the amount
+// of it written in the field, which is nil when the field is not set. The
+// reservation is read first since the amount is in its currency, which
+// never changes.
+func (s *server) reservationAmount(ctx context.Context, id int, field string, d *model.Decimal) (*model.Transaction, *model.Amount, error) {
+	reservation, err := s.service.Reservation(ctx, id)
+	if err != nil {
+		return nil, nil, reservationByIdError(err)
+	}
+	if d == nil {
+		return reservation, nil, nil
+	}
+	v := &validator{}
+	amount := v.amount(field, *d, reservation.Currency)
+	v.check(amount <= reservation.Amount, field, "max", "can't be greater than the reserved amount")
+	return reservation, &amount, v.err()
+}
+
+// reservationByIdError answers 404 for a reservation of the path that is
+// not open, as for any other missing resource of v2.
+func reservationByIdError(err error) error {
+	if errors.Is(err, balance.ErrReservationNotFound) {
+		return errReservationNotFound
+	}
+	return err
+}
+
+func (s *server) handleCreateRefundV2() http.HandlerFunc {
+	type request struct {
+		User_id    int            `json:"userId"`
+		Service_id int            `json:"serviceId"`
+		Order_id   int            `json:"orderId"`
+		Amount     *model.Decimal `json:"amount,omitempty"`
+		Currency   string         `json:"currency"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &request{}
+
+		if err := decode(r, req); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+		v := &validator{}
+		v.required("userId", req.User_id)
+		v.required("serviceId", req.Service_id)
+		currency := v.currency("currency", req.Currency)
+		var amount *model.Amount
+		if req.Amount != nil {
+			parsed := v.amount("amount", *req.Amount, currency)
+			amount = &parsed
+		}
+		if err := v.err(); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+
+		refunded, err := s.service.Refund(r.Context(), balance.RefundRequest{
+			User_id:    req.User_id,
+			Service_id: req.Service_id,
+			Order_id:   req.Order_id,
+			Currency:   currency,
+			Amount:     amount,
+		})
+		if err != nil {
+			s.fail(w, r, err)
+			return
+		}
+		s.respond(w, r, http.StatusCreated, map[string]interface{}{
+			"amount": model.Money{Amount: refunded, Currency: currency},
+		})
+	}
+}
+
+func (s *server) handleGetMonthlyReportV2() http.HandlerFunc {
+	type response struct {
+		Month    int                    `json:"month"`
+		Year     int                    `json:"year"`
+		Services []model.ServiceRevenue `json:"services"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		v := &validator{}
+		month := v.number("month", query.Get("month"), 0)
+		v.check(month >= 1 && month <= 12, "month", "range", "have to be from 1 to 12")
+		year := v.number("year", query.Get("year"), 0)
+		v.check(year > 0, "year", "required", "have to be a positive year")
+		if err := v.err(); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+
+		report, err := s.service.MonthlyReport(r.Context(), month, year)
+		if err != nil {
+			s.fail(w, r, err)
+			return
+		}
+		s.respond(w, r, http.StatusOK, &response{Month: month, Year: year, Services: report})
+	}
+}
+
+func (s *server) handleGetRevenueReportV2() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()
+		var groupBy []string
+		if params.Get("groupBy") != "" {
+			groupBy = strings.Split(params.Get("groupBy"), ",")
+		}
+		req := &formatRequest{Format: params.Get("format")}
+		v := &validator{}
+		if params.Has("bom") {
+			bom, err := strconv.ParseBool(params.Get("bom"))
+			v.check(err == nil, "bom", "boolean", "have to be true or false")
+			req.BOM = &bom
+		}
+		if params.Has("delimiter") {
+			delimiter := params.Get("delimiter")
+			req.Delimiter = &delimiter
+		}
+		if err := req.negotiate(r, model.FormatJSON); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+		query := v.reportQuery(params.Get("from"), params.Get("to"), groupBy)
+		format := req.format(v, s.config, model.FormatJSON)
+		if err := v.err(); err != nil {
+			s.fail(w, r, err)
+			return
+		}
+
+		rows, err := s.service.Report(r.Context(), query)
+		if err != nil {
+			s.fail(w, r, err)
+			return
+		}
+		s.streamReport(w, r, reportPrefix(query), format, &reports.Report{Query: query, Rows: rows})
+	}
+}
@@ -0,0 +1,268 @@
+package apiserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"user_balance_microservice/internal/app/model"
+	"user_balance_microservice/internal/app/store/teststore"
+)
+
+func TestServer_v2(t *testing.T) {
+	ctx := context.Background()
+	store := teststore.New()
+	createAccount(t, store, 1, 100)
+	s := newServer(store, testConfig())
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, newMethodRequest(t, http.MethodPost, "/v2/reservations", map[string]interface{}{
+		"userId": 1, "serviceId": 1, "orderId": 1, "amount": "30",
+	}))
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	reservation := &struct {
+		Id     int    `json:"id"`
+		Amount string `json:"amount"`
+	}{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(reservation))
+	assert.NotZero(t, reservation.Id)
+	assert.Equal(t, "30.00", reservation.Amount)
+
+	now := time.Now()
+	testCases := []struct {
+		name           string
+		method         string
+		path           string
+		payload        interface{}
+		expectedStatus int
+		expectedCode   errorCode
+	}{
+		{
+			name:           "account",
+			method:         http.MethodGet,
+			path:           "/v2/accounts/1",
+			expectedStatus: http.StatusOK,
+		}, {
+			name:           "unknown account",
+			method:         http.MethodGet,
+			path:           "/v2/accounts/2",
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedCode:   codeAccountNotFound,
+		}, {
+			name:           "invalid account id",
+			method:         http.MethodGet,
+			path:           "/v2/accounts/abc",
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   codeValidationFailed,
+		}, {
+			name:           "deposit",
+			method:         http.MethodPost,
+			path:           "/v2/accounts/1/deposits",
+			payload:        map[string]interface{}{"amount": "50"},
+			expectedStatus: http.StatusCreated,
+		}, {
+			name:           "partial abort",
+			method:         http.MethodPost,
+			path:           fmt.Sprintf("/v2/reservations/%d/abort", reservation.Id),
+			payload:        map[string]interface{}{"releasedAmount": "10"},
+			expectedStatus: http.StatusOK,
+		}, {
+			name:           "charge above reserve",
+			method:         http.MethodPost,
+			path:           fmt.Sprintf("/v2/reservations/%d/confirm", reservation.Id),
+			payload:        map[string]interface{}{"chargedAmount": "25"},
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   codeValidationFailed,
+		}, {
+			name:           "confirm",
+			method:         http.MethodPost,
+			path:           fmt.Sprintf("/v2/reservations/%d/confirm", reservation.Id),
+			expectedStatus: http.StatusOK,
+		}, {
+			name:           "confirm again",
+			method:         http.MethodPost,
+			path:           fmt.Sprintf("/v2/reservations/%d/confirm", reservation.Id),
+			expectedStatus: http.StatusNotFound,
+			expectedCode:   codeReservationNotFound,
+		}, {
+			name:           "refund",
+			method:         http.MethodPost,
+			path:           "/v2/refunds",
+			payload:        map[string]interface{}{"userId": 1, "serviceId": 1, "orderId": 1, "amount": "5"},
+			expectedStatus: http.StatusCreated,
+		}, {
+			name:           "transfer",
+			method:         http.MethodPost,
+			path:           "/v2/transfers",
+			payload:        map[string]interface{}{"fromUserId": 1, "toUserId": 2, "amount": "15"},
+			expectedStatus: http.StatusCreated,
+		}, {
+			name:           "transactions",
+			method:         http.MethodGet,
+			path:           "/v2/accounts/1/transactions?sort=-date&pageSize=10",
+			expectedStatus: http.StatusOK,
+		}, {
+			name:           "invalid sort",
+			method:         http.MethodGet,
+			path:           "/v2/accounts/1/transactions?sort=service",
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   codeValidationFailed,
+		}, {
+			name:           "monthly report",
+			method:         http.MethodGet,
+			path:           fmt.Sprintf("/v2/reports/monthly?month=%d&year=%d", now.Month(), now.Year()),
+			expectedStatus: http.StatusOK,
+		}, {
+			name:           "report without month",
+			method:         http.MethodGet,
+			path:           "/v2/reports/monthly?year=2022",
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   codeValidationFailed,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, newMethodRequest(t, tc.method, tc.path, tc.payload))
+			assert.Equal(t, tc.expectedStatus, rec.Code)
+			assert.Empty(t, rec.Header().Get("Deprecation"))
+			if tc.expectedCode == "" {
+				return
+			}
+
+			res := &problem{}
+			assert.NoError(t, json.NewDecoder(rec.Body).Decode(res))
+			assert.Equal(t, tc.expectedCode, res.Code)
+		})
+	}
+
+	// 100 + 50 deposited - 20 charged + 5 refunded - 15 transferred
+	account, _ := store.UserAccount().FindById(ctx, 1, "RUB")
+	assert.Equal(t, model.Amount(12000), account.Balance)
+	assert.Equal(t, model.Amount(0), account.Reserved_balance)
+	account, _ = store.UserAccount().FindById(ctx, 2, "RUB")
+	assert.Equal(t, model.Amount(1500), account.Balance)
+
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, newMethodRequest(t, http.MethodGet, fmt.Sprintf("/v2/reports/monthly?month=%d&year=%d", now.Month(), now.Year()), nil))
+	report := &struct {
+		Services []struct {
+			Service string `json:"service"`
+			Amount  string `json:"amount"`
+		} `json:"services"`
+	}{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(report))
+	if assert.Len(t, report.Services, 1) {
+		assert.Equal(t, "15.00", report.Services[0].Amount)
+	}
+}
+
+func TestServer_v2Reservations(t *testing.T) {
+	store := teststore.New()
+	createAccount(t, store, 1, 100)
+	createAccount(t, store, 2, 100)
+	s := newServer(store, testConfig())
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, newRequest(t, "/reserve_money", map[string]int{"id": 1, "serviceId": 1, "orderId": 1, "amount": 10}))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	first := &struct {
+		Reservation_id int `json:"reservationId"`
+	}{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(first))
+	assert.NotZero(t, first.Reservation_id)
+
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, newMethodRequest(t, http.MethodPost, "/v2/reservations", map[string]interface{}{
+		"userId": 1, "serviceId": 2, "orderId": 2, "amount": "20.50", "ttl": 60,
+	}))
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	second := &struct {
+		Id int `json:"id"`
+	}{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(second))
+	assert.Equal(t, fmt.Sprintf("/v2/reservations/%d", second.Id), rec.Header().Get("Location"))
+	s.ServeHTTP(httptest.NewRecorder(), newRequest(t, "/reserve_money", map[string]int{"id": 2, "serviceId": 1, "orderId": 3, "amount": 30}))
+
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, newMethodRequest(t, http.MethodGet, fmt.Sprintf("/v2/reservations/%d", first.Reservation_id), nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"amount":"10.00"`)
+	assert.Contains(t, rec.Body.String(), `"ageSeconds":`)
+
+	list := func() []string {
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, newMethodRequest(t, http.MethodGet, "/v2/accounts/1/reservations", nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		res := &struct {
+			Reservations []struct {
+				Amount string `json:"amount"`
+			} `json:"reservations"`
+		}{}
+		assert.NoError(t, json.NewDecoder(rec.Body).Decode(res))
+		amounts := []string{}
+		for _, reservation := range res.Reservations {
+			amounts = append(amounts, reservation.Amount)
+		}
+		return amounts
+	}
+	assert.Equal(t, []string{"10.00", "20.50"}, list())
+
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, newMethodRequest(t, http.MethodPost, fmt.Sprintf("/v2/reservations/%d/confirm", first.Reservation_id), nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, []string{"20.50"}, list())
+
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, newMethodRequest(t, http.MethodGet, fmt.Sprintf("/v2/reservations/%d", first.Reservation_id), nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, newMethodRequest(t, http.MethodGet, "/v2/accounts/3/reservations", nil))
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+}
+
+func TestReservationView(t *testing.T) {
+	created := time.Date(2022, 11, 1, 12, 0, 0, 0, time.UTC)
+	view := newReservationView(&model.Transaction{Id: 1, Amount: 1050, Currency: "USD", Created_at: created}, created.Add(90*time.Second+time.Millisecond))
+	assert.Equal(t, int64(90), view.Age)
+	assert.Equal(t, "10.50", view.Amount.String())
+}
+
+func TestServer_v1Deprecation(t *testing.T) {
+	store := teststore.New()
+	createAccount(t, store, 1, 100)
+	s := newServer(store, testConfig())
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, newRequest(t, "/reserve_money", map[string]int{"id": 1, "serviceId": 1, "orderId": 1, "amount": 10}))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "true", rec.Header().Get("Deprecation"))
+	assert.Equal(t, `</v2/reservations>; rel="successor-version"`, rec.Header().Get("Link"))
+
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, newMethodRequest(t, http.MethodGet, "/account/balance?id=1", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "true", rec.Header().Get("Deprecation"))
+	assert.Equal(t, `</v2/accounts/{id}>; rel="successor-version"`, rec.Header().Get("Link"))
+}
+
+// newMethodRequest is newRequest for any method. A nil payload is sent as
+// an empty body.
+func newMethodRequest(t *testing.T, method, path string, payload interface{}) *http.Request {
+	t.Helper()
+
+	b := &bytes.Buffer{}
+	if payload != nil {
+		json.NewEncoder(b).Encode(payload)
+	}
+	req, err := http.NewRequest(method, path, b)
+	assert.Nil(t, err)
+	return req
+}
@@ -0,0 +1,221 @@
+package apiserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+	"user_balance_microservice/internal/app/model"
+	"user_balance_microservice/internal/app/reports"
+)
+
+// fieldError is a request field that breaks one of the rules of its request.
+type fieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// validationError lists every invalid field of a request. It is answered
+// with 400 and the list of fields.
+type validationError struct {
+	Fields []fieldError `json:"fields"`
+}
+
+func (e *validationError) Error() string {
+	messages := make([]string, 0, len(e.Fields))
+	for _, field := range e.Fields {
+		messages = append(messages, fmt.Sprintf("%s %s", field.Field, field.Message))
+	}
+	return strings.Join(messages, ", ")
+}
+
+// validator collects the fields of a request that break its rules, so that
+// all of them are reported at once.
+type validator struct {
+	fields []fieldError
+}
+
+// check records the field as invalid by the rule unless ok.
+func (v *validator) check(ok bool, field, rule, message string) {
+	if !ok {
+		v.fields = append(v.fields, fieldError{Field: field, Rule: rule, Message: message})
+	}
+}
+
+func (v *validator) required(field string, id int) {
+	v.check(id > 0, field, "required", "have to be a positive id")
+}
+
+// id returns the id written in a path or query parameter.
+func (v *validator) id(field, value string) int {
+	id, err := strconv.Atoi(value)
+	v.check(err == nil && id > 0, field, "required", "have to be a positive id")
+	return id
+}
+
+// number returns the integer written in a query parameter, or def when the
+// parameter is empty.
+func (v *validator) number(field, value string, def int) int {
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		v.check(false, field, "type", "have to be of type int")
+		return def
+	}
+	return n
+}
+
+// expiry returns when a reservation expires: ttl seconds from now or at
+// expiresAt, which can't be both set. It is nil when neither is. The fields
+// are named as in the request.
+func (v *validator) expiry(ttlField string, ttl *int, expiresAtField string, expiresAt *time.Time) *time.Time {
+	switch {
+	case ttl != nil && expiresAt != nil:
+		v.check(false, ttlField, "exclusive", fmt.Sprintf("can't be set together with %s", expiresAtField))
+	case ttl != nil:
+		v.check(*ttl > 0, ttlField, "positive", "have to be a positive number of seconds")
+		expires := time.Now().Add(time.Duration(*ttl) * time.Second)
+		return &expires
+	case expiresAt != nil:
+		v.check(expiresAt.After(time.Now()), expiresAtField, "future", "have to be in the future")
+	}
+	return expiresAt
+}
+
+// currency returns the currency named by the field, or the default one when
+// it is empty.
+func (v *validator) currency(field, name string) string {
+	currency, err := parseCurrency(name)
+	v.check(err == nil, field, "currency", "have to be one of RUB, KZT, USD")
+	return currency
+}
+
+// amount returns the positive amount written in the field in minor units of
+// the currency.
+func (v *validator) amount(field string, d model.Decimal, currency string) model.Amount {
+	amount, err := d.Amount(currency)
+	switch {
+	case errors.Is(err, model.ErrAmountPrecision):
+		v.check(false, field, "precision", fmt.Sprintf("can't have more than %d digits after the point", model.Exponent(currency)))
+	case errors.Is(err, model.ErrAmountRange):
+		v.check(false, field, "range", "is too large")
+	case err != nil:
+		v.check(false, field, "decimal", `have to be a decimal number like "100.50"`)
+	default:
+		v.check(amount > 0, field, "positive", "have to be positive")
+	}
+	return amount
+}
+
+// reportGroupings are the groupings of a revenue report. A report can be
+// grouped by one period at most.
+var reportGroupings = map[string]bool{
+	model.GroupByService: false,
+	model.GroupByUser:    false,
+	model.GroupByDay:     true,
+	model.GroupByWeek:    true,
+	model.GroupByMonth:   true,
+}
+
+// reportQuery returns the query of a revenue report for the days from from
+// to to, both included and written like 2006-01-02.
+func (v *validator) reportQuery(from, to string, groupBy []string) model.ReportQuery {
+	query := model.ReportQuery{GroupBy: v.groupBy(groupBy)}
+	var fromErr, toErr error
+	query.From, fromErr = time.Parse("2006-01-02", from)
+	v.check(fromErr == nil, "from", "date", `have to be a date like "2022-11-01"`)
+	query.To, toErr = time.Parse("2006-01-02", to)
+	v.check(toErr == nil, "to", "date", `have to be a date like "2022-11-30"`)
+	if fromErr == nil && toErr == nil {
+		v.check(!query.To.Before(query.From), "to", "range", "can't be before from")
+	}
+	query.To = query.To.AddDate(0, 0, 1)
+	return query
+}
+
+// groupBy returns the groupings of a revenue report, by service unless
+// any are given.
+func (v *validator) groupBy(groupBy []string) []string {
+	if len(groupBy) == 0 {
+		return []string{model.GroupByService}
+	}
+	seen := map[string]bool{}
+	periods := 0
+	for _, grouping := range groupBy {
+		period, ok := reportGroupings[grouping]
+		v.check(ok, "groupBy", "enum", "have to be service, user, day, week or month")
+		v.check(!seen[grouping], "groupBy", "unique", fmt.Sprintf("can't have %s twice", grouping))
+		seen[grouping] = true
+		if period {
+			periods++
+		}
+	}
+	v.check(periods <= 1, "groupBy", "period", "can't have more than one of day, week and month")
+	return groupBy
+}
+
+// reportFormat checks the format of a report file. The delimiter of a CSV
+// report has to be a single character that can't be a part of a field.
+func (v *validator) reportFormat(format model.ReportFormat) model.ReportFormat {
+	v.check(reports.ContentType(format.Name) != "", "format", "enum", "have to be csv, xlsx or json")
+	delimiter, size := utf8.DecodeRuneInString(format.Delimiter)
+	v.check(size == len(format.Delimiter) && delimiter != utf8.RuneError &&
+		!strings.ContainsRune("\x00\"\r\n\ufeff", delimiter), "delimiter", "character",
+		"have to be a single character other than a quote or a line break")
+	return format
+}
+
+func (v *validator) err() error {
+	if len(v.fields) == 0 {
+		return nil
+	}
+	return &validationError{Fields: v.fields}
+}
+
+var emptyBody = fieldError{Field: "body", Rule: "required", Message: "can't be empty"}
+
+// decode reads the JSON body of a request into req. Unknown fields and
+// values of a wrong type are reported as a validationError.
+func decode(r *http.Request, req interface{}) error {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(req)
+
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, io.EOF):
+		return &validationError{Fields: []fieldError{emptyBody}}
+	case errors.As(err, &typeErr):
+		return &validationError{Fields: []fieldError{{
+			Field:   typeErr.Field,
+			Rule:    "type",
+			Message: fmt.Sprintf("have to be of type %s", typeErr.Type),
+		}}}
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		return &validationError{Fields: []fieldError{{Field: field, Rule: "unknown", Message: "is not a field of this request"}}}
+	default:
+		return &validationError{Fields: []fieldError{{Field: "body", Rule: "json", Message: err.Error()}}}
+	}
+}
+
+// decodeOptional is decode for requests whose fields are all optional, so
+// an empty body is one without fields.
+func decodeOptional(r *http.Request, req interface{}) error {
+	err := decode(r, req)
+	var validationErr *validationError
+	if errors.As(err, &validationErr) && len(validationErr.Fields) == 1 && validationErr.Fields[0] == emptyBody {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,138 @@
+package apiserver
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"user_balance_microservice/internal/app/model"
+	"user_balance_microservice/internal/app/store/teststore"
+)
+
+func TestServer_validation(t *testing.T) {
+	ctx := context.Background()
+	store := teststore.New()
+	createAccount(t, store, 1, 100)
+	s := newServer(store, testConfig())
+
+	testCases := []struct {
+		name     string
+		path     string
+		payload  interface{}
+		expected []fieldError
+	}{
+		{
+			name:    "negative top-up",
+			path:    "/account/add",
+			payload: map[string]interface{}{"id": 1, "amount": "-10"},
+			expected: []fieldError{
+				{Field: "amount", Rule: "positive", Message: "have to be positive"},
+			},
+		}, {
+			name:    "zero top-up without user",
+			path:    "/account/add",
+			payload: map[string]interface{}{"amount": 0, "currency": "EUR"},
+			expected: []fieldError{
+				{Field: "id", Rule: "required", Message: "have to be a positive id"},
+				{Field: "currency", Rule: "currency", Message: "have to be one of RUB, KZT, USD"},
+				{Field: "amount", Rule: "positive", Message: "have to be positive"},
+			},
+		}, {
+			name:    "unknown field",
+			path:    "/account/add",
+			payload: map[string]interface{}{"id": 1, "amount": 10, "comment": "gift"},
+			expected: []fieldError{
+				{Field: "comment", Rule: "unknown", Message: "is not a field of this request"},
+			},
+		}, {
+			name:    "wrong type",
+			path:    "/account/add",
+			payload: map[string]interface{}{"id": "1", "amount": 10},
+			expected: []fieldError{
+				{Field: "id", Rule: "type", Message: "have to be of type int"},
+			},
+		}, {
+			name:    "self-transfer",
+			path:    "/account/transfer",
+			payload: map[string]interface{}{"idFrom": 1, "idTo": 1, "amount": 10},
+			expected: []fieldError{
+				{Field: "idTo", Rule: "distinct", Message: "have to differ from idFrom unless toCurrency is another currency"},
+			},
+		}, {
+			name:    "reserve without service",
+			path:    "/reserve_money",
+			payload: map[string]interface{}{"id": 1, "orderId": 1, "amount": 10, "ttl": -1},
+			expected: []fieldError{
+				{Field: "serviceId", Rule: "required", Message: "have to be a positive id"},
+				{Field: "ttl", Rule: "positive", Message: "have to be a positive number of seconds"},
+			},
+		}, {
+			name:    "charge above reserve",
+			path:    "/confirm_reserve",
+			payload: map[string]interface{}{"id": 1, "serviceId": 1, "orderId": 1, "amount": 10, "chargedAmount": 20},
+			expected: []fieldError{
+				{Field: "chargedAmount", Rule: "max", Message: "can't be greater than the reserved amount"},
+			},
+		}, {
+			name:    "refund too precise",
+			path:    "/refund",
+			payload: map[string]interface{}{"id": 1, "serviceId": 1, "orderId": 1, "amount": "0.001"},
+			expected: []fieldError{
+				{Field: "amount", Rule: "precision", Message: "can't have more than 2 digits after the point"},
+			},
+		}, {
+			name:    "report month",
+			path:    "/get_report",
+			payload: map[string]interface{}{"month": 13, "year": 2022},
+			expected: []fieldError{
+				{Field: "month", Rule: "range", Message: "have to be from 1 to 12"},
+			},
+		}, {
+			name:    "report range",
+			path:    "/get_report",
+			payload: map[string]interface{}{"month": 11, "from": "2022-11-30", "to": "2022-11-01", "groupBy": []string{"day", "week", "day", "year"}},
+			expected: []fieldError{
+				{Field: "month", Rule: "exclusive", Message: "can't be set together with from and to"},
+				{Field: "groupBy", Rule: "unique", Message: "can't have day twice"},
+				{Field: "groupBy", Rule: "enum", Message: "have to be service, user, day, week or month"},
+				{Field: "groupBy", Rule: "period", Message: "can't have more than one of day, week and month"},
+				{Field: "to", Rule: "range", Message: "can't be before from"},
+			},
+		}, {
+			name:    "history page",
+			path:    "/account/history",
+			payload: map[string]interface{}{"id": 1, "page": 0, "pageSize": 0},
+			expected: []fieldError{
+				{Field: "page", Rule: "positive", Message: "have to be a positive page number"},
+				{Field: "pageSize", Rule: "positive", Message: "have to be a positive number of records"},
+			},
+		}, {
+			name:    "missing fields",
+			path:    "/abort_reserve",
+			payload: nil,
+			expected: []fieldError{
+				{Field: "id", Rule: "required", Message: "have to be a positive id"},
+				{Field: "serviceId", Rule: "required", Message: "have to be a positive id"},
+				{Field: "amount", Rule: "decimal", Message: `have to be a decimal number like "100.50"`},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, newRequest(t, tc.path, tc.payload))
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+			res := &problem{}
+			assert.NoError(t, json.NewDecoder(rec.Body).Decode(res))
+			assert.Equal(t, codeValidationFailed, res.Code)
+			assert.Equal(t, tc.expected, res.Fields)
+		})
+	}
+
+	account, _ := store.UserAccount().FindById(ctx, 1, "RUB")
+	assert.Equal(t, model.Amount(10000), account.Balance)
+}
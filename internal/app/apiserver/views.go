@@ -0,0 +1,43 @@
+package apiserver
+
+import (
+	"user_balance_microservice/internal/app/balance"
+	"user_balance_microservice/internal/app/model"
+)
+
+// The views below are the results of the balance service as the handlers of
+// both API versions write them.
+
+// accountBalance is the balance of every wallet of a user.
+type accountBalance struct {
+	User_id int             `json:"id"`
+	Wallets []walletBalance `json:"wallets"`
+}
+
+type walletBalance struct {
+	Currency string      `json:"currency"`
+	Balance  model.Money `json:"balance"`
+}
+
+func newAccountBalance(userId int, accounts []model.UserAccount) *accountBalance {
+	res := &accountBalance{User_id: userId, Wallets: []walletBalance{}}
+	for _, account := range accounts {
+		res.Wallets = append(res.Wallets, walletBalance{
+			Currency: account.Currency,
+			Balance:  model.Money{Amount: account.Balance, Currency: account.Currency},
+		})
+	}
+	return res
+}
+
+// transferResult is what a transfer recorded: the transactions of both
+// users and the conversion, if the currency was exchanged.
+type transferResult struct {
+	Out        *model.Transaction `json:"out"`
+	In         *model.Transaction `json:"in"`
+	Conversion *model.Conversion  `json:"conversion,omitempty"`
+}
+
+func newTransferResult(res *balance.TransferResult) *transferResult {
+	return &transferResult{Out: res.Out, In: res.In, Conversion: res.Conversion}
+}
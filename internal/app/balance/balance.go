@@ -0,0 +1,147 @@
+// Package balance holds the business rules of the service: how money is
+// deposited, transferred, reserved, charged and refunded. The APIs only
+// read requests, call a Service and write its results or errors.
+package balance
+
+import (
+	"context"
+	"math"
+	"strings"
+	"time"
+	"user_balance_microservice/internal/app/exchange"
+	"user_balance_microservice/internal/app/model"
+	"user_balance_microservice/internal/app/store"
+)
+
+// Service runs the operations on the accounts of a store.
+type Service struct {
+	store  store.Store
+	rates  exchange.RatesProvider
+	spread float64
+}
+
+// New returns a Service that converts currencies at the rates given by rates
+// with the spread applied. Without rates, transfers between currencies are
+// refused.
+func New(store store.Store, rates exchange.RatesProvider, spread float64) *Service {
+	return &Service{store: store, rates: rates, spread: spread}
+}
+
+// HistoryQuery selects a page of a user's transactions. Sort is "amount" or
+// "date", descending when prefixed with "-", and the amount when empty. An
+// empty Currency selects every currency. When After is set, the page is the
+// PageSize records that follow it instead of the numbered one.
+type HistoryQuery struct {
+	User_id  int
+	Currency string
+	Sort     string
+	Page     int
+	PageSize int
+	After    *model.AccountTransaction
+}
+
+// Balance returns the wallets of the user.
+func (s *Service) Balance(ctx context.Context, userId int) ([]model.UserAccount, error) {
+	accounts, err := s.store.UserAccount().FindByUser(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	if len(accounts) == 0 {
+		return nil, errNoUser(userId)
+	}
+	return accounts, nil
+}
+
+// Deposit tops up the wallet of the user, opening it on the first deposit.
+// It returns the wallet as stored after the deposit and the transaction of
+// the deposit.
+func (s *Service) Deposit(ctx context.Context, wallet model.Wallet, amount model.Amount) (*model.UserAccount, *model.Transaction, error) {
+	account := &model.UserAccount{
+		User_id:  wallet.User_id,
+		Currency: wallet.Currency,
+		Balance:  amount,
+	}
+
+	transaction := &model.Transaction{
+		User_id:     wallet.User_id,
+		Amount:      amount,
+		Currency:    wallet.Currency,
+		Description: "Пополнение счета",
+		Closed_date: time.Now(),
+		Success_flg: true,
+		Type:        "add",
+	}
+
+	if err := s.store.WithinTx(ctx, func(tx store.TxStore) error {
+		accounts, err := tx.UserAccount().FindForUpdate(ctx, wallet)
+		if err != nil {
+			return err
+		}
+		if stored, ok := accounts[wallet]; !ok {
+			if err := tx.UserAccount().Create(ctx, account); err != nil {
+				return err
+			}
+		} else if err := ensureRoom(stored, amount); err != nil {
+			return err
+		} else if account, err = tx.UserAccount().Add(ctx, account); err != nil {
+			return err
+		}
+		if err := tx.Transaction().CreateAddTransaction(ctx, transaction); err != nil {
+			return err
+		}
+		return postEntry(ctx, tx, transaction, transaction.Description,
+			move(model.ExternalLedgerAccount(wallet.Currency), model.UserLedgerAccount(wallet), amount))
+	}); err != nil {
+		return nil, nil, err
+	}
+	return account, transaction, nil
+}
+
+// History returns a page of the user's transactions.
+func (s *Service) History(ctx context.Context, query HistoryQuery) (*[]model.AccountTransaction, error) {
+	if _, err := s.Balance(ctx, query.User_id); err != nil {
+		return nil, err
+	}
+	orderDir := "ASC"
+	if strings.HasPrefix(query.Sort, "-") {
+		orderDir = "DESC"
+	}
+	orderCol := "amount"
+	if strings.Contains(query.Sort, "date") {
+		orderCol = "closed_date"
+	}
+	if query.After != nil {
+		return s.store.Transaction().GetAccountReportAfter(ctx, query.User_id, query.Currency, orderCol, orderDir, query.After, query.PageSize)
+	}
+	return s.store.Transaction().GetAccountReport(ctx, query.User_id, query.Currency, orderCol, orderDir, query.Page, query.PageSize)
+}
+
+// MonthlyReport returns the revenue of every service in the month.
+func (s *Service) MonthlyReport(ctx context.Context, month, year int) ([]model.ServiceRevenue, error) {
+	return s.store.Transaction().GetMonthReport(ctx, month, year)
+}
+
+// Report returns the revenue of the services over the period of the
+// query, sorted by its groupings and currency.
+func (s *Service) Report(ctx context.Context, query model.ReportQuery) ([]model.Revenue, error) {
+	return s.store.Transaction().GetRevenueReport(ctx, query)
+}
+
+// ensureRoom fails with ErrBalanceOverflow when amount can't be credited to
+// the account. The balance and the reserve are kept within an int64
+// together, so that returning the reserve to the balance can't overflow
+// either.
+func ensureRoom(account *model.UserAccount, amount model.Amount) error {
+	if amount > math.MaxInt64-account.Balance-account.Reserved_balance {
+		return newError(ErrBalanceOverflow, "%s wallet of user with id = %d can't hold that much money", account.Currency, account.User_id)
+	}
+	return nil
+}
+
+func errNoUser(userId int) error {
+	return newError(ErrAccountNotFound, "No user with id = %d", userId)
+}
+
+func errNoWallet(wallet model.Wallet) error {
+	return newError(ErrAccountNotFound, "No %s wallet of user with id = %d", wallet.Currency, wallet.User_id)
+}
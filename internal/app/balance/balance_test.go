@@ -0,0 +1,177 @@
+package balance_test
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+	"time"
+	"user_balance_microservice/internal/app/balance"
+	"user_balance_microservice/internal/app/exchange"
+	"user_balance_microservice/internal/app/model"
+	"user_balance_microservice/internal/app/store"
+	"user_balance_microservice/internal/app/store/teststore"
+)
+
+func TestService(t *testing.T) {
+	store := teststore.New()
+	s := balance.New(store, nil, 0)
+	ctx := context.Background()
+	wallet := model.Wallet{User_id: 1, Currency: "RUB"}
+
+	_, err := s.Balance(ctx, 1)
+	assert.ErrorIs(t, err, balance.ErrAccountNotFound)
+
+	_, deposit, err := s.Deposit(ctx, wallet, 10000)
+	assert.NoError(t, err)
+	assert.NotZero(t, deposit.Id)
+
+	_, _, err = s.Reserve(ctx, balance.ReserveRequest{Wallet: wallet, Service_id: 1, Order_id: 1, Amount: 20000})
+	assert.ErrorIs(t, err, balance.ErrInsufficientFunds)
+	assert.Equal(t, "Not enough money for reserve. Current balance is 100.00", err.Error())
+
+	account, reservation, err := s.Reserve(ctx, balance.ReserveRequest{Wallet: wallet, Service_id: 1, Order_id: 1, Amount: 3000})
+	assert.NoError(t, err)
+	assert.Equal(t, model.Amount(7000), account.Balance)
+
+	charged := model.Amount(5000)
+	assert.ErrorIs(t, s.Confirm(ctx, balance.ById(reservation.Id), &charged), balance.ErrExceedsReservation)
+	charged = 2000
+	assert.NoError(t, s.Confirm(ctx, balance.ById(reservation.Id), &charged))
+	assert.ErrorIs(t, s.Abort(ctx, balance.ById(reservation.Id), nil), balance.ErrReservationNotFound)
+
+	refunded, err := s.Refund(ctx, balance.RefundRequest{User_id: 1, Service_id: 1, Order_id: 1, Currency: "RUB"})
+	assert.NoError(t, err)
+	assert.Equal(t, model.Amount(2000), refunded)
+	_, err = s.Refund(ctx, balance.RefundRequest{User_id: 1, Service_id: 1, Order_id: 1, Currency: "RUB"})
+	assert.ErrorIs(t, err, balance.ErrAlreadyRefunded)
+	_, err = s.Refund(ctx, balance.RefundRequest{User_id: 1, Service_id: 1, Order_id: 2, Currency: "RUB"})
+	assert.ErrorIs(t, err, balance.ErrChargeNotFound)
+
+	accounts, err := s.Balance(ctx, 1)
+	assert.NoError(t, err)
+	if assert.Len(t, accounts, 1) {
+		assert.Equal(t, model.Amount(10000), accounts[0].Balance)
+		assert.Equal(t, model.Amount(0), accounts[0].Reserved_balance)
+	}
+}
+
+func TestService_Transfer(t *testing.T) {
+	store := teststore.New()
+	ctx := context.Background()
+	from := model.Wallet{User_id: 1, Currency: "RUB"}
+	_, _, err := balance.New(store, nil, 0).Deposit(ctx, from, 10000)
+	assert.NoError(t, err)
+
+	s := balance.New(store, nil, 0)
+	_, err = s.Transfer(ctx, from, model.Wallet{User_id: 2, Currency: "RUB"}, 20000)
+	assert.ErrorIs(t, err, balance.ErrInsufficientFunds)
+	_, err = s.Transfer(ctx, model.Wallet{User_id: 3, Currency: "RUB"}, from, 100)
+	assert.ErrorIs(t, err, balance.ErrAccountNotFound)
+	_, err = s.Transfer(ctx, from, model.Wallet{User_id: 2, Currency: "KZT"}, 100)
+	assert.ErrorIs(t, err, balance.ErrExchangeDisabled)
+
+	s = balance.New(store, exchange.NewStaticProvider(exchange.Rates{Base: "RUB", Rates: map[string]float64{"KZT": 5}}), 0)
+	res, err := s.Transfer(ctx, from, model.Wallet{User_id: 2, Currency: "KZT"}, 1000)
+	assert.NoError(t, err)
+	assert.Equal(t, model.Amount(5000), res.In.Amount)
+	if assert.NotNil(t, res.Conversion) {
+		assert.Equal(t, 5.0, res.Conversion.Rate)
+	}
+
+	_, err = s.Transfer(ctx, from, model.Wallet{User_id: 2, Currency: "USD"}, 1000)
+	assert.ErrorIs(t, err, balance.ErrRateNotFound)
+	var balanceErr *balance.Error
+	assert.True(t, errors.As(err, &balanceErr))
+}
+
+func TestService_ConcurrentConfirmAndAbort(t *testing.T) {
+	st := teststore.New()
+	s := balance.New(st, nil, 0)
+	ctx := context.Background()
+	wallet := model.Wallet{User_id: 1, Currency: "RUB"}
+	_, _, err := s.Deposit(ctx, wallet, 10000)
+	assert.NoError(t, err)
+	_, reservation, err := s.Reserve(ctx, balance.ReserveRequest{Wallet: wallet, Service_id: 1, Order_id: 1, Amount: 3000})
+	assert.NoError(t, err)
+
+	// The partial abort starts once the confirm has read the reservation and
+	// gets as far as it can before the confirm goes on.
+	aborted := make(chan error, 1)
+	lookup := func(ctx context.Context, tx store.TxStore) (*model.Transaction, error) {
+		found, err := balance.ById(reservation.Id)(ctx, tx)
+		go func() {
+			released := model.Amount(1000)
+			aborted <- s.Abort(ctx, balance.ById(reservation.Id), &released)
+		}()
+		select {
+		case err := <-aborted:
+			aborted <- err
+		case <-time.After(100 * time.Millisecond):
+		}
+		return found, err
+	}
+	assert.NoError(t, s.Confirm(ctx, lookup, nil))
+	assert.ErrorIs(t, <-aborted, balance.ErrReservationNotFound)
+
+	accounts, err := s.Balance(ctx, 1)
+	assert.NoError(t, err)
+	if assert.Len(t, accounts, 1) {
+		assert.Equal(t, model.Amount(7000), accounts[0].Balance)
+		assert.Equal(t, model.Amount(0), accounts[0].Reserved_balance)
+	}
+}
+
+func TestService_ExpirePartlyReleased(t *testing.T) {
+	s := balance.New(teststore.New(), nil, 0)
+	ctx := context.Background()
+	wallet := model.Wallet{User_id: 1, Currency: "RUB"}
+	_, _, err := s.Deposit(ctx, wallet, 10000)
+	assert.NoError(t, err)
+	_, reservation, err := s.Reserve(ctx, balance.ReserveRequest{Wallet: wallet, Service_id: 1, Order_id: 1, Amount: 3000})
+	assert.NoError(t, err)
+
+	released := model.Amount(1000)
+	assert.NoError(t, s.Abort(ctx, balance.ById(reservation.Id), &released))
+	assert.NoError(t, s.Expire(ctx, reservation))
+	assert.ErrorIs(t, s.Expire(ctx, reservation), balance.ErrReservationNotFound)
+
+	accounts, err := s.Balance(ctx, 1)
+	assert.NoError(t, err)
+	if assert.Len(t, accounts, 1) {
+		assert.Equal(t, model.Amount(10000), accounts[0].Balance)
+		assert.Equal(t, model.Amount(0), accounts[0].Reserved_balance)
+	}
+}
+
+func TestService_Overflow(t *testing.T) {
+	st := teststore.New()
+	s := balance.New(st, nil, 0)
+	ctx := context.Background()
+	wallet := model.Wallet{User_id: 1, Currency: "RUB"}
+	other := model.Wallet{User_id: 2, Currency: "RUB"}
+	_, _, err := s.Deposit(ctx, wallet, 10000)
+	assert.NoError(t, err)
+	_, _, err = s.Reserve(ctx, balance.ReserveRequest{Wallet: wallet, Service_id: 1, Order_id: 1, Amount: 3000})
+	assert.NoError(t, err)
+
+	_, _, err = s.Deposit(ctx, wallet, math.MaxInt64)
+	assert.ErrorIs(t, err, balance.ErrBalanceOverflow)
+	_, _, err = s.Deposit(ctx, wallet, math.MaxInt64-9999)
+	assert.ErrorIs(t, err, balance.ErrBalanceOverflow)
+	_, _, err = s.Deposit(ctx, wallet, math.MaxInt64-10000)
+	assert.NoError(t, err)
+
+	_, _, err = s.Deposit(ctx, other, 100)
+	assert.NoError(t, err)
+	_, err = s.Transfer(ctx, other, wallet, 100)
+	assert.ErrorIs(t, err, balance.ErrBalanceOverflow)
+
+	accounts, err := s.Balance(ctx, 1)
+	assert.NoError(t, err)
+	if assert.Len(t, accounts, 1) {
+		assert.Equal(t, model.Amount(math.MaxInt64-3000), accounts[0].Balance)
+		assert.Equal(t, model.Amount(3000), accounts[0].Reserved_balance)
+	}
+}
@@ -0,0 +1,48 @@
+package balance
+
+import (
+	"errors"
+	"fmt"
+)
+
+// The kinds of errors of the operations. Callers compare them with
+// errors.Is and read the details from the Error that wraps them.
+var (
+	ErrAccountNotFound     = errors.New("Account not found")
+	ErrInsufficientFunds   = errors.New("Not enough money on the balance")
+	ErrReservationNotFound = errors.New("No open reservation with such data")
+	ErrExceedsReservation  = errors.New("Amount can't be greater than the reserved amount")
+	ErrChargeNotFound      = errors.New("No confirmed charge with such data")
+	ErrAlreadyRefunded     = errors.New("Charge is already refunded")
+	ErrRefundExceedsCharge = errors.New("Refund amount exceeds the charged amount")
+	ErrExchangeDisabled    = errors.New("Currency conversion is not configured")
+	ErrRateNotFound        = errors.New("No exchange rate for the currency pair")
+	ErrAmountTooSmall      = errors.New("Amount is too small to convert")
+	ErrRatesUnavailable    = errors.New("Exchange rates are unavailable")
+	ErrBalanceOverflow     = errors.New("Balance can't hold that much money")
+)
+
+// Error is an operation refused by the state of the accounts. Kind is one of
+// the errors above, Message describes the case for the user, and Err is the
+// cause, if any, which is not meant for the user.
+type Error struct {
+	Kind    error
+	Message string
+	Err     error
+}
+
+func newError(kind error, format string, args ...interface{}) *Error {
+	return &Error{Kind: kind, Message: fmt.Sprintf(format, args...)}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Is(target error) bool {
+	return e.Kind == target
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,27 @@
+package balance
+
+import (
+	"context"
+	"user_balance_microservice/internal/app/model"
+	"user_balance_microservice/internal/app/store"
+)
+
+// postEntry records the journal entry of an operation on transaction. The
+// stored balances are checked against the ledger by the reconciliation, not
+// here, as that sums every posting of the accounts.
+func postEntry(ctx context.Context, tx store.TxStore, transaction *model.Transaction, description string, postings []model.Posting) error {
+	return tx.Ledger().Post(ctx, &model.JournalEntry{
+		Transaction_id: transaction.Id,
+		Description:    description,
+		Postings:       postings,
+	})
+}
+
+// move is the pair of postings that moves amount from one ledger account to
+// another.
+func move(from, to string, amount model.Amount) []model.Posting {
+	return []model.Posting{
+		{Account: from, Amount: -amount},
+		{Account: to, Amount: amount},
+	}
+}
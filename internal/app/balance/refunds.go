@@ -0,0 +1,110 @@
+package balance
+
+import (
+	"context"
+	"user_balance_microservice/internal/app/model"
+	"user_balance_microservice/internal/app/store"
+)
+
+// RefundRequest returns the charges of an order to the user. A nil Amount
+// refunds everything not refunded yet.
+type RefundRequest struct {
+	User_id    int
+	Service_id int
+	Order_id   int
+	Currency   string
+	Amount     *model.Amount
+}
+
+// Refund returns the charges of the order to the user's balance and returns
+// the refunded amount.
+func (s *Service) Refund(ctx context.Context, req RefundRequest) (model.Amount, error) {
+	var refunded model.Amount
+	err := s.store.WithinTx(ctx, func(tx store.TxStore) error {
+		charges, err := tx.Transaction().GetCharges(ctx, req.User_id, req.Service_id, req.Order_id, req.Currency)
+		if err != nil {
+			return err
+		}
+		refunded, err = refundCharges(ctx, tx, charges, req.Amount)
+		return err
+	})
+	return refunded, err
+}
+
+// refundCharges returns amount of the confirmed charges to the user's
+// balance, or everything that is not refunded yet when amount is nil. The
+// charges are refunded oldest first, each refund being an entry linked to
+// the charge it returns. It returns the refunded amount.
+func refundCharges(ctx context.Context, tx store.TxStore, charges []model.Transaction, amount *model.Amount) (model.Amount, error) {
+	if len(charges) == 0 {
+		return 0, newError(ErrChargeNotFound, "%v", ErrChargeNotFound)
+	}
+
+	refundable := make([]model.Amount, len(charges))
+	total := model.Amount(0)
+	for i, charge := range charges {
+		refunded, err := tx.Transaction().GetRefundedAmount(ctx, charge.Id)
+		if err != nil {
+			return 0, err
+		}
+		refundable[i] = charge.Amount - refunded
+		total += refundable[i]
+	}
+
+	requested := total
+	if amount != nil {
+		requested = *amount
+	}
+	if total == 0 {
+		return 0, newError(ErrAlreadyRefunded, "%v", ErrAlreadyRefunded)
+	}
+	if requested > total {
+		return 0, newError(ErrRefundExceedsCharge, "Refund amount exceeds the charged amount, %s can be refunded", model.Money{Amount: total, Currency: charges[0].Currency})
+	}
+
+	wallet := charges[0].Wallet()
+	accounts, err := tx.UserAccount().FindForUpdate(ctx, wallet)
+	if err != nil {
+		return 0, err
+	}
+	if account, ok := accounts[wallet]; ok {
+		if err := ensureRoom(account, requested); err != nil {
+			return 0, err
+		}
+	}
+
+	left := requested
+	for i := range charges {
+		if left == 0 {
+			break
+		}
+		part := refundable[i]
+		if part > left {
+			part = left
+		}
+		if part == 0 {
+			continue
+		}
+		refund := linkedTransaction(&charges[i], part, "refund", "Возврат средств за услугу")
+		if err := tx.Transaction().CreateLinkedTransaction(ctx, refund); err != nil {
+			return 0, err
+		}
+		if _, err := tx.UserAccount().Add(ctx, &model.UserAccount{
+			User_id:  refund.User_id,
+			Currency: refund.Currency,
+			Balance:  part,
+		}); err != nil {
+			return 0, err
+		}
+		if err := postEntry(ctx, tx, refund, refund.Description, move(
+			model.RevenueLedgerAccount(refund.Service_id, refund.Currency),
+			model.UserLedgerAccount(refund.Wallet()),
+			part,
+		)); err != nil {
+			return 0, err
+		}
+		left -= part
+	}
+
+	return requested, nil
+}
@@ -0,0 +1,296 @@
+package balance
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+	"user_balance_microservice/internal/app/model"
+	"user_balance_microservice/internal/app/store"
+)
+
+// ReserveRequest holds money of a wallet for an order of a service until it
+// is confirmed or aborted. A nil Expires_at never expires.
+type ReserveRequest struct {
+	Wallet     model.Wallet
+	Service_id int
+	Order_id   int
+	Amount     model.Amount
+	Expires_at *time.Time
+}
+
+// Lookup finds the open reservation an operation is about and locks it
+// until the end of tx.
+type Lookup func(ctx context.Context, tx store.TxStore) (*model.Transaction, error)
+
+// ById looks the reservation up by its id.
+func ById(id int) Lookup {
+	return func(ctx context.Context, tx store.TxStore) (*model.Transaction, error) {
+		return tx.Transaction().LockReservation(ctx, id)
+	}
+}
+
+// ByOrder looks the reservation up by the data it was made with.
+func ByOrder(search *model.Transaction) Lookup {
+	return func(ctx context.Context, tx store.TxStore) (*model.Transaction, error) {
+		found, err := tx.Transaction().GetTransaction(ctx, search)
+		if err != nil {
+			return nil, err
+		}
+		reservation, err := tx.Transaction().LockReservation(ctx, found.Id)
+		if err != nil {
+			return nil, err
+		}
+		// A part of it may have been released before the lock was taken.
+		if reservation.Amount != search.Amount {
+			return nil, sql.ErrNoRows
+		}
+		return reservation, nil
+	}
+}
+
+// Reserve holds the amount on the user's wallet. It returns the wallet as
+// stored after the reserve and the reservation.
+func (s *Service) Reserve(ctx context.Context, req ReserveRequest) (*model.UserAccount, *model.Transaction, error) {
+	reservation := &model.Transaction{
+		User_id:     req.Wallet.User_id,
+		Amount:      req.Amount,
+		Currency:    req.Wallet.Currency,
+		Description: "Списание средств за услугу",
+		Service_id:  req.Service_id,
+		Order_id:    req.Order_id,
+		Type:        "reserve",
+		Expires_at:  req.Expires_at,
+	}
+	wallet := req.Wallet
+	var account *model.UserAccount
+
+	if err := s.store.WithinTx(ctx, func(tx store.TxStore) error {
+		accounts, err := tx.UserAccount().FindForUpdate(ctx, wallet)
+		if err != nil {
+			return err
+		}
+		stored, ok := accounts[wallet]
+		if !ok {
+			return errNoWallet(wallet)
+		}
+		if stored.Balance < reservation.Amount {
+			return newError(ErrInsufficientFunds, "Not enough money for reserve. Current balance is %s", model.Money{Amount: stored.Balance, Currency: wallet.Currency})
+		}
+
+		if account, err = tx.UserAccount().Reserve(ctx, &model.UserAccount{
+			User_id:  wallet.User_id,
+			Currency: wallet.Currency,
+			Balance:  reservation.Amount,
+		}); err != nil {
+			return err
+		}
+		if err := tx.Transaction().CreateReserveTransaction(ctx, reservation); err != nil {
+			return err
+		}
+		return postEntry(ctx, tx, reservation, "Резервирование средств",
+			move(model.UserLedgerAccount(wallet), model.ReservedLedgerAccount(wallet), reservation.Amount))
+	}); err != nil {
+		return nil, nil, err
+	}
+	return account, reservation, nil
+}
+
+// Reservation returns the open reservation with the id.
+func (s *Service) Reservation(ctx context.Context, id int) (*model.Transaction, error) {
+	reservation, err := s.store.Transaction().GetReservation(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, newError(ErrReservationNotFound, "No open reservation with such id")
+	}
+	return reservation, err
+}
+
+// OpenReservations returns the reservations of the user that are neither
+// confirmed nor aborted yet, oldest first.
+func (s *Service) OpenReservations(ctx context.Context, userId int) ([]model.Transaction, error) {
+	if _, err := s.Balance(ctx, userId); err != nil {
+		return nil, err
+	}
+	return s.store.Transaction().GetOpenReservations(ctx, userId)
+}
+
+// Confirm charges the user for the reservation found by lookup: the whole of
+// it when charged is nil.
+func (s *Service) Confirm(ctx context.Context, lookup Lookup, charged *model.Amount) error {
+	err := s.store.WithinTx(ctx, func(tx store.TxStore) error {
+		reservation, err := lookup(ctx, tx)
+		if err != nil {
+			return err
+		}
+		amount := reservation.Amount
+		if charged != nil {
+			if *charged > amount {
+				return newError(ErrExceedsReservation, "Charged amount can't be greater than the reserved amount")
+			}
+			amount = *charged
+		}
+		return confirmReservation(ctx, tx, reservation, amount)
+	})
+	return reservationError(err)
+}
+
+// Abort returns the reservation found by lookup to the user's balance: the
+// whole of it when released is nil, in which case the reservation is
+// closed.
+func (s *Service) Abort(ctx context.Context, lookup Lookup, released *model.Amount) error {
+	err := s.store.WithinTx(ctx, func(tx store.TxStore) error {
+		reservation, err := lookup(ctx, tx)
+		if err != nil {
+			return err
+		}
+		amount := reservation.Amount
+		if released != nil {
+			if *released > amount {
+				return newError(ErrExceedsReservation, "Released amount can't be greater than the reserved amount")
+			}
+			amount = *released
+		}
+		return releaseReservation(ctx, tx, reservation, amount)
+	})
+	return reservationError(err)
+}
+
+// Expire aborts a reservation that expired, with the amount it holds when
+// it is aborted. It fails with ErrReservationNotFound if the reservation was
+// closed meanwhile.
+func (s *Service) Expire(ctx context.Context, reservation *model.Transaction) error {
+	err := s.store.WithinTx(ctx, func(tx store.TxStore) error {
+		locked, err := tx.Transaction().LockReservation(ctx, reservation.Id)
+		if err != nil {
+			return err
+		}
+		return abortReservation(ctx, tx, locked)
+	})
+	return reservationError(err)
+}
+
+func reservationError(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return newError(ErrReservationNotFound, "%v", ErrReservationNotFound)
+	}
+	return err
+}
+
+// The functions below take a reservation locked by LockReservation, so its
+// amount can't change under them until the transaction ends. A concurrent
+// request for the same reservation waits for the lock, then finds it closed
+// or with the amount that is left.
+
+// confirmReservation charges the user for an open reservation. If only a
+// part of it is charged, the rest returns to the balance: the reservation is
+// closed without success, and the charged and released parts are recorded
+// as separate entries linked to it.
+func confirmReservation(ctx context.Context, tx store.TxStore, reservation *model.Transaction, charged model.Amount) error {
+	if charged == reservation.Amount {
+		if err := tx.Transaction().ConfirmReserveTransaction(ctx, reservation.Id); err != nil {
+			return err
+		}
+		if _, err := tx.UserAccount().ConfirmReserve(ctx, &model.UserAccount{
+			User_id:  reservation.User_id,
+			Currency: reservation.Currency,
+			Balance:  charged,
+		}); err != nil {
+			return err
+		}
+		return postCharge(ctx, tx, reservation, charged)
+	}
+
+	if err := tx.Transaction().AbortReserveTransaction(ctx, reservation.Id); err != nil {
+		return err
+	}
+	charge := linkedTransaction(reservation, charged, "charge", "Списание средств за услугу")
+	if err := tx.Transaction().CreateLinkedTransaction(ctx, charge); err != nil {
+		return err
+	}
+	if _, err := tx.UserAccount().ConfirmReserve(ctx, &model.UserAccount{
+		User_id:  reservation.User_id,
+		Currency: reservation.Currency,
+		Balance:  charged,
+	}); err != nil {
+		return err
+	}
+	if err := postCharge(ctx, tx, charge, charged); err != nil {
+		return err
+	}
+	return release(ctx, tx, reservation, reservation.Amount-charged)
+}
+
+// abortReservation closes an open reservation and returns its amount to the
+// user's balance.
+func abortReservation(ctx context.Context, tx store.TxStore, reservation *model.Transaction) error {
+	if err := tx.Transaction().AbortReserveTransaction(ctx, reservation.Id); err != nil {
+		return err
+	}
+	if _, err := tx.UserAccount().AbortReserve(ctx, &model.UserAccount{
+		User_id:  reservation.User_id,
+		Currency: reservation.Currency,
+		Balance:  reservation.Amount,
+	}); err != nil {
+		return err
+	}
+	return postRelease(ctx, tx, reservation, reservation.Amount)
+}
+
+// releaseReservation returns a part of an open reservation to the user's
+// balance. The reservation stays open with the remaining amount.
+func releaseReservation(ctx context.Context, tx store.TxStore, reservation *model.Transaction, released model.Amount) error {
+	if released == reservation.Amount {
+		return abortReservation(ctx, tx, reservation)
+	}
+
+	if err := tx.Transaction().ReduceReserveTransaction(ctx, reservation.Id, released); err != nil {
+		return err
+	}
+	return release(ctx, tx, reservation, released)
+}
+
+func release(ctx context.Context, tx store.TxStore, reservation *model.Transaction, amount model.Amount) error {
+	entry := linkedTransaction(reservation, amount, "release", "Возврат зарезервированных средств")
+	if err := tx.Transaction().CreateLinkedTransaction(ctx, entry); err != nil {
+		return err
+	}
+	if _, err := tx.UserAccount().AbortReserve(ctx, &model.UserAccount{
+		User_id:  reservation.User_id,
+		Currency: reservation.Currency,
+		Balance:  amount,
+	}); err != nil {
+		return err
+	}
+	return postRelease(ctx, tx, entry, amount)
+}
+
+func postCharge(ctx context.Context, tx store.TxStore, transaction *model.Transaction, amount model.Amount) error {
+	return postEntry(ctx, tx, transaction, "Списание зарезервированных средств", move(
+		model.ReservedLedgerAccount(transaction.Wallet()),
+		model.RevenueLedgerAccount(transaction.Service_id, transaction.Currency),
+		amount,
+	))
+}
+
+func postRelease(ctx context.Context, tx store.TxStore, transaction *model.Transaction, amount model.Amount) error {
+	return postEntry(ctx, tx, transaction, "Возврат зарезервированных средств", move(
+		model.ReservedLedgerAccount(transaction.Wallet()),
+		model.UserLedgerAccount(transaction.Wallet()),
+		amount,
+	))
+}
+
+func linkedTransaction(reservation *model.Transaction, amount model.Amount, kind, description string) *model.Transaction {
+	return &model.Transaction{
+		User_id:     reservation.User_id,
+		Amount:      amount,
+		Currency:    reservation.Currency,
+		Description: description,
+		Order_id:    reservation.Order_id,
+		Service_id:  reservation.Service_id,
+		Closed_date: time.Now(),
+		Success_flg: true,
+		Type:        kind,
+		Parent_id:   reservation.Id,
+	}
+}
@@ -0,0 +1,133 @@
+package balance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+	"user_balance_microservice/internal/app/exchange"
+	"user_balance_microservice/internal/app/model"
+	"user_balance_microservice/internal/app/store"
+)
+
+// TransferResult is what a transfer recorded: the transactions of both
+// users and the conversion, if the currency was exchanged.
+type TransferResult struct {
+	Out        *model.Transaction
+	In         *model.Transaction
+	Conversion *model.Conversion
+}
+
+// Transfer moves amount from one wallet to another, exchanging it when the
+// wallets are in different currencies. The wallet of the receiver is opened
+// if it has none in that currency.
+func (s *Service) Transfer(ctx context.Context, from, to model.Wallet, amount model.Amount) (*TransferResult, error) {
+	// Rates are fetched before any wallet is locked.
+	credit := amount
+	var conversion *model.Conversion
+	if to.Currency != from.Currency {
+		var err error
+		if conversion, err = s.convert(ctx, from.Currency, to.Currency, amount); err != nil {
+			return nil, err
+		}
+		credit = conversion.To_amount
+	}
+
+	transactionFrom := &model.Transaction{
+		User_id:     from.User_id,
+		Amount:      amount,
+		Currency:    from.Currency,
+		Description: fmt.Sprintf("Перевод средств пользователю id=%d", to.User_id),
+		Closed_date: time.Now(),
+		Success_flg: true,
+		Type:        "transfer_out",
+	}
+
+	transactionTo := &model.Transaction{
+		User_id:     to.User_id,
+		Amount:      credit,
+		Currency:    to.Currency,
+		Description: fmt.Sprintf("Перевод средств от пользователя id=%d", from.User_id),
+		Closed_date: time.Now(),
+		Success_flg: true,
+		Type:        "transfer_in",
+	}
+
+	if err := s.store.WithinTx(ctx, func(tx store.TxStore) error {
+		accounts, err := tx.UserAccount().FindForUpdate(ctx, from, to)
+		if err != nil {
+			return err
+		}
+		accountFrom, ok := accounts[from]
+		if !ok {
+			return errNoWallet(from)
+		}
+		if accountFrom.Balance < amount {
+			return newError(ErrInsufficientFunds, "Not enough money for transfer. Current balance is %s", model.Money{Amount: accountFrom.Balance, Currency: from.Currency})
+		}
+
+		if accountTo, ok := accounts[to]; ok {
+			if err := ensureRoom(accountTo, credit); err != nil {
+				return err
+			}
+		} else {
+			accountTo := &model.UserAccount{
+				User_id:  to.User_id,
+				Currency: to.Currency,
+				Balance:  0,
+			}
+			if err := tx.UserAccount().Create(ctx, accountTo); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.UserAccount().Transfer(ctx, from, to, amount, credit); err != nil {
+			return err
+		}
+
+		description := "Перевод средств"
+		postings := move(model.UserLedgerAccount(from), model.UserLedgerAccount(to), amount)
+		if conversion != nil {
+			if err := tx.Conversion().Create(ctx, conversion); err != nil {
+				return err
+			}
+			transactionFrom.Conversion_id = conversion.Id
+			transactionTo.Conversion_id = conversion.Id
+
+			description = "Перевод средств с обменом валюты"
+			postings = append(
+				move(model.UserLedgerAccount(from), model.ExchangeLedgerAccount(from.Currency), amount),
+				move(model.ExchangeLedgerAccount(to.Currency), model.UserLedgerAccount(to), credit)...,
+			)
+		}
+
+		if err := tx.Transaction().CreateAddTransaction(ctx, transactionTo); err != nil {
+			return err
+		}
+		if err := tx.Transaction().CreateAddTransaction(ctx, transactionFrom); err != nil {
+			return err
+		}
+		return postEntry(ctx, tx, transactionFrom, description, postings)
+	}); err != nil {
+		return nil, err
+	}
+	return &TransferResult{Out: transactionFrom, In: transactionTo, Conversion: conversion}, nil
+}
+
+// convert prices amount of currency from in currency to, applying the
+// spread.
+func (s *Service) convert(ctx context.Context, from, to string, amount model.Amount) (*model.Conversion, error) {
+	if s.rates == nil {
+		return nil, newError(ErrExchangeDisabled, "%v", ErrExchangeDisabled)
+	}
+
+	conversion, err := exchange.Convert(ctx, s.rates, from, to, amount, s.spread)
+	switch {
+	case errors.Is(err, exchange.ErrUnknownRate):
+		return nil, newError(ErrRateNotFound, "%v", err)
+	case errors.Is(err, exchange.ErrTooSmall), errors.Is(err, model.ErrAmountRange):
+		return nil, newError(ErrAmountTooSmall, "%v", err)
+	case err != nil:
+		return nil, &Error{Kind: ErrRatesUnavailable, Message: ErrRatesUnavailable.Error(), Err: err}
+	}
+	return conversion, nil
+}
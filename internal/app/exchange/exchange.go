@@ -0,0 +1,149 @@
+// Package exchange converts money between currencies at the rates given by a
+// RatesProvider.
+package exchange
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"math/big"
+	"os"
+	"time"
+	"user_balance_microservice/internal/app/model"
+)
+
+var (
+	ErrUnknownRate = errors.New("No exchange rate for the currency pair")
+	ErrTooSmall    = errors.New("Amount is too small to convert")
+)
+
+// RatesProvider returns how many units of currency to are given for one unit
+// of currency from.
+type RatesProvider interface {
+	Rate(ctx context.Context, from, to string) (float64, error)
+}
+
+// Rates is the price of one unit of the base currency in other currencies,
+// as read from a rates file or a rates service:
+//
+//	{"base": "RUB", "rates": {"KZT": 5.2, "USD": 0.0104}}
+type Rates struct {
+	Base  string             `json:"base"`
+	Rates map[string]float64 `json:"rates"`
+}
+
+// Rate returns the cross rate of the pair through the base currency.
+func (r *Rates) Rate(from, to string) (float64, error) {
+	fromRate, ok := r.rate(from)
+	if !ok {
+		return 0, ErrUnknownRate
+	}
+	toRate, ok := r.rate(to)
+	if !ok {
+		return 0, ErrUnknownRate
+	}
+	return toRate / fromRate, nil
+}
+
+func (r *Rates) rate(currency string) (float64, bool) {
+	if currency == r.Base {
+		return 1, true
+	}
+	rate, ok := r.Rates[currency]
+	if !ok || rate <= 0 || math.IsNaN(rate) || math.IsInf(rate, 0) {
+		return 0, false
+	}
+	return rate, true
+}
+
+// StaticProvider serves rates that never change, usually read from a file.
+type StaticProvider struct {
+	rates Rates
+}
+
+func NewStaticProvider(rates Rates) *StaticProvider {
+	return &StaticProvider{rates: rates}
+}
+
+// LoadFile reads a StaticProvider from a JSON rates file.
+func LoadFile(path string) (*StaticProvider, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	rates := Rates{}
+	if err := json.NewDecoder(f).Decode(&rates); err != nil {
+		return nil, err
+	}
+	return NewStaticProvider(rates), nil
+}
+
+func (p *StaticProvider) Rate(_ context.Context, from, to string) (float64, error) {
+	return p.rates.Rate(from, to)
+}
+
+// Convert exchanges amount of currency from into currency to. The spread is
+// the share of the market rate kept by the service, so the applied rate is
+// rate * (1 - spread) rounded half up to 10 decimal places. The amount is
+// multiplied by it exactly, as a fraction, and rounded down to a minor unit
+// of currency to, so no float rounding gets into large amounts.
+func Convert(ctx context.Context, provider RatesProvider, from, to string, amount model.Amount, spread float64) (*model.Conversion, error) {
+	rate, err := provider.Rate(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	marketRate, spreadShare := new(big.Rat), new(big.Rat)
+	if marketRate.SetFloat64(rate) == nil || spreadShare.SetFloat64(spread) == nil {
+		return nil, ErrUnknownRate
+	}
+	applied := round(new(big.Rat).Mul(marketRate, new(big.Rat).Sub(big.NewRat(1, 1), spreadShare)), 10)
+	converted := new(big.Rat).Mul(big.NewRat(int64(amount), 1), applied)
+	converted.Mul(converted, pow10(model.Exponent(to)-model.Exponent(from)))
+	minor := floor(converted)
+	if !minor.IsInt64() {
+		return nil, model.ErrAmountRange
+	}
+	toAmount := model.Amount(minor.Int64())
+	if toAmount <= 0 {
+		return nil, ErrTooSmall
+	}
+	appliedRate, _ := applied.Float64()
+
+	return &model.Conversion{
+		From_currency: from,
+		From_amount:   amount,
+		To_currency:   to,
+		To_amount:     toAmount,
+		Rate:          appliedRate,
+		Spread:        spread,
+		Created_at:    time.Now(),
+	}, nil
+}
+
+// round rounds x half up to the given number of decimal places, which also
+// drops the noise of the float it was made of.
+func round(x *big.Rat, places int) *big.Rat {
+	p := pow10(places)
+	scaled := new(big.Rat).Mul(x, p)
+	scaled.Add(scaled, big.NewRat(1, 2))
+	return new(big.Rat).Quo(new(big.Rat).SetInt(floor(scaled)), p)
+}
+
+// floor rounds x down to an integer.
+func floor(x *big.Rat) *big.Int {
+	// The denominator is positive, so the Euclidean quotient is the floor.
+	q, _ := new(big.Int).DivMod(x.Num(), x.Denom(), new(big.Int))
+	return q
+}
+
+// pow10 returns 10 to the power of n, which may be negative.
+func pow10(n int) *big.Rat {
+	if n < 0 {
+		return new(big.Rat).Inv(pow10(-n))
+	}
+	return new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil))
+}
@@ -0,0 +1,141 @@
+package exchange_test
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+	"user_balance_microservice/internal/app/exchange"
+	"user_balance_microservice/internal/app/model"
+)
+
+var testRates = exchange.Rates{
+	Base:  "RUB",
+	Rates: map[string]float64{"KZT": 5.2, "USD": 0.0104},
+}
+
+func TestRates_Rate(t *testing.T) {
+	testCases := []struct {
+		name     string
+		from, to string
+		expected float64
+		err      error
+	}{
+		{name: "from base", from: "RUB", to: "KZT", expected: 5.2},
+		{name: "to base", from: "USD", to: "RUB", expected: 1 / 0.0104},
+		{name: "cross", from: "USD", to: "KZT", expected: 5.2 / 0.0104},
+		{name: "unknown", from: "USD", to: "EUR", err: exchange.ErrUnknownRate},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rate, err := testRates.Rate(tc.from, tc.to)
+			assert.ErrorIs(t, err, tc.err)
+			assert.InDelta(t, tc.expected, rate, 1e-9)
+		})
+	}
+}
+
+func TestConvert(t *testing.T) {
+	provider := exchange.NewStaticProvider(testRates)
+
+	conversion, err := exchange.Convert(context.Background(), provider, "RUB", "KZT", 1000, 0.01)
+	assert.NoError(t, err)
+	assert.Equal(t, "RUB", conversion.From_currency)
+	assert.Equal(t, model.Amount(1000), conversion.From_amount)
+	assert.Equal(t, "KZT", conversion.To_currency)
+	assert.Equal(t, model.Amount(5148), conversion.To_amount)
+	assert.Equal(t, 5.148, conversion.Rate)
+	assert.Equal(t, 0.01, conversion.Spread)
+
+	conversion, err = exchange.Convert(context.Background(), provider, "RUB", "USD", 10000, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, model.Amount(104), conversion.To_amount)
+
+	_, err = exchange.Convert(context.Background(), provider, "RUB", "USD", 50, 0)
+	assert.ErrorIs(t, err, exchange.ErrTooSmall)
+
+	// A float64 keeps only 53 bits and would give 10400000000000000 here.
+	conversion, err = exchange.Convert(context.Background(), provider, "RUB", "USD", 999999999999999999, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, model.Amount(10399999999999999), conversion.To_amount)
+	_, err = exchange.Convert(context.Background(), provider, "RUB", "KZT", 1999999999999999999, 0)
+	assert.ErrorIs(t, err, model.ErrAmountRange)
+	_, err = exchange.Convert(context.Background(), provider, "RUB", "EUR", 50, 0)
+	assert.ErrorIs(t, err, exchange.ErrUnknownRate)
+}
+
+func TestLoadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rates.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`{"base":"RUB","rates":{"USD":0.0104}}`), 0o644))
+
+	provider, err := exchange.LoadFile(path)
+	assert.NoError(t, err)
+	rate, err := provider.Rate(context.Background(), "RUB", "USD")
+	assert.NoError(t, err)
+	assert.Equal(t, 0.0104, rate)
+
+	_, err = exchange.LoadFile(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
+
+func TestHTTPProvider(t *testing.T) {
+	var requests int32
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"base":"USD","rates":{"RUB":96,"KZT":500}}`))
+	}))
+	defer stub.Close()
+
+	provider := exchange.NewHTTPProvider(stub.URL, time.Hour)
+	rate, err := provider.Rate(context.Background(), "USD", "RUB")
+	assert.NoError(t, err)
+	assert.Equal(t, 96.0, rate)
+	rate, err = provider.Rate(context.Background(), "RUB", "KZT")
+	assert.NoError(t, err)
+	assert.InDelta(t, 500.0/96, rate, 1e-9)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+
+	_, err = provider.Rate(context.Background(), "USD", "EUR")
+	assert.ErrorIs(t, err, exchange.ErrUnknownRate)
+}
+
+func TestHTTPProvider_Errors(t *testing.T) {
+	testCases := []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{
+			name: "status",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusBadGateway)
+			},
+		}, {
+			name: "malformed",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(`{"base":`))
+			},
+		}, {
+			name: "no base",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(`{"rates":{"RUB":96}}`))
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			stub := httptest.NewServer(tc.handler)
+			defer stub.Close()
+
+			_, err := exchange.NewHTTPProvider(stub.URL, time.Hour).Rate(context.Background(), "USD", "RUB")
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package exchange
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// HTTPProvider fetches rates from a service answering GET requests with a
+// Rates document and keeps them for ttl. When a refresh fails the last rates
+// are used until they are twice as old as ttl.
+type HTTPProvider struct {
+	url    string
+	ttl    time.Duration
+	client *http.Client
+	now    func() time.Time
+
+	mu        sync.Mutex
+	rates     *Rates
+	fetchedAt time.Time
+}
+
+func NewHTTPProvider(url string, ttl time.Duration) *HTTPProvider {
+	return &HTTPProvider{
+		url:    url,
+		ttl:    ttl,
+		client: &http.Client{Timeout: 10 * time.Second},
+		now:    time.Now,
+	}
+}
+
+func (p *HTTPProvider) Rate(ctx context.Context, from, to string) (float64, error) {
+	rates, err := p.current(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return rates.Rate(from, to)
+}
+
+func (p *HTTPProvider) current(ctx context.Context) (*Rates, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	age := p.now().Sub(p.fetchedAt)
+	if p.rates != nil && age < p.ttl {
+		return p.rates, nil
+	}
+
+	rates, err := p.fetch(ctx)
+	if err != nil {
+		if p.rates != nil && age < 2*p.ttl {
+			return p.rates, nil
+		}
+		return nil, err
+	}
+	p.rates = rates
+	p.fetchedAt = p.now()
+	return rates, nil
+}
+
+func (p *HTTPProvider) fetch(ctx context.Context) (*Rates, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("rates service answered %s", res.Status)
+	}
+	rates := &Rates{}
+	if err := json.NewDecoder(res.Body).Decode(rates); err != nil {
+		return nil, err
+	}
+	if rates.Base == "" {
+		return nil, fmt.Errorf("rates service answered without a base currency")
+	}
+	return rates, nil
+}
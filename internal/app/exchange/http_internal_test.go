@@ -0,0 +1,49 @@
+package exchange
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHTTPProvider_stale(t *testing.T) {
+	rates := `{"base":"USD","rates":{"RUB":96}}`
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rates == "" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(rates))
+	}))
+	defer stub.Close()
+
+	now := time.Now()
+	provider := NewHTTPProvider(stub.URL, time.Minute)
+	provider.now = func() time.Time { return now }
+
+	rate, err := provider.Rate(context.Background(), "USD", "RUB")
+	assert.NoError(t, err)
+	assert.Equal(t, 96.0, rate)
+
+	rates = `{"base":"USD","rates":{"RUB":97}}`
+	now = now.Add(2 * time.Minute / 3)
+	rate, _ = provider.Rate(context.Background(), "USD", "RUB")
+	assert.Equal(t, 96.0, rate)
+
+	now = now.Add(time.Minute / 3)
+	rate, _ = provider.Rate(context.Background(), "USD", "RUB")
+	assert.Equal(t, 97.0, rate)
+
+	rates = ""
+	now = now.Add(3 * time.Minute / 2)
+	rate, err = provider.Rate(context.Background(), "USD", "RUB")
+	assert.NoError(t, err)
+	assert.Equal(t, 97.0, rate)
+
+	now = now.Add(time.Minute)
+	_, err = provider.Rate(context.Background(), "USD", "RUB")
+	assert.Error(t, err)
+}
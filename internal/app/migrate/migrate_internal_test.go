@@ -0,0 +1,81 @@
+package migrate
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoad(t *testing.T) {
+	migrations, err := Load()
+	assert.NoError(t, err)
+	assert.NotEmpty(t, migrations)
+	assert.Equal(t, 1, migrations[0].Version)
+	assert.Equal(t, "init", migrations[0].Name)
+	assert.Contains(t, migrations[0].Up, "CREATE TABLE user_accounts")
+	assert.NotContains(t, migrations[0].Up, "IF NOT EXISTS")
+	assert.NotContains(t, migrations[0].Up, "ON CONFLICT")
+	assert.NotContains(t, migrations[0].Up, "VALUES (1,")
+}
+
+func TestLoad_Files(t *testing.T) {
+	file := func(body string) *fstest.MapFile {
+		return &fstest.MapFile{Data: []byte(body)}
+	}
+
+	testCases := []struct {
+		name     string
+		fsys     fstest.MapFS
+		versions []int
+		isValid  bool
+	}{
+		{
+			name: "ordered by version",
+			fsys: fstest.MapFS{
+				"0010_later.up.sql":   file("up"),
+				"0010_later.down.sql": file("down"),
+				"0002_first.up.sql":   file("up"),
+				"0002_first.down.sql": file("down"),
+			},
+			versions: []int{2, 10},
+			isValid:  true,
+		}, {
+			name: "missing down",
+			fsys: fstest.MapFS{
+				"0001_init.up.sql": file("up"),
+			},
+			isValid: false,
+		}, {
+			name: "bad name",
+			fsys: fstest.MapFS{
+				"init.sql": file("up"),
+			},
+			isValid: false,
+		}, {
+			name: "two names",
+			fsys: fstest.MapFS{
+				"0001_init.up.sql":    file("up"),
+				"0001_other.down.sql": file("down"),
+			},
+			isValid: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			migrations, err := load(tc.fsys)
+			if !tc.isValid {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			versions := []int{}
+			for _, m := range migrations {
+				versions = append(versions, m.Version)
+				assert.Equal(t, "up", m.Up)
+				assert.Equal(t, "down", m.Down)
+			}
+			assert.Equal(t, tc.versions, versions)
+		})
+	}
+}
@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS postings;
+DROP FUNCTION IF EXISTS check_entry_balanced();
+DROP TABLE IF EXISTS journal_entries;
+DROP TABLE IF EXISTS discrepancies;
+DROP TABLE IF EXISTS idempotency_keys;
+DROP TABLE IF EXISTS transactions;
+DROP TABLE IF EXISTS user_accounts;
+DROP TABLE IF EXISTS servicies;
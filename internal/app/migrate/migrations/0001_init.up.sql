@@ -0,0 +1,85 @@
+-- The initial schema. It is applied to an empty database only: a table left
+-- by the former initDB.sql makes CREATE TABLE fail and the whole migration
+-- roll back, so such a database has to be recreated.
+
+CREATE TABLE servicies (
+     id bigserial primary key not null,
+     name varchar(60) not null unique
+    );
+
+CREATE TABLE user_accounts (
+    user_id bigserial primary key not null,
+    balance integer not null CHECK (balance >= 0),
+    reserved_balance integer not null CHECK (reserved_balance >= 0)
+    );
+
+CREATE TABLE transactions (
+    id  bigserial primary key not null,
+    user_id integer REFERENCES user_accounts (user_id) not null,
+    amount integer not null	,
+    description text,
+    order_id integer,
+    service_id integer REFERENCES servicies (id),
+    closed_date date,
+    expires_at timestamptz,
+    success_flg boolean not null default false,
+    type varchar(30) not null CHECK (type in ('add', 'reserve', 'charge', 'release', 'refund', 'transfer_in', 'transfer_out')),
+    parent_id bigint REFERENCES transactions (id)
+    );
+
+CREATE UNIQUE INDEX transactions_reserve_key
+    ON transactions (user_id, amount, order_id, service_id)
+    WHERE type = 'reserve';
+
+CREATE TABLE idempotency_keys (
+    key varchar(255) primary key not null,
+    request_hash varchar(64) not null,
+    status_code integer,
+    response bytea,
+    created_at timestamptz not null default now()
+    );
+
+CREATE TABLE discrepancies (
+    id bigserial primary key not null,
+    user_id integer REFERENCES user_accounts (user_id) not null,
+    balance integer not null,
+    expected_balance integer not null,
+    reserved_balance integer not null,
+    expected_reserved integer not null,
+    found_at timestamptz not null default now()
+    );
+
+-- The ledger: every operation posts a journal entry whose postings add up
+-- to zero. Accounts are user:<id>, reserved:<id>, revenue:<service id> and
+-- external, the source of top-ups.
+CREATE TABLE journal_entries (
+    id bigserial primary key not null,
+    transaction_id bigint REFERENCES transactions (id),
+    description text,
+    created_at timestamptz not null default now()
+    );
+
+CREATE TABLE postings (
+    id bigserial primary key not null,
+    entry_id bigint REFERENCES journal_entries (id) not null,
+    account varchar(64) not null,
+    amount integer not null
+    );
+
+CREATE INDEX postings_account_idx ON postings (account);
+
+CREATE FUNCTION check_entry_balanced() RETURNS trigger AS $$
+BEGIN
+    IF (SELECT sum(amount) FROM postings WHERE entry_id = NEW.entry_id) <> 0 THEN
+        RAISE EXCEPTION 'journal entry % is not balanced', NEW.entry_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE CONSTRAINT TRIGGER postings_balanced
+    AFTER INSERT ON postings
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW EXECUTE FUNCTION check_entry_balanced();
+
+INSERT INTO servicies (name) VALUES ('услуга 1'), ('услуга 2');
@@ -0,0 +1,27 @@
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM user_accounts WHERE currency <> 'RUB') THEN
+        RAISE EXCEPTION 'wallets in currencies other than RUB exist';
+    END IF;
+END
+$$;
+
+UPDATE postings SET account = left(account, length(account) - 4);
+
+DROP INDEX transactions_reserve_key;
+CREATE UNIQUE INDEX transactions_reserve_key
+    ON transactions (user_id, amount, order_id, service_id)
+    WHERE type = 'reserve';
+
+ALTER TABLE discrepancies DROP CONSTRAINT discrepancies_account_fkey;
+ALTER TABLE transactions DROP CONSTRAINT transactions_account_fkey;
+ALTER TABLE user_accounts DROP CONSTRAINT user_accounts_pkey;
+ALTER TABLE user_accounts ADD CONSTRAINT user_accounts_pkey PRIMARY KEY (user_id);
+ALTER TABLE transactions ADD CONSTRAINT transactions_user_id_fkey
+    FOREIGN KEY (user_id) REFERENCES user_accounts (user_id);
+ALTER TABLE discrepancies ADD CONSTRAINT discrepancies_user_id_fkey
+    FOREIGN KEY (user_id) REFERENCES user_accounts (user_id);
+
+ALTER TABLE discrepancies DROP COLUMN currency;
+ALTER TABLE transactions DROP COLUMN currency;
+ALTER TABLE user_accounts DROP COLUMN currency;
@@ -0,0 +1,26 @@
+-- Accounts become wallets: one per user and currency. Everything that
+-- existed so far was in roubles.
+
+ALTER TABLE user_accounts ADD COLUMN currency char(3) not null default 'RUB';
+ALTER TABLE transactions ADD COLUMN currency char(3) not null default 'RUB';
+ALTER TABLE discrepancies ADD COLUMN currency char(3) not null default 'RUB';
+ALTER TABLE user_accounts ALTER COLUMN currency DROP DEFAULT;
+ALTER TABLE transactions ALTER COLUMN currency DROP DEFAULT;
+ALTER TABLE discrepancies ALTER COLUMN currency DROP DEFAULT;
+
+ALTER TABLE transactions DROP CONSTRAINT transactions_user_id_fkey;
+ALTER TABLE discrepancies DROP CONSTRAINT discrepancies_user_id_fkey;
+ALTER TABLE user_accounts DROP CONSTRAINT user_accounts_pkey;
+ALTER TABLE user_accounts ADD CONSTRAINT user_accounts_pkey PRIMARY KEY (user_id, currency);
+ALTER TABLE transactions ADD CONSTRAINT transactions_account_fkey
+    FOREIGN KEY (user_id, currency) REFERENCES user_accounts (user_id, currency);
+ALTER TABLE discrepancies ADD CONSTRAINT discrepancies_account_fkey
+    FOREIGN KEY (user_id, currency) REFERENCES user_accounts (user_id, currency);
+
+DROP INDEX transactions_reserve_key;
+CREATE UNIQUE INDEX transactions_reserve_key
+    ON transactions (user_id, currency, amount, order_id, service_id)
+    WHERE type = 'reserve';
+
+-- Ledger accounts are kept per currency as well.
+UPDATE postings SET account = account || ':RUB';
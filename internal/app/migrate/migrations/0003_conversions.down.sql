@@ -0,0 +1,11 @@
+CREATE OR REPLACE FUNCTION check_entry_balanced() RETURNS trigger AS $$
+BEGIN
+    IF (SELECT sum(amount) FROM postings WHERE entry_id = NEW.entry_id) <> 0 THEN
+        RAISE EXCEPTION 'journal entry % is not balanced', NEW.entry_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+ALTER TABLE transactions DROP COLUMN conversion_id;
+DROP TABLE conversions;
@@ -0,0 +1,31 @@
+-- Cross-currency transfers: both legs of a transfer reference the
+-- conversion made, with the applied rate and the spread kept by the service.
+CREATE TABLE conversions (
+    id bigserial primary key not null,
+    from_currency char(3) not null,
+    from_amount integer not null CHECK (from_amount > 0),
+    to_currency char(3) not null,
+    to_amount integer not null CHECK (to_amount > 0),
+    rate numeric(20, 10) not null CHECK (rate > 0),
+    spread numeric(6, 5) not null CHECK (spread >= 0 AND spread < 1),
+    created_at timestamptz not null default now()
+    );
+
+ALTER TABLE transactions ADD COLUMN conversion_id bigint
+    CONSTRAINT transactions_conversion_id_fkey REFERENCES conversions (id);
+
+-- A journal entry may now move money in several currencies, and it has to
+-- balance in each of them. Ledger accounts end with their currency.
+CREATE OR REPLACE FUNCTION check_entry_balanced() RETURNS trigger AS $$
+BEGIN
+    IF EXISTS (
+        SELECT 1 FROM postings
+        WHERE entry_id = NEW.entry_id
+        GROUP BY substring(account from '[^:]+$')
+        HAVING sum(amount) <> 0
+    ) THEN
+        RAISE EXCEPTION 'journal entry % is not balanced', NEW.entry_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
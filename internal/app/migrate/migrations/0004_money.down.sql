@@ -0,0 +1,34 @@
+-- Whole units can't hold fractional amounts, so refuse to lose them.
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM user_accounts WHERE balance % 100 <> 0 OR reserved_balance % 100 <> 0)
+        OR EXISTS (SELECT 1 FROM transactions WHERE amount % 100 <> 0)
+        OR EXISTS (SELECT 1 FROM postings WHERE amount % 100 <> 0)
+        OR EXISTS (SELECT 1 FROM discrepancies WHERE balance % 100 <> 0 OR expected_balance % 100 <> 0
+            OR reserved_balance % 100 <> 0 OR expected_reserved % 100 <> 0)
+        OR EXISTS (SELECT 1 FROM conversions WHERE from_amount % 100 <> 0 OR to_amount % 100 <> 0)
+    THEN
+        RAISE EXCEPTION 'fractional amounts can''t be converted back to whole units';
+    END IF;
+END;
+$$;
+
+ALTER TABLE conversions
+    ALTER COLUMN from_amount TYPE integer USING from_amount / 100,
+    ALTER COLUMN to_amount TYPE integer USING to_amount / 100;
+
+ALTER TABLE discrepancies
+    ALTER COLUMN balance TYPE integer USING balance / 100,
+    ALTER COLUMN expected_balance TYPE integer USING expected_balance / 100,
+    ALTER COLUMN reserved_balance TYPE integer USING reserved_balance / 100,
+    ALTER COLUMN expected_reserved TYPE integer USING expected_reserved / 100;
+
+ALTER TABLE postings
+    ALTER COLUMN amount TYPE integer USING amount / 100;
+
+ALTER TABLE transactions
+    ALTER COLUMN amount TYPE integer USING amount / 100;
+
+ALTER TABLE user_accounts
+    ALTER COLUMN balance TYPE integer USING balance / 100,
+    ALTER COLUMN reserved_balance TYPE integer USING reserved_balance / 100;
@@ -0,0 +1,21 @@
+-- Amounts are now stored in minor units of their currency (kopecks, tiyns,
+-- cents) as bigint. Existing amounts were whole units.
+ALTER TABLE user_accounts
+    ALTER COLUMN balance TYPE bigint USING balance * 100,
+    ALTER COLUMN reserved_balance TYPE bigint USING reserved_balance * 100;
+
+ALTER TABLE transactions
+    ALTER COLUMN amount TYPE bigint USING amount * 100;
+
+ALTER TABLE postings
+    ALTER COLUMN amount TYPE bigint USING amount * 100;
+
+ALTER TABLE discrepancies
+    ALTER COLUMN balance TYPE bigint USING balance * 100,
+    ALTER COLUMN expected_balance TYPE bigint USING expected_balance * 100,
+    ALTER COLUMN reserved_balance TYPE bigint USING reserved_balance * 100,
+    ALTER COLUMN expected_reserved TYPE bigint USING expected_reserved * 100;
+
+ALTER TABLE conversions
+    ALTER COLUMN from_amount TYPE bigint USING from_amount * 100,
+    ALTER COLUMN to_amount TYPE bigint USING to_amount * 100;
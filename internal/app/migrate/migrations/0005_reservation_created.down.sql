@@ -0,0 +1,3 @@
+DROP INDEX transactions_open_reservations_idx;
+
+ALTER TABLE transactions DROP COLUMN created_at;
@@ -0,0 +1,18 @@
+-- Transactions remember when they were made, so the age of open
+-- reservations can be shown. Existing rows take the time of their first
+-- journal entry.
+ALTER TABLE transactions ADD COLUMN created_at timestamptz;
+
+UPDATE transactions t SET created_at = e.created_at
+    FROM (SELECT transaction_id, min(created_at) AS created_at FROM journal_entries GROUP BY transaction_id) e
+    WHERE e.transaction_id = t.id;
+
+UPDATE transactions SET created_at = coalesce(closed_date, now()) WHERE created_at IS NULL;
+
+ALTER TABLE transactions
+    ALTER COLUMN created_at SET DEFAULT now(),
+    ALTER COLUMN created_at SET NOT NULL;
+
+CREATE INDEX transactions_open_reservations_idx
+    ON transactions (user_id, created_at)
+    WHERE type = 'reserve' AND closed_date IS NULL;
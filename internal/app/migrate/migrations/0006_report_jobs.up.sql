@@ -0,0 +1,19 @@
+-- Reports can be generated in the background. A job is claimed by a worker
+-- while it runs; to_date is the first day after the report.
+CREATE TABLE report_jobs (
+    id serial PRIMARY KEY,
+    status text NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'running', 'done', 'failed')),
+    from_date date NOT NULL,
+    to_date date NOT NULL,
+    group_by text[] NOT NULL,
+    file text,
+    error text,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    started_at timestamptz,
+    finished_at timestamptz
+);
+
+CREATE INDEX report_jobs_unfinished_idx
+    ON report_jobs (created_at)
+    WHERE status IN ('pending', 'running');
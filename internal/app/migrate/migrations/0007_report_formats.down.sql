@@ -0,0 +1,4 @@
+ALTER TABLE report_jobs
+    DROP COLUMN delimiter,
+    DROP COLUMN bom,
+    DROP COLUMN format;
@@ -0,0 +1,7 @@
+-- Report jobs remember the format their report is saved in. Jobs queued
+-- before saved their reports as CSV.
+ALTER TABLE report_jobs
+    ADD COLUMN format text NOT NULL DEFAULT 'csv'
+        CHECK (format IN ('csv', 'xlsx', 'json')),
+    ADD COLUMN bom boolean NOT NULL DEFAULT false,
+    ADD COLUMN delimiter text NOT NULL DEFAULT ',';
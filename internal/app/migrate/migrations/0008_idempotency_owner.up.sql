@@ -0,0 +1,5 @@
+-- An idempotency key belongs to the request that took it, so that a request
+-- that lost the key to a retry can neither store its response nor release
+-- the key of the retry.
+ALTER TABLE idempotency_keys
+    ADD COLUMN owner varchar(64) NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+ALTER TABLE discrepancies
+    DROP COLUMN source;
@@ -0,0 +1,4 @@
+-- Reconciliation checks the stored balances against the transaction history
+-- and against the ledger; source tells which of them a discrepancy is with.
+ALTER TABLE discrepancies
+    ADD COLUMN source varchar(16) NOT NULL DEFAULT 'history';
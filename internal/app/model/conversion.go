@@ -0,0 +1,29 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Conversion is the currency exchange made by a cross-currency transfer.
+// Rate is the applied rate per major unit, with the spread already taken off
+// the market one.
+type Conversion struct {
+	Id            int       `json:"-"`
+	From_currency string    `json:"fromCurrency"`
+	From_amount   Amount    `json:"fromAmount"`
+	To_currency   string    `json:"toCurrency"`
+	To_amount     Amount    `json:"toAmount"`
+	Rate          float64   `json:"rate"`
+	Spread        float64   `json:"spread"`
+	Created_at    time.Time `json:"-"`
+}
+
+func (c Conversion) MarshalJSON() ([]byte, error) {
+	type conversion Conversion
+	return json.Marshal(struct {
+		conversion
+		From_amount Money `json:"fromAmount"`
+		To_amount   Money `json:"toAmount"`
+	}{conversion(c), Money{c.From_amount, c.From_currency}, Money{c.To_amount, c.To_currency}})
+}
@@ -0,0 +1,27 @@
+package model
+
+// DefaultCurrency is used when a request does not name a currency.
+const DefaultCurrency = "RUB"
+
+// Currencies maps the ISO 4217 codes of the supported currencies to their
+// exponent: the number of digits after the decimal point of the minor unit.
+var Currencies = map[string]int{
+	"RUB": 2,
+	"KZT": 2,
+	"USD": 2,
+}
+
+// Exponent returns the exponent of the currency, or 2 for a currency that is
+// not supported.
+func Exponent(currency string) int {
+	if exponent, ok := Currencies[currency]; ok {
+		return exponent
+	}
+	return 2
+}
+
+// Wallet identifies the account of a user in a currency.
+type Wallet struct {
+	User_id  int
+	Currency string
+}
@@ -0,0 +1,47 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// The sources reconciliation checks the stored balances against.
+const (
+	DiscrepancyHistory = "history"
+	DiscrepancyLedger  = "ledger"
+)
+
+// Discrepancy is an account whose stored balances differ from the ones its
+// transaction history or the ledger gives, as told by Source.
+type Discrepancy struct {
+	User_id           int       `json:"userId"`
+	Currency          string    `json:"currency"`
+	Balance           Amount    `json:"balance"`
+	Expected_balance  Amount    `json:"expectedBalance"`
+	Reserved_balance  Amount    `json:"reservedBalance"`
+	Expected_reserved Amount    `json:"expectedReserved"`
+	Source            string    `json:"source"`
+	Found_at          time.Time `json:"foundAt"`
+}
+
+func (d Discrepancy) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		User_id           int       `json:"userId"`
+		Currency          string    `json:"currency"`
+		Balance           Money     `json:"balance"`
+		Expected_balance  Money     `json:"expectedBalance"`
+		Reserved_balance  Money     `json:"reservedBalance"`
+		Expected_reserved Money     `json:"expectedReserved"`
+		Source            string    `json:"source"`
+		Found_at          time.Time `json:"foundAt"`
+	}{
+		d.User_id,
+		d.Currency,
+		Money{d.Balance, d.Currency},
+		Money{d.Expected_balance, d.Currency},
+		Money{d.Reserved_balance, d.Currency},
+		Money{d.Expected_reserved, d.Currency},
+		d.Source,
+		d.Found_at,
+	})
+}
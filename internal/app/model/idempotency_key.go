@@ -0,0 +1,16 @@
+package model
+
+import "time"
+
+// IdempotencyKey is a client supplied key of a money-moving request together
+// with the response it got. Status_code is 0 while the request is in progress.
+// Owner is a random token of the request that holds the key, so that a
+// request that lost the key to a retry can't change it anymore.
+type IdempotencyKey struct {
+	Key          string
+	Request_hash string
+	Owner        string
+	Status_code  int
+	Response     []byte
+	Created_at   time.Time
+}
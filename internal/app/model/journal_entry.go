@@ -0,0 +1,58 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// JournalEntry is a balanced set of postings made by a single operation. It
+// is linked to the transaction that describes the operation to the user.
+type JournalEntry struct {
+	Id             int
+	Transaction_id int
+	Description    string
+	Created_at     time.Time
+	Postings       []Posting
+}
+
+// Posting changes the balance of a ledger account by Amount, which is
+// negative when money leaves the account.
+type Posting struct {
+	Account string
+	Amount  Amount
+}
+
+// Every ledger account holds money in a single currency, which ends its code.
+
+// ExternalLedgerAccount is the ledger account money comes from when users
+// top up their balance.
+func ExternalLedgerAccount(currency string) string {
+	return fmt.Sprintf("external:%s", currency)
+}
+
+// UserLedgerAccount holds the available balance of the wallet.
+func UserLedgerAccount(wallet Wallet) string {
+	return fmt.Sprintf("user:%d:%s", wallet.User_id, wallet.Currency)
+}
+
+// ReservedLedgerAccount holds the money reserved in the wallet.
+func ReservedLedgerAccount(wallet Wallet) string {
+	return fmt.Sprintf("reserved:%d:%s", wallet.User_id, wallet.Currency)
+}
+
+// ExchangeLedgerAccount takes the money sold and gives the money bought by
+// cross-currency transfers, keeping the spread.
+func ExchangeLedgerAccount(currency string) string {
+	return fmt.Sprintf("exchange:%s", currency)
+}
+
+// RevenueLedgerAccount holds the money charged for the service.
+func RevenueLedgerAccount(serviceId int, currency string) string {
+	return fmt.Sprintf("revenue:%d:%s", serviceId, currency)
+}
+
+// LedgerAccountCurrency returns the currency of the ledger account.
+func LedgerAccountCurrency(account string) string {
+	return account[strings.LastIndex(account, ":")+1:]
+}
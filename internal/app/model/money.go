@@ -0,0 +1,120 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Amount is a sum of money in minor units of its currency: kopecks, tiyn or
+// cents. How many of them make a major unit is given by the exponent of the
+// currency.
+type Amount int64
+
+var (
+	ErrInvalidAmount   = errors.New("Amount has to be a decimal number like \"100.50\"")
+	ErrAmountPrecision = errors.New("Amount has more fractional digits than its currency allows")
+	ErrAmountRange     = errors.New("Amount is out of range")
+)
+
+// ParseAmount parses a decimal into minor units of a currency with the given
+// exponent. Only an optional minus sign, digits and an optional point
+// followed by at most exponent digits are accepted, so NaN, infinities,
+// exponent notation and a leading plus are rejected.
+func ParseAmount(s string, exponent int) (Amount, error) {
+	if err := checkDecimal(s); err != nil {
+		return 0, err
+	}
+	negative := strings.HasPrefix(s, "-")
+	whole, frac, _ := strings.Cut(strings.TrimPrefix(s, "-"), ".")
+	if len(frac) > exponent {
+		return 0, ErrAmountPrecision
+	}
+
+	units, err := strconv.ParseInt(whole+frac+strings.Repeat("0", exponent-len(frac)), 10, 64)
+	if err != nil {
+		return 0, ErrAmountRange
+	}
+	if negative {
+		units = -units
+	}
+	return Amount(units), nil
+}
+
+func checkDecimal(s string) error {
+	whole, frac, point := strings.Cut(strings.TrimPrefix(s, "-"), ".")
+	if !isDigits(whole) || point && !isDigits(frac) {
+		return ErrInvalidAmount
+	}
+	return nil
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Format writes the amount as a decimal with exponent fractional digits.
+func (a Amount) Format(exponent int) string {
+	s := strconv.FormatInt(int64(a), 10)
+	sign := ""
+	if a < 0 {
+		sign, s = "-", s[1:]
+	}
+	if exponent <= 0 {
+		return sign + s
+	}
+	if len(s) <= exponent {
+		s = strings.Repeat("0", exponent-len(s)+1) + s
+	}
+	return sign + s[:len(s)-exponent] + "." + s[len(s)-exponent:]
+}
+
+// Money is an amount in a currency. It is written to JSON as a decimal
+// string, like "100.50".
+type Money struct {
+	Amount   Amount
+	Currency string
+}
+
+func (m Money) String() string {
+	return m.Amount.Format(Exponent(m.Currency))
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.String())
+}
+
+// Decimal is an amount as it is written in a request, before the currency
+// that gives its exponent is known. It is read from a JSON string like
+// "100.50" or, for older clients, from a JSON number written the same way.
+// Any other JSON value is kept as it is written and only rejected by Amount,
+// so that the field it came from can be reported.
+type Decimal string
+
+func (d *Decimal) UnmarshalJSON(b []byte) error {
+	s := string(b)
+	if s == "null" {
+		return nil
+	}
+	if strings.HasPrefix(s, `"`) {
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+	}
+	*d = Decimal(s)
+	return nil
+}
+
+// Amount returns the decimal in minor units of the currency.
+func (d Decimal) Amount(currency string) (Amount, error) {
+	return ParseAmount(string(d), Exponent(currency))
+}
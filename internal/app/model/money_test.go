@@ -0,0 +1,82 @@
+package model_test
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"user_balance_microservice/internal/app/model"
+)
+
+func TestParseAmount(t *testing.T) {
+	testCases := []struct {
+		name     string
+		s        string
+		expected model.Amount
+		err      error
+	}{
+		{name: "whole", s: "100", expected: 10000},
+		{name: "fraction", s: "100.5", expected: 10050},
+		{name: "minor units", s: "0.01", expected: 1},
+		{name: "negative", s: "-1.25", expected: -125},
+		{name: "too precise", s: "1.005", err: model.ErrAmountPrecision},
+		{name: "out of range", s: "92233720368547758.08", err: model.ErrAmountRange},
+		{name: "empty", s: "", err: model.ErrInvalidAmount},
+		{name: "plus", s: "+1", err: model.ErrInvalidAmount},
+		{name: "exponent", s: "1e2", err: model.ErrInvalidAmount},
+		{name: "nan", s: "NaN", err: model.ErrInvalidAmount},
+		{name: "trailing point", s: "1.", err: model.ErrInvalidAmount},
+		{name: "leading point", s: ".5", err: model.ErrInvalidAmount},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			amount, err := model.ParseAmount(tc.s, 2)
+			assert.ErrorIs(t, err, tc.err)
+			assert.Equal(t, tc.expected, amount)
+		})
+	}
+}
+
+func TestAmount_Format(t *testing.T) {
+	assert.Equal(t, "100.50", model.Amount(10050).Format(2))
+	assert.Equal(t, "0.05", model.Amount(5).Format(2))
+	assert.Equal(t, "-0.05", model.Amount(-5).Format(2))
+	assert.Equal(t, "0.00", model.Amount(0).Format(2))
+	assert.Equal(t, "42", model.Amount(42).Format(0))
+}
+
+func TestMoney_MarshalJSON(t *testing.T) {
+	b, err := json.Marshal(model.UserAccount{User_id: 1, Currency: "USD", Balance: 1999})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"id":1,"currency":"USD","balance":"19.99"}`, string(b))
+}
+
+func TestDecimal_UnmarshalJSON(t *testing.T) {
+	testCases := []struct {
+		name     string
+		json     string
+		expected model.Amount
+		err      error
+	}{
+		{name: "string", json: `{"amount":"100.50"}`, expected: 10050},
+		{name: "number", json: `{"amount":100.5}`, expected: 10050},
+		{name: "short fraction", json: `{"amount":0.1}`, expected: 10},
+		{name: "exponent", json: `{"amount":1e2}`, err: model.ErrInvalidAmount},
+		{name: "invalid string", json: `{"amount":"ten"}`, err: model.ErrInvalidAmount},
+		{name: "bool", json: `{"amount":true}`, err: model.ErrInvalidAmount},
+		{name: "missing", json: `{}`, err: model.ErrInvalidAmount},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := struct {
+				Amount model.Decimal `json:"amount"`
+			}{}
+			assert.NoError(t, json.Unmarshal([]byte(tc.json), &req))
+			amount, err := req.Amount.Amount("RUB")
+			assert.ErrorIs(t, err, tc.err)
+			assert.Equal(t, tc.expected, amount)
+		})
+	}
+
+}
@@ -0,0 +1,99 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Groupings of a revenue report.
+const (
+	GroupByService = "service"
+	GroupByUser    = "user"
+	GroupByDay     = "day"
+	GroupByWeek    = "week"
+	GroupByMonth   = "month"
+)
+
+// ReportQuery selects the revenue of the transactions closed on the days
+// from From up to, but not including, To. The revenue is summed per
+// currency and per the groupings of GroupBy, in the order the rows are
+// sorted by.
+type ReportQuery struct {
+	From    time.Time
+	To      time.Time
+	GroupBy []string
+}
+
+// Revenue is the revenue in a currency of a group of a report. Only the
+// fields of the groupings of the report are set. Period is the first day
+// of the day, week or month, weeks starting on Monday, formatted as
+// 2006-01-02.
+type Revenue struct {
+	Service  string `json:"service,omitempty"`
+	User_id  int    `json:"userId,omitempty"`
+	Period   string `json:"period,omitempty"`
+	Currency string `json:"currency"`
+	Amount   Amount `json:"amount"`
+}
+
+func (r Revenue) MarshalJSON() ([]byte, error) {
+	type revenue Revenue
+	return json.Marshal(struct {
+		revenue
+		Amount Money `json:"amount"`
+	}{revenue(r), Money{r.Amount, r.Currency}})
+}
+
+// PeriodStart returns the first day of the period of grouping that holds
+// the day of t. Any other grouping has no period and gives the zero time.
+func PeriodStart(t time.Time, grouping string) time.Time {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	switch grouping {
+	case GroupByDay:
+		return day
+	case GroupByWeek:
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	case GroupByMonth:
+		return day.AddDate(0, 0, 1-day.Day())
+	}
+	return time.Time{}
+}
+
+// Formats of a report file.
+const (
+	FormatCSV  = "csv"
+	FormatXLSX = "xlsx"
+	FormatJSON = "json"
+)
+
+// ReportFormat is how a report is written to a file. BOM and Delimiter
+// only apply to CSV: the file then starts with a UTF-8 byte order mark if
+// BOM is set, and its fields are separated by Delimiter, or by commas
+// when it is empty.
+type ReportFormat struct {
+	Name      string
+	BOM       bool
+	Delimiter string
+}
+
+// Statuses of a report job.
+const (
+	ReportPending = "pending"
+	ReportRunning = "running"
+	ReportDone    = "done"
+	ReportFailed  = "failed"
+)
+
+// ReportJob is a report generated in the background. File is the name of
+// the saved report once the job is done, and Error why it failed.
+type ReportJob struct {
+	Id          int
+	Status      string
+	Query       ReportQuery
+	Format      ReportFormat
+	File        string
+	Error       string
+	Created_at  time.Time
+	Started_at  *time.Time
+	Finished_at *time.Time
+}
@@ -0,0 +1,28 @@
+package model_test
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+	"user_balance_microservice/internal/app/model"
+)
+
+func TestPeriodStart(t *testing.T) {
+	day := time.Date(2022, time.November, 5, 15, 30, 0, 0, time.Local)
+	testCases := []struct {
+		grouping string
+		expected string
+	}{
+		{model.GroupByDay, "2022-11-05"},
+		{model.GroupByWeek, "2022-10-31"},
+		{model.GroupByMonth, "2022-11-01"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.grouping, func(t *testing.T) {
+			assert.Equal(t, tc.expected, model.PeriodStart(day, tc.grouping).Format("2006-01-02"))
+		})
+	}
+	assert.Equal(t, "2022-10-31", model.PeriodStart(time.Date(2022, time.October, 31, 0, 0, 0, 0, time.UTC), model.GroupByWeek).Format("2006-01-02"))
+	assert.Equal(t, "2022-10-31", model.PeriodStart(time.Date(2022, time.November, 6, 0, 0, 0, 0, time.UTC), model.GroupByWeek).Format("2006-01-02"))
+	assert.True(t, model.PeriodStart(day, model.GroupByService).IsZero())
+}
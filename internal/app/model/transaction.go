@@ -1,23 +1,70 @@
 package model
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 type Transaction struct {
-	Id          int       `json:"id"`
-	User_id     int       `json:"userId"`
-	Amount      int       `json:"amount"`
-	Description string    `json:"description"`
-	Order_id    int       `json:"orderId"`
-	Service_id  int       `json:"serviceId"`
-	Closed_date time.Time `json:"closedDate"`
-	Success_flg bool      `json:"-"`
-	Type        string    `json:"-"`
+	Id            int        `json:"id"`
+	User_id       int        `json:"userId"`
+	Amount        Amount     `json:"amount"`
+	Currency      string     `json:"currency"`
+	Description   string     `json:"description"`
+	Order_id      int        `json:"orderId"`
+	Service_id    int        `json:"serviceId"`
+	Closed_date   time.Time  `json:"closedDate"`
+	Created_at    time.Time  `json:"createdAt"`
+	Expires_at    *time.Time `json:"expiresAt,omitempty"`
+	Success_flg   bool       `json:"-"`
+	Type          string     `json:"-"`
+	Parent_id     int        `json:"parentId,omitempty"`
+	Conversion_id int        `json:"-"`
+}
+
+func (t *Transaction) Wallet() Wallet {
+	return Wallet{User_id: t.User_id, Currency: t.Currency}
+}
+
+func (t Transaction) MarshalJSON() ([]byte, error) {
+	type transaction Transaction
+	return json.Marshal(struct {
+		transaction
+		Amount Money `json:"amount"`
+	}{transaction(t), Money{t.Amount, t.Currency}})
 }
 
 type AccountTransaction struct {
-	Amount      int       `json:"amount"`
-	Description string    `json:"description"`
-	Order_id    int       `json:"orderId"`
-	Service     string    `json:"service"`
-	Closed_date time.Time `json:"closedDate"`
+	Id          int         `json:"-"`
+	Amount      Amount      `json:"amount"`
+	Currency    string      `json:"currency"`
+	Description string      `json:"description"`
+	Order_id    int         `json:"orderId"`
+	Service     string      `json:"service"`
+	Closed_date time.Time   `json:"closedDate"`
+	Conversion  *Conversion `json:"conversion,omitempty"`
+}
+
+func (t AccountTransaction) MarshalJSON() ([]byte, error) {
+	type accountTransaction AccountTransaction
+	return json.Marshal(struct {
+		accountTransaction
+		Amount Money `json:"amount"`
+	}{accountTransaction(t), Money{t.Amount, t.Currency}})
+}
+
+// ServiceRevenue is the revenue of a service in a currency for the period of
+// a report.
+type ServiceRevenue struct {
+	Service  string `json:"service"`
+	Currency string `json:"currency"`
+	Amount   Amount `json:"amount"`
+}
+
+func (r ServiceRevenue) MarshalJSON() ([]byte, error) {
+	type serviceRevenue ServiceRevenue
+	return json.Marshal(struct {
+		serviceRevenue
+		Amount Money `json:"amount"`
+	}{serviceRevenue(r), Money{r.Amount, r.Currency}})
 }
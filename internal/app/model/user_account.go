@@ -1,7 +1,23 @@
 package model
 
+import "encoding/json"
+
+// UserAccount is the wallet of a user in one currency.
 type UserAccount struct {
-	User_id          int `json:"id"`
-	Balance          int `json:"balance"`
-	Reserved_balance int `json:"-"`
+	User_id          int    `json:"id"`
+	Currency         string `json:"currency"`
+	Balance          Amount `json:"balance"`
+	Reserved_balance Amount `json:"-"`
+}
+
+func (a *UserAccount) Wallet() Wallet {
+	return Wallet{User_id: a.User_id, Currency: a.Currency}
+}
+
+func (a UserAccount) MarshalJSON() ([]byte, error) {
+	type account UserAccount
+	return json.Marshal(struct {
+		account
+		Balance Money `json:"balance"`
+	}{account(a), Money{a.Balance, a.Currency}})
 }
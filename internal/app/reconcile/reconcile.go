@@ -0,0 +1,87 @@
+// Package reconcile detects drift between the balances stored in
+// user_accounts and the ones the transaction history and the ledger give.
+package reconcile
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+	"user_balance_microservice/internal/app/model"
+	"user_balance_microservice/internal/app/store"
+)
+
+const (
+	FormatJSON = "json"
+	FormatCSV  = "csv"
+)
+
+var ErrUnknownFormat = errors.New("Unknown format, use json or csv")
+
+// Run returns the accounts whose balances differ from their history, then
+// the ones whose balances differ from the ledger. With save set they are
+// also written to the discrepancies table.
+func Run(ctx context.Context, s store.Store, save bool) ([]model.Discrepancy, error) {
+	var discrepancies []model.Discrepancy
+	err := s.WithinTx(ctx, func(tx store.TxStore) error {
+		history, err := tx.Reconciliation().FindDiscrepancies()
+		if err != nil {
+			return err
+		}
+		ledger, err := tx.Reconciliation().FindLedgerDiscrepancies()
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		for i := range history {
+			history[i].Source = model.DiscrepancyHistory
+			history[i].Found_at = now
+		}
+		for i := range ledger {
+			ledger[i].Source = model.DiscrepancyLedger
+			ledger[i].Found_at = now
+		}
+		discrepancies = append(history, ledger...)
+		if !save || len(discrepancies) == 0 {
+			return nil
+		}
+		return tx.Reconciliation().SaveDiscrepancies(discrepancies)
+	})
+	return discrepancies, err
+}
+
+// Write writes the discrepancies to w in the given format.
+func Write(w io.Writer, format string, discrepancies []model.Discrepancy) error {
+	switch format {
+	case FormatJSON:
+		return json.NewEncoder(w).Encode(discrepancies)
+	case FormatCSV:
+		return writeCSV(w, discrepancies)
+	default:
+		return ErrUnknownFormat
+	}
+}
+
+func writeCSV(w io.Writer, discrepancies []model.Discrepancy) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"user_id", "currency", "balance", "expected_balance", "reserved_balance", "expected_reserved", "source", "found_at"})
+	for _, d := range discrepancies {
+		exponent := model.Exponent(d.Currency)
+		writer.Write([]string{
+			strconv.Itoa(d.User_id),
+			d.Currency,
+			d.Balance.Format(exponent),
+			d.Expected_balance.Format(exponent),
+			d.Reserved_balance.Format(exponent),
+			d.Expected_reserved.Format(exponent),
+			d.Source,
+			d.Found_at.Format(time.RFC3339),
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,122 @@
+package reconcile_test
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+	"user_balance_microservice/internal/app/model"
+	"user_balance_microservice/internal/app/reconcile"
+	"user_balance_microservice/internal/app/store/teststore"
+)
+
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+	s := teststore.New()
+	for _, account := range []*model.UserAccount{
+		{User_id: 1, Currency: "RUB", Balance: 100},
+		{User_id: 2, Currency: "RUB", Balance: 60},
+		{User_id: 3, Currency: "RUB", Balance: 10},
+	} {
+		assert.NoError(t, s.UserAccount().Create(ctx, account))
+		assert.NoError(t, s.Transaction().CreateAddTransaction(ctx, &model.Transaction{
+			User_id: account.User_id, Amount: 100, Currency: "RUB", Closed_date: time.Now(), Success_flg: true, Type: "add",
+		}))
+		postBalance(t, s, account)
+	}
+
+	reserve := &model.Transaction{User_id: 2, Amount: 40, Currency: "RUB", Order_id: 1, Service_id: 1, Type: "reserve"}
+	assert.NoError(t, s.Transaction().CreateReserveTransaction(ctx, reserve))
+
+	discrepancies, err := reconcile.Run(context.Background(), s, true)
+	assert.NoError(t, err)
+	assert.Len(t, discrepancies, 2)
+	assert.Equal(t, model.Discrepancy{
+		User_id:           2,
+		Currency:          "RUB",
+		Balance:           60,
+		Expected_balance:  60,
+		Reserved_balance:  0,
+		Expected_reserved: 40,
+		Source:            model.DiscrepancyHistory,
+		Found_at:          discrepancies[0].Found_at,
+	}, discrepancies[0])
+	assert.Equal(t, 3, discrepancies[1].User_id)
+	assert.Equal(t, model.Amount(100), discrepancies[1].Expected_balance)
+	assert.Len(t, s.Discrepancies(), 2)
+
+	discrepancies, err = reconcile.Run(context.Background(), s, false)
+	assert.NoError(t, err)
+	assert.Len(t, discrepancies, 2)
+	assert.Len(t, s.Discrepancies(), 2)
+}
+
+func TestRun_ledger(t *testing.T) {
+	ctx := context.Background()
+	s := teststore.New()
+	for _, account := range []*model.UserAccount{
+		{User_id: 1, Currency: "RUB", Balance: 100},
+		{User_id: 2, Currency: "RUB", Balance: 60},
+	} {
+		assert.NoError(t, s.UserAccount().Create(ctx, account))
+		postBalance(t, s, account)
+	}
+	// The reserve is stored but never posted.
+	_, err := s.UserAccount().Reserve(ctx, &model.UserAccount{User_id: 1, Currency: "RUB", Balance: 30})
+	assert.NoError(t, err)
+
+	discrepancies, err := reconcile.Run(ctx, s, false)
+	assert.NoError(t, err)
+	ledger := []model.Discrepancy{}
+	for _, d := range discrepancies {
+		if d.Source == model.DiscrepancyLedger {
+			ledger = append(ledger, d)
+		}
+	}
+	assert.Equal(t, []model.Discrepancy{{
+		User_id:           1,
+		Currency:          "RUB",
+		Balance:           70,
+		Expected_balance:  100,
+		Reserved_balance:  30,
+		Expected_reserved: 0,
+		Source:            model.DiscrepancyLedger,
+		Found_at:          discrepancies[0].Found_at,
+	}}, ledger)
+}
+
+// postBalance posts the opening entry that puts the stored balances of the
+// account into the ledger.
+func postBalance(t *testing.T, s *teststore.Store, account *model.UserAccount) {
+	t.Helper()
+
+	wallet := model.Wallet{User_id: account.User_id, Currency: account.Currency}
+	assert.NoError(t, s.Ledger().Post(context.Background(), &model.JournalEntry{
+		Description: "Входящий остаток",
+		Postings: []model.Posting{
+			{Account: model.ExternalLedgerAccount(account.Currency), Amount: -account.Balance - account.Reserved_balance},
+			{Account: model.UserLedgerAccount(wallet), Amount: account.Balance},
+			{Account: model.ReservedLedgerAccount(wallet), Amount: account.Reserved_balance},
+		},
+	}))
+}
+
+func TestWrite(t *testing.T) {
+	found := time.Date(2022, 11, 12, 10, 0, 0, 0, time.UTC)
+	discrepancies := []model.Discrepancy{
+		{User_id: 1, Currency: "RUB", Balance: 1000, Expected_balance: 2000, Source: model.DiscrepancyLedger, Found_at: found},
+	}
+
+	b := &bytes.Buffer{}
+	assert.NoError(t, reconcile.Write(b, reconcile.FormatCSV, discrepancies))
+	assert.Equal(t, "user_id,currency,balance,expected_balance,reserved_balance,expected_reserved,source,found_at\n"+
+		"1,RUB,10.00,20.00,0.00,0.00,ledger,2022-11-12T10:00:00Z\n", b.String())
+
+	b.Reset()
+	assert.NoError(t, reconcile.Write(b, reconcile.FormatJSON, discrepancies))
+	assert.True(t, strings.HasPrefix(b.String(), `[{"userId":1,"currency":"RUB","balance":"10.00","expectedBalance":"20.00",`))
+
+	assert.ErrorIs(t, reconcile.Write(b, "xml", discrepancies), reconcile.ErrUnknownFormat)
+}
@@ -0,0 +1,136 @@
+package reports
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"unicode/utf8"
+	"user_balance_microservice/internal/app/model"
+)
+
+// Report is a revenue report as it is written to a file.
+type Report struct {
+	Query model.ReportQuery
+	Rows  []model.Revenue
+}
+
+// contentTypes are the media types of the report formats.
+var contentTypes = map[string]string{
+	model.FormatCSV:  "text/csv; charset=utf-8",
+	model.FormatXLSX: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	model.FormatJSON: "application/json",
+}
+
+// ContentType returns the media type of a report format, or "" for an
+// unknown format.
+func ContentType(format string) string {
+	return contentTypes[format]
+}
+
+// Write writes the report in the format: a header naming the groupings,
+// currency and amount, then a row for every revenue in the order of the
+// report. XLSX and JSON reports end with the totals per currency.
+func Write(w io.Writer, format model.ReportFormat, report *Report) error {
+	switch format.Name {
+	case model.FormatCSV:
+		return writeCSV(w, format, report)
+	case model.FormatXLSX:
+		return writeXLSX(w, report)
+	case model.FormatJSON:
+		return writeJSON(w, report)
+	}
+	return fmt.Errorf("unknown report format %q", format.Name)
+}
+
+// byteOrderMark starts a CSV report for programs like Excel, that
+// otherwise do not read it as UTF-8.
+const byteOrderMark = "\ufeff"
+
+func writeCSV(w io.Writer, format model.ReportFormat, report *Report) error {
+	if format.BOM {
+		if _, err := io.WriteString(w, byteOrderMark); err != nil {
+			return err
+		}
+	}
+	csvWriter := csv.NewWriter(w)
+	if format.Delimiter != "" {
+		csvWriter.Comma, _ = utf8.DecodeRuneInString(format.Delimiter)
+	}
+	if err := csvWriter.Write(header(report.Query.GroupBy)); err != nil {
+		return err
+	}
+	for _, revenue := range report.Rows {
+		row := []string{}
+		for _, grouping := range report.Query.GroupBy {
+			row = append(row, groupOf(revenue, grouping))
+		}
+		row = append(row, revenue.Currency, model.Money{Amount: revenue.Amount, Currency: revenue.Currency}.String())
+		if err := csvWriter.Write(row); err != nil {
+			return err
+		}
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+// total is the sum of a currency in a JSON report.
+type total struct {
+	Currency string      `json:"currency"`
+	Amount   model.Money `json:"amount"`
+}
+
+func writeJSON(w io.Writer, report *Report) error {
+	rows := report.Rows
+	if rows == nil {
+		rows = []model.Revenue{}
+	}
+	sums := []total{}
+	for _, sum := range totals(report.Rows) {
+		sums = append(sums, total{Currency: sum.Currency, Amount: sum})
+	}
+	return json.NewEncoder(w).Encode(struct {
+		From    string          `json:"from"`
+		To      string          `json:"to"`
+		GroupBy []string        `json:"groupBy"`
+		Rows    []model.Revenue `json:"rows"`
+		Totals  []total         `json:"totals"`
+	}{
+		From:    report.Query.From.Format("2006-01-02"),
+		To:      report.Query.To.AddDate(0, 0, -1).Format("2006-01-02"),
+		GroupBy: report.Query.GroupBy,
+		Rows:    rows,
+		Totals:  sums,
+	})
+}
+
+func header(groupBy []string) []string {
+	return append(append([]string{}, groupBy...), "currency", "amount")
+}
+
+// groupOf returns the value of a grouping of the revenue.
+func groupOf(revenue model.Revenue, grouping string) string {
+	switch grouping {
+	case model.GroupByService:
+		return revenue.Service
+	case model.GroupByUser:
+		return strconv.Itoa(revenue.User_id)
+	}
+	return revenue.Period
+}
+
+// totals sums the rows per currency, in the order of the currencies.
+func totals(rows []model.Revenue) []model.Money {
+	sums := map[string]model.Amount{}
+	for _, revenue := range rows {
+		sums[revenue.Currency] += revenue.Amount
+	}
+	res := []model.Money{}
+	for currency, amount := range sums {
+		res = append(res, model.Money{Amount: amount, Currency: currency})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Currency < res[j].Currency })
+	return res
+}
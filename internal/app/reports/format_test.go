@@ -0,0 +1,113 @@
+package reports_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+	"time"
+	"user_balance_microservice/internal/app/model"
+	"user_balance_microservice/internal/app/reports"
+)
+
+func testReport() *reports.Report {
+	from := time.Date(2022, 11, 1, 0, 0, 0, 0, time.UTC)
+	return &reports.Report{
+		Query: model.ReportQuery{From: from, To: from.AddDate(0, 1, 0), GroupBy: []string{model.GroupByService, model.GroupByUser}},
+		Rows: []model.Revenue{
+			{Service: "услуга 1", User_id: 1, Currency: "RUB", Amount: 123456},
+			{Service: "услуга; 2", User_id: 2, Currency: "RUB", Amount: 500},
+			{Service: "услуга 1", User_id: 3, Currency: "USD", Amount: 1050},
+		},
+	}
+}
+
+func TestWrite_CSV(t *testing.T) {
+	testCases := []struct {
+		name     string
+		format   model.ReportFormat
+		expected string
+	}{
+		{
+			name:     "plain",
+			format:   model.ReportFormat{Name: model.FormatCSV},
+			expected: "service,user,currency,amount\nуслуга 1,1,RUB,1234.56\nуслуга; 2,2,RUB,5.00\nуслуга 1,3,USD,10.50\n",
+		}, {
+			name:     "for excel",
+			format:   model.ReportFormat{Name: model.FormatCSV, BOM: true, Delimiter: ";"},
+			expected: "\ufeffservice;user;currency;amount\nуслуга 1;1;RUB;1234.56\n\"услуга; 2\";2;RUB;5.00\nуслуга 1;3;USD;10.50\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			b := &bytes.Buffer{}
+			assert.NoError(t, reports.Write(b, tc.format, testReport()))
+			assert.Equal(t, tc.expected, b.String())
+		})
+	}
+}
+
+func TestWrite_JSON(t *testing.T) {
+	b := &bytes.Buffer{}
+	assert.NoError(t, reports.Write(b, model.ReportFormat{Name: model.FormatJSON}, testReport()))
+	assert.JSONEq(t, `{
+		"from": "2022-11-01",
+		"to": "2022-11-30",
+		"groupBy": ["service", "user"],
+		"rows": [
+			{"service": "услуга 1", "userId": 1, "currency": "RUB", "amount": "1234.56"},
+			{"service": "услуга; 2", "userId": 2, "currency": "RUB", "amount": "5.00"},
+			{"service": "услуга 1", "userId": 3, "currency": "USD", "amount": "10.50"}
+		],
+		"totals": [
+			{"currency": "RUB", "amount": "1239.56"},
+			{"currency": "USD", "amount": "10.50"}
+		]
+	}`, b.String())
+
+	b.Reset()
+	assert.NoError(t, reports.Write(b, model.ReportFormat{Name: model.FormatJSON}, &reports.Report{Query: testReport().Query}))
+	res := map[string]json.RawMessage{}
+	assert.NoError(t, json.Unmarshal(b.Bytes(), &res))
+	assert.Equal(t, "[]", string(res["rows"]))
+	assert.Equal(t, "[]", string(res["totals"]))
+}
+
+func TestWrite_XLSX(t *testing.T) {
+	b := &bytes.Buffer{}
+	assert.NoError(t, reports.Write(b, model.ReportFormat{Name: model.FormatXLSX}, testReport()))
+
+	workbook, err := zip.NewReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	assert.NoError(t, err)
+	parts := map[string]string{}
+	for _, file := range workbook.File {
+		r, err := file.Open()
+		assert.NoError(t, err)
+		content, err := io.ReadAll(r)
+		assert.NoError(t, err)
+		r.Close()
+		parts[file.Name] = string(content)
+	}
+	assert.Contains(t, parts, "[Content_Types].xml")
+	assert.Contains(t, parts, "xl/workbook.xml")
+
+	styles := parts["xl/styles.xml"]
+	assert.Contains(t, styles, `<numFmt numFmtId="164" formatCode="#,##0.00"/>`)
+	assert.Contains(t, styles, `<cellXfs count="4">`)
+
+	sheet := parts["xl/worksheets/sheet1.xml"]
+	assert.Contains(t, sheet, `<c r="A1" s="1" t="inlineStr"><is><t xml:space="preserve">service</t></is></c>`)
+	assert.Contains(t, sheet, `<c r="A2" s="0" t="inlineStr"><is><t xml:space="preserve">услуга 1</t></is></c><c r="B2"><v>1</v></c>`)
+	assert.Contains(t, sheet, `<c r="D2" s="2"><v>1234.56</v></c>`)
+	assert.Contains(t, sheet, `<row r="5"><c r="A5" s="1" t="inlineStr"><is><t xml:space="preserve">total</t></is></c>`)
+	assert.Contains(t, sheet, `<c r="D5" s="3"><f>SUMIF(C2:C4,"RUB",D2:D4)</f><v>1239.56</v></c>`)
+	assert.Contains(t, sheet, `<c r="D6" s="3"><f>SUMIF(C2:C4,"USD",D2:D4)</f><v>10.50</v></c>`)
+}
+
+func TestWrite_unknownFormat(t *testing.T) {
+	assert.Error(t, reports.Write(io.Discard, model.ReportFormat{Name: "pdf"}, testReport()))
+	assert.Equal(t, "", reports.ContentType("pdf"))
+}
@@ -0,0 +1,116 @@
+package reports
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// LocalStorage keeps reports as files of a directory, which is made on the
+// first Put.
+type LocalStorage struct {
+	dir string
+}
+
+func NewLocalStorage(dir string) *LocalStorage {
+	return &LocalStorage{dir: dir}
+}
+
+func (s *LocalStorage) Put(ctx context.Context, name string, r io.Reader) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+	// The report is written under a hidden name and renamed once complete.
+	file, err := os.CreateTemp(s.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	if _, err := io.Copy(file, r); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), filepath.Join(s.dir, name))
+}
+
+func (s *LocalStorage) Get(ctx context.Context, name string) (io.ReadCloser, *Object, error) {
+	if checkName(name) != nil {
+		return nil, nil, ErrNotFound
+	}
+	file, err := os.Open(filepath.Join(s.dir, name))
+	if os.IsNotExist(err) {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	if !info.Mode().IsRegular() {
+		file.Close()
+		return nil, nil, ErrNotFound
+	}
+	return file, &Object{Name: name, Size: info.Size(), Modified: info.ModTime()}, nil
+}
+
+func (s *LocalStorage) List(ctx context.Context) ([]Object, error) {
+	objects := []Object{}
+	entries, err := os.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return objects, nil
+	}
+	if err != nil {
+		return objects, err
+	}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || checkName(entry.Name()) != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return objects, err
+		}
+		objects = append(objects, Object{Name: entry.Name(), Size: info.Size(), Modified: info.ModTime()})
+	}
+	return objects, nil
+}
+
+func (s *LocalStorage) Delete(ctx context.Context, name string) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(s.dir, name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// URL returns "": local reports are downloaded through the service.
+func (s *LocalStorage) URL(ctx context.Context, name string, ttl time.Duration) (string, error) {
+	return "", nil
+}
+
+// checkName refuses names that reach outside of the storage or are hidden.
+func checkName(name string) error {
+	if name == "" || strings.HasPrefix(name, ".") || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("invalid report name %q", name)
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+// Package reports writes revenue reports as CSV, XLSX or JSON files and
+// keeps the saved ones in a ReportStorage: a local directory or a bucket of
+// an S3-compatible service.
+package reports
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+var ErrNotFound = errors.New("Report not found")
+
+// Object is a stored report.
+type Object struct {
+	Name     string
+	Size     int64
+	Modified time.Time
+}
+
+// ReportStorage keeps report files by name. Names are plain file names
+// without directories.
+type ReportStorage interface {
+	// Put stores the report read from r, replacing a report of the same
+	// name. The report can't be read before it is stored completely.
+	Put(ctx context.Context, name string, r io.Reader) error
+	// Get opens a stored report. It returns ErrNotFound if there is none.
+	Get(ctx context.Context, name string) (io.ReadCloser, *Object, error)
+	// List returns every stored report.
+	List(ctx context.Context) ([]Object, error)
+	// Delete removes a report. Removing a missing report is not an error.
+	Delete(ctx context.Context, name string) error
+	// URL returns a link to download a report right from the storage that
+	// is valid for ttl, or "" when the storage gives no such links.
+	URL(ctx context.Context, name string, ttl time.Duration) (string, error)
+}
@@ -0,0 +1,123 @@
+package reports_test
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+	"user_balance_microservice/internal/app/reports"
+)
+
+func TestReportStorage(t *testing.T) {
+	storages := map[string]func(t *testing.T) reports.ReportStorage{
+		"local": func(t *testing.T) reports.ReportStorage { return reports.NewLocalStorage(t.TempDir()) },
+		"s3":    func(t *testing.T) reports.ReportStorage { return reports.NewS3Storage(reports.TestS3(t)) },
+	}
+	for name, newStorage := range storages {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			storage := newStorage(t)
+
+			objects, err := storage.List(ctx)
+			assert.NoError(t, err)
+			assert.Empty(t, objects)
+			_, _, err = storage.Get(ctx, "a.csv")
+			assert.ErrorIs(t, err, reports.ErrNotFound)
+
+			for _, name := range []string{"a.csv", "b.csv", "c.csv"} {
+				assert.NoError(t, storage.Put(ctx, name, strings.NewReader("report "+name)))
+			}
+			assert.NoError(t, storage.Put(ctx, "a.csv", strings.NewReader("new report")))
+
+			body, object, err := storage.Get(ctx, "a.csv")
+			assert.NoError(t, err)
+			content, _ := io.ReadAll(body)
+			body.Close()
+			assert.Equal(t, "new report", string(content))
+			assert.Equal(t, int64(len("new report")), object.Size)
+			assert.WithinDuration(t, time.Now(), object.Modified, time.Minute)
+
+			objects, err = storage.List(ctx)
+			assert.NoError(t, err)
+			names := []string{}
+			for _, object := range objects {
+				names = append(names, object.Name)
+			}
+			sort.Strings(names)
+			assert.Equal(t, []string{"a.csv", "b.csv", "c.csv"}, names)
+
+			assert.NoError(t, storage.Delete(ctx, "b.csv"))
+			assert.NoError(t, storage.Delete(ctx, "b.csv"))
+			_, _, err = storage.Get(ctx, "b.csv")
+			assert.ErrorIs(t, err, reports.ErrNotFound)
+
+			assert.Error(t, storage.Put(ctx, "../a.csv", strings.NewReader("")))
+			_, _, err = storage.Get(ctx, "../a.csv")
+			assert.ErrorIs(t, err, reports.ErrNotFound)
+		})
+	}
+}
+
+func TestS3Storage_URL(t *testing.T) {
+	ctx := context.Background()
+	storage := reports.NewS3Storage(reports.TestS3(t))
+	assert.NoError(t, storage.Put(ctx, "a.csv", strings.NewReader("report")))
+
+	link, err := storage.URL(ctx, "a.csv", time.Minute)
+	assert.NoError(t, err)
+	assert.Contains(t, link, "/reports/a.csv?")
+	assert.Contains(t, link, "X-Amz-Expires=60")
+
+	res, err := http.Get(link)
+	assert.NoError(t, err)
+	defer res.Body.Close()
+	content, _ := io.ReadAll(res.Body)
+	assert.Equal(t, "report", string(content))
+
+	// The fake checks the signature, so a changed link is refused.
+	res, err = http.Get(strings.Replace(link, "a.csv", "b.csv", 1))
+	assert.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusForbidden, res.StatusCode)
+
+	link, err = reports.NewLocalStorage(t.TempDir()).URL(ctx, "a.csv", time.Minute)
+	assert.NoError(t, err)
+	assert.Empty(t, link)
+}
+
+func TestS3Storage_signature(t *testing.T) {
+	ctx := context.Background()
+	config := reports.TestS3(t)
+
+	wrong := config
+	wrong.SecretKey = "wrong"
+	assert.Error(t, reports.NewS3Storage(wrong).Put(ctx, "a.csv", strings.NewReader("report")))
+
+	// Links point to the public endpoint and are signed for its host.
+	public := config
+	public.PublicEndpoint = "http://reports.example.com"
+	storage := reports.NewS3Storage(public)
+	assert.NoError(t, storage.Put(ctx, "a.csv", strings.NewReader("report")))
+	link, err := storage.URL(ctx, "a.csv", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(link, "http://reports.example.com/reports/a.csv?"), link)
+
+	req, err := http.NewRequest(http.MethodGet, strings.Replace(link, public.PublicEndpoint, config.Endpoint, 1), nil)
+	assert.NoError(t, err)
+	req.Host = "reports.example.com"
+	res, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	content, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	assert.Equal(t, "report", string(content))
+
+	req.Host = ""
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusForbidden, res.StatusCode)
+}
@@ -0,0 +1,332 @@
+package reports
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3Config locates a bucket of an S3-compatible service such as MinIO.
+// Endpoint is the base URL of the service, like http://minio:9000, and
+// PublicEndpoint the one clients reach it at, used in pre-signed links; it
+// is Endpoint when empty.
+type S3Config struct {
+	Endpoint       string
+	PublicEndpoint string
+	Region         string
+	Bucket         string
+	AccessKey      string
+	SecretKey      string
+}
+
+// S3Storage keeps reports as objects of a bucket. Objects are addressed by
+// path, as MinIO expects, and requests are signed with AWS Signature
+// Version 4.
+type S3Storage struct {
+	config S3Config
+	client *http.Client
+	now    func() time.Time
+}
+
+func NewS3Storage(config S3Config) *S3Storage {
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	config.Endpoint = strings.TrimSuffix(config.Endpoint, "/")
+	config.PublicEndpoint = strings.TrimSuffix(config.PublicEndpoint, "/")
+	if config.PublicEndpoint == "" {
+		config.PublicEndpoint = config.Endpoint
+	}
+	return &S3Storage{
+		config: config,
+		client: &http.Client{Timeout: time.Minute},
+		now:    time.Now,
+	}
+}
+
+func (s *S3Storage) Put(ctx context.Context, name string, r io.Reader) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+	// The payload is signed, so it is read whole first.
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	res, err := s.do(ctx, http.MethodPut, s.objectPath(name), nil, body)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return s3Error(res)
+	}
+	return nil
+}
+
+func (s *S3Storage) Get(ctx context.Context, name string) (io.ReadCloser, *Object, error) {
+	if checkName(name) != nil {
+		return nil, nil, ErrNotFound
+	}
+	res, err := s.do(ctx, http.MethodGet, s.objectPath(name), nil, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		res.Body.Close()
+		return nil, nil, ErrNotFound
+	default:
+		defer res.Body.Close()
+		return nil, nil, s3Error(res)
+	}
+
+	object := &Object{Name: name, Size: res.ContentLength}
+	object.Modified, _ = http.ParseTime(res.Header.Get("Last-Modified"))
+	return res.Body, object, nil
+}
+
+func (s *S3Storage) List(ctx context.Context) ([]Object, error) {
+	objects := []Object{}
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		res, err := s.do(ctx, http.MethodGet, "/"+s.config.Bucket, query, nil)
+		if err != nil {
+			return objects, err
+		}
+		if res.StatusCode != http.StatusOK {
+			err := s3Error(res)
+			res.Body.Close()
+			return objects, err
+		}
+
+		page := struct {
+			Contents []struct {
+				Key          string
+				Size         int64
+				LastModified time.Time
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}{}
+		err = xml.NewDecoder(res.Body).Decode(&page)
+		res.Body.Close()
+		if err != nil {
+			return objects, err
+		}
+		for _, content := range page.Contents {
+			objects = append(objects, Object{Name: content.Key, Size: content.Size, Modified: content.LastModified})
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+func (s *S3Storage) Delete(ctx context.Context, name string) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+	res, err := s.do(ctx, http.MethodDelete, s.objectPath(name), nil, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusNoContent && res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNotFound {
+		return s3Error(res)
+	}
+	return nil
+}
+
+// URL returns a pre-signed link to the object of the report at the public
+// endpoint, whose host is the one signed.
+func (s *S3Storage) URL(ctx context.Context, name string, ttl time.Duration) (string, error) {
+	if err := checkName(name); err != nil {
+		return "", err
+	}
+	endpoint, err := url.Parse(s.config.PublicEndpoint)
+	if err != nil {
+		return "", err
+	}
+	query := s.presign(http.MethodGet, endpoint.Host, s.objectPath(name), s.now(), ttl)
+	return fmt.Sprintf("%s%s?%s", s.config.PublicEndpoint, escapePath(s.objectPath(name)), query), nil
+}
+
+func (s *S3Storage) objectPath(name string) string {
+	return "/" + s.config.Bucket + "/" + name
+}
+
+func (s *S3Storage) do(ctx context.Context, method, path string, query url.Values, body []byte) (*http.Response, error) {
+	target := s.config.Endpoint + escapePath(path)
+	if len(query) > 0 {
+		target += "?" + canonicalQuery(query)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req, body, s.now())
+	return s.client.Do(req)
+}
+
+// sign adds the headers of Signature Version 4 to req, signing all of its
+// headers and the payload.
+func (s *S3Storage) sign(req *http.Request, payload []byte, now time.Time) {
+	hash := sha256.Sum256(payload)
+	payloadHash := hex.EncodeToString(hash[:])
+	amzDate := now.UTC().Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	canonicalHeaders := ""
+	for _, name := range names {
+		canonicalHeaders += name + ":" + headers[name] + "\n"
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		escapePath(req.URL.Path),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope, signature := s.signature(now, canonicalRequest)
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.config.AccessKey, scope, signedHeaders, signature))
+}
+
+// presign returns the query of a link that performs the request without
+// further credentials until ttl passes.
+func (s *S3Storage) presign(method, host, path string, now time.Time, ttl time.Duration) string {
+	query := url.Values{
+		"X-Amz-Algorithm":     {"AWS4-HMAC-SHA256"},
+		"X-Amz-Credential":    {s.config.AccessKey + "/" + s.scope(now)},
+		"X-Amz-Date":          {now.UTC().Format("20060102T150405Z")},
+		"X-Amz-Expires":       {strconv.Itoa(int(ttl / time.Second))},
+		"X-Amz-SignedHeaders": {"host"},
+	}
+	canonicalRequest := strings.Join([]string{
+		method,
+		escapePath(path),
+		canonicalQuery(query),
+		"host:" + host + "\n",
+		"host",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	_, signature := s.signature(now, canonicalRequest)
+	return canonicalQuery(query) + "&X-Amz-Signature=" + signature
+}
+
+func (s *S3Storage) scope(now time.Time) string {
+	return now.UTC().Format("20060102") + "/" + s.config.Region + "/s3/aws4_request"
+}
+
+// signature returns the scope of the credentials and the signature of the
+// canonical request.
+func (s *S3Storage) signature(now time.Time, canonicalRequest string) (string, string) {
+	scope := s.scope(now)
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		now.UTC().Format("20060102T150405Z"),
+		scope,
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	key := []byte("AWS4" + s.config.SecretKey)
+	for _, part := range []string{now.UTC().Format("20060102"), s.config.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	return scope, hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// escapePath encodes every segment of the path as Signature Version 4
+// requires.
+func escapePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = escape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// canonicalQuery encodes the query sorted by key.
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := []string{}
+	for _, key := range keys {
+		values := append([]string(nil), query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			pairs = append(pairs, escape(key)+"="+escape(value))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// escape encodes everything but the unreserved characters of RFC 3986.
+func escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// s3Error reads the error answered by the service.
+func s3Error(res *http.Response) error {
+	body := struct {
+		Code    string
+		Message string
+	}{}
+	if err := xml.NewDecoder(io.LimitReader(res.Body, 1<<16)).Decode(&body); err != nil || body.Code == "" {
+		return fmt.Errorf("storage answered %s", res.Status)
+	}
+	return fmt.Errorf("storage answered %s: %s: %s", res.Status, body.Code, body.Message)
+}
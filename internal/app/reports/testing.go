@@ -0,0 +1,195 @@
+package reports
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestS3 starts an in-process fake of an S3-compatible service with one
+// bucket, stopped when the test ends, and returns the config of a storage
+// in it. The fake verifies the Signature Version 4 of every request, signed
+// in its headers or pre-signed, with the keys of the config.
+func TestS3(t *testing.T) S3Config {
+	t.Helper()
+
+	config := S3Config{Region: "us-east-1", Bucket: "reports", AccessKey: "test", SecretKey: "secret"}
+	fake := &fakeS3{config: config, objects: map[string]fakeObject{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	config.Endpoint = server.URL
+	return config
+}
+
+type fakeObject struct {
+	body     []byte
+	modified time.Time
+}
+
+type fakeS3 struct {
+	config S3Config
+
+	mu      sync.Mutex
+	objects map[string]fakeObject
+}
+
+// fakeS3PageSize is small so that listing goes through several pages.
+const fakeS3PageSize = 2
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	if err := f.verify(r, body); err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintf(w, "<Error><Code>SignatureDoesNotMatch</Code><Message>%s</Message></Error>", err)
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	bucket, key, _ := strings.Cut(path, "/")
+	if bucket != f.config.Bucket {
+		http.Error(w, "no such bucket", http.StatusNotFound)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case key == "" && r.Method == http.MethodGet:
+		f.list(w, r)
+	case r.Method == http.MethodPut:
+		f.objects[key] = fakeObject{body: body, modified: time.Now()}
+	case r.Method == http.MethodGet:
+		object, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, "<Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>")
+			return
+		}
+		w.Header().Set("Last-Modified", object.modified.UTC().Format(http.TimeFormat))
+		w.Header().Set("Content-Length", strconv.Itoa(len(object.body)))
+		w.Write(object.body)
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// verify checks the signature of the request the way S3 does, computing it
+// again from the request with the secret key.
+func (f *fakeS3) verify(r *http.Request, body []byte) error {
+	signer := &S3Storage{config: f.config}
+	query := r.URL.Query()
+	header := func(name string) string {
+		if name == "host" {
+			return r.Host
+		}
+		return strings.TrimSpace(strings.Join(r.Header.Values(name), ","))
+	}
+
+	var credential, signedHeaders, signature, amzDate, payloadHash string
+	if signature = query.Get("X-Amz-Signature"); signature != "" {
+		credential = query.Get("X-Amz-Credential")
+		signedHeaders = query.Get("X-Amz-SignedHeaders")
+		amzDate = query.Get("X-Amz-Date")
+		payloadHash = "UNSIGNED-PAYLOAD"
+		query.Del("X-Amz-Signature")
+	} else {
+		authorization := r.Header.Get("Authorization")
+		if !strings.HasPrefix(authorization, "AWS4-HMAC-SHA256 ") {
+			return fmt.Errorf("request is not signed")
+		}
+		for _, field := range strings.Split(strings.TrimPrefix(authorization, "AWS4-HMAC-SHA256 "), ", ") {
+			name, value, _ := strings.Cut(field, "=")
+			switch name {
+			case "Credential":
+				credential = value
+			case "SignedHeaders":
+				signedHeaders = value
+			case "Signature":
+				signature = value
+			}
+		}
+		amzDate = r.Header.Get("X-Amz-Date")
+		payloadHash = r.Header.Get("X-Amz-Content-Sha256")
+		hash := sha256.Sum256(body)
+		if payloadHash != hex.EncodeToString(hash[:]) {
+			return fmt.Errorf("payload does not match its hash")
+		}
+	}
+
+	now, err := time.Parse("20060102T150405Z", amzDate)
+	if err != nil {
+		return fmt.Errorf("bad date %q", amzDate)
+	}
+	if credential != f.config.AccessKey+"/"+signer.scope(now) {
+		return fmt.Errorf("bad credential %q", credential)
+	}
+	if expires := query.Get("X-Amz-Expires"); expires != "" {
+		seconds, err := strconv.Atoi(expires)
+		if err != nil || time.Now().After(now.Add(time.Duration(seconds)*time.Second)) {
+			return fmt.Errorf("link expired")
+		}
+	}
+
+	canonicalHeaders := ""
+	for _, name := range strings.Split(signedHeaders, ";") {
+		canonicalHeaders += name + ":" + header(name) + "\n"
+	}
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		escapePath(r.URL.Path),
+		canonicalQuery(query),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	_, expected := signer.signature(now, canonicalRequest)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return fmt.Errorf("signature does not match")
+	}
+	return nil
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, r *http.Request) {
+	keys := []string{}
+	for key := range f.objects {
+		if key > r.URL.Query().Get("continuation-token") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	type content struct {
+		Key          string
+		Size         int
+		LastModified time.Time
+	}
+	page := struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		Contents              []content
+		IsTruncated           bool
+		NextContinuationToken string `xml:",omitempty"`
+	}{}
+	for i, key := range keys {
+		if i == fakeS3PageSize {
+			page.IsTruncated = true
+			page.NextContinuationToken = keys[i-1]
+			break
+		}
+		page.Contents = append(page.Contents, content{Key: key, Size: len(f.objects[key].body), LastModified: f.objects[key].modified})
+	}
+	xml.NewEncoder(w).Encode(page)
+}
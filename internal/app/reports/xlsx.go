@@ -0,0 +1,199 @@
+package reports
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"user_balance_microservice/internal/app/model"
+)
+
+// The parts of an XLSX workbook besides its styles and its only sheet,
+// which depend on the report.
+const (
+	xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>` +
+		`</Types>`
+	xlsxRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`
+	xlsxWorkbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		`<sheets><sheet name="Report" sheetId="1" r:id="rId1"/></sheets>` +
+		`</workbook>`
+	xlsxWorkbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>` +
+		`</Relationships>`
+)
+
+// Styles of the cells of an XLSX report. Amounts take a style of their own
+// for every exponent, after these.
+const (
+	styleDefault = iota
+	styleBold
+	styleAmounts
+)
+
+// writeXLSX writes the report as a workbook of one sheet: a bold header,
+// the rows, and a bold row of the total of every currency. Amounts are
+// numbers shown with the digits of their currency and thousands
+// separators.
+func writeXLSX(w io.Writer, report *Report) error {
+	exponents := map[int]int{}
+	for _, revenue := range report.Rows {
+		exponents[model.Exponent(revenue.Currency)] = 0
+	}
+	ordered := []int{}
+	for exponent := range exponents {
+		ordered = append(ordered, exponent)
+	}
+	sort.Ints(ordered)
+	for i, exponent := range ordered {
+		exponents[exponent] = styleAmounts + 2*i
+	}
+
+	zipWriter := zip.NewWriter(w)
+	parts := []struct {
+		name    string
+		content string
+	}{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxRels},
+		{"xl/workbook.xml", xlsxWorkbook},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+		{"xl/styles.xml", xlsxStyles(ordered)},
+		{"xl/worksheets/sheet1.xml", xlsxSheet(report, exponents)},
+	}
+	for _, part := range parts {
+		partWriter, err := zipWriter.Create(part.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(partWriter, part.content); err != nil {
+			return err
+		}
+	}
+	return zipWriter.Close()
+}
+
+// xlsxStyles returns the styles of a report with amounts of the exponents.
+// The amounts of an exponent are styled as styleAmounts+2*i, where i is the
+// index of the exponent, and their totals with the next style, in bold.
+func xlsxStyles(exponents []int) string {
+	b := &strings.Builder{}
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`)
+	if len(exponents) > 0 {
+		fmt.Fprintf(b, `<numFmts count="%d">`, len(exponents))
+		for i, exponent := range exponents {
+			code := "#,##0"
+			if exponent > 0 {
+				code += "." + strings.Repeat("0", exponent)
+			}
+			fmt.Fprintf(b, `<numFmt numFmtId="%d" formatCode="%s"/>`, 164+i, code)
+		}
+		b.WriteString(`</numFmts>`)
+	}
+	b.WriteString(`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>` +
+		`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>` +
+		`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+		`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>`)
+	fmt.Fprintf(b, `<cellXfs count="%d">`, styleAmounts+2*len(exponents))
+	b.WriteString(`<xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>` +
+		`<xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/>`)
+	for i := range exponents {
+		fmt.Fprintf(b, `<xf numFmtId="%d" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>`, 164+i)
+		fmt.Fprintf(b, `<xf numFmtId="%d" fontId="1" fillId="0" borderId="0" xfId="0" applyNumberFormat="1" applyFont="1"/>`, 164+i)
+	}
+	b.WriteString(`</cellXfs></styleSheet>`)
+	return b.String()
+}
+
+// xlsxSheet returns the sheet of the report. styles gives the style of the
+// amounts of every exponent.
+func xlsxSheet(report *Report, styles map[int]int) string {
+	groupBy := report.Query.GroupBy
+	currencyColumn := xlsxColumn(len(groupBy))
+	amountColumn := xlsxColumn(len(groupBy) + 1)
+
+	b := &strings.Builder{}
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`)
+	fmt.Fprintf(b, `<cols><col min="1" max="%d" width="20" customWidth="1"/></cols>`, len(groupBy)+2)
+	b.WriteString(`<sheetData>`)
+
+	b.WriteString(`<row r="1">`)
+	for i, name := range header(groupBy) {
+		xlsxString(b, xlsxColumn(i)+"1", styleBold, name)
+	}
+	b.WriteString(`</row>`)
+
+	row := 1
+	for _, revenue := range report.Rows {
+		row++
+		fmt.Fprintf(b, `<row r="%d">`, row)
+		for i, grouping := range groupBy {
+			cell := xlsxColumn(i) + strconv.Itoa(row)
+			if grouping == model.GroupByUser {
+				fmt.Fprintf(b, `<c r="%s"><v>%d</v></c>`, cell, revenue.User_id)
+				continue
+			}
+			xlsxString(b, cell, styleDefault, groupOf(revenue, grouping))
+		}
+		xlsxString(b, currencyColumn+strconv.Itoa(row), styleDefault, revenue.Currency)
+		amount := model.Money{Amount: revenue.Amount, Currency: revenue.Currency}
+		fmt.Fprintf(b, `<c r="%s%d" s="%d"><v>%s</v></c>`, amountColumn, row, styles[model.Exponent(revenue.Currency)], amount)
+		b.WriteString(`</row>`)
+	}
+
+	// The totals are formulas, so that they follow the rows when these are
+	// edited, with the sums as their values until they are calculated.
+	last := row
+	for _, sum := range totals(report.Rows) {
+		row++
+		fmt.Fprintf(b, `<row r="%d">`, row)
+		xlsxString(b, "A"+strconv.Itoa(row), styleBold, "total")
+		xlsxString(b, currencyColumn+strconv.Itoa(row), styleBold, sum.Currency)
+		fmt.Fprintf(b, `<c r="%s%d" s="%d"><f>SUMIF(%s2:%s%d,"%s",%s2:%s%d)</f><v>%s</v></c>`,
+			amountColumn, row, styles[model.Exponent(sum.Currency)]+1,
+			currencyColumn, currencyColumn, last, xlsxEscape(sum.Currency), amountColumn, amountColumn, last,
+			sum)
+		b.WriteString(`</row>`)
+	}
+
+	b.WriteString(`</sheetData></worksheet>`)
+	return b.String()
+}
+
+// xlsxString writes a cell holding text.
+func xlsxString(b *strings.Builder, cell string, style int, text string) {
+	fmt.Fprintf(b, `<c r="%s" s="%d" t="inlineStr"><is><t xml:space="preserve">%s</t></is></c>`, cell, style, xlsxEscape(text))
+}
+
+func xlsxEscape(text string) string {
+	b := &bytes.Buffer{}
+	xml.EscapeText(b, []byte(text))
+	return b.String()
+}
+
+// xlsxColumn returns the name of the column of index i, counted from 0:
+// A to Z, then AA and on.
+func xlsxColumn(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
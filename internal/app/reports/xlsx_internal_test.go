@@ -0,0 +1,12 @@
+package reports
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestXLSXColumn(t *testing.T) {
+	for i, expected := range map[int]string{0: "A", 4: "E", 25: "Z", 26: "AA", 27: "AB", 701: "ZZ", 702: "AAA"} {
+		assert.Equal(t, expected, xlsxColumn(i), i)
+	}
+}
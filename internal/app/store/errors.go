@@ -3,5 +3,7 @@ package store
 import "errors"
 
 var (
-	RecordNotFound = errors.New("Record not found")
+	RecordNotFound  = errors.New("Record not found")
+	RecordExists    = errors.New("Record already exists")
+	UnbalancedEntry = errors.New("Journal entry is not balanced")
 )
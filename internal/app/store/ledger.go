@@ -0,0 +1,21 @@
+package store
+
+import "user_balance_microservice/internal/app/model"
+
+// CheckEntry returns UnbalancedEntry unless the postings of the entry add up
+// to zero in every currency.
+func CheckEntry(entry *model.JournalEntry) error {
+	if len(entry.Postings) == 0 {
+		return UnbalancedEntry
+	}
+	sums := make(map[string]model.Amount)
+	for _, posting := range entry.Postings {
+		sums[model.LedgerAccountCurrency(posting.Account)] += posting.Amount
+	}
+	for _, sum := range sums {
+		if sum != 0 {
+			return UnbalancedEntry
+		}
+	}
+	return nil
+}
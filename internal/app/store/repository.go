@@ -1,26 +1,70 @@
 package store
 
 import (
-	"database/sql"
+	"context"
+	"time"
 	"user_balance_microservice/internal/app/model"
 )
 
 type UserAccountRepository interface {
-	Create(*sql.Tx, *model.UserAccount) error
-	FindById(int) (*model.UserAccount, error)
-	Add(*sql.Tx, *model.UserAccount) (*model.UserAccount, error)
-	Reserve(*sql.Tx, *model.UserAccount) (*model.UserAccount, error)
-	ConfirmReserve(*sql.Tx, *model.UserAccount) (*model.UserAccount, error)
-	AbortReserve(*sql.Tx, *model.UserAccount) (*model.UserAccount, error)
-	Transfer(*sql.Tx, int, int, int) (*model.UserAccount, error)
+	Create(context.Context, *model.UserAccount) error
+	FindById(context.Context, int, string) (*model.UserAccount, error)
+	FindByUser(context.Context, int) ([]model.UserAccount, error)
+	FindForUpdate(context.Context, ...model.Wallet) (map[model.Wallet]*model.UserAccount, error)
+	Add(context.Context, *model.UserAccount) (*model.UserAccount, error)
+	Reserve(context.Context, *model.UserAccount) (*model.UserAccount, error)
+	ConfirmReserve(context.Context, *model.UserAccount) (*model.UserAccount, error)
+	AbortReserve(context.Context, *model.UserAccount) (*model.UserAccount, error)
+	Transfer(context.Context, model.Wallet, model.Wallet, model.Amount, model.Amount) (*model.UserAccount, error)
 }
 
 type TransactionRepository interface {
-	CreateReserveTransaction(*sql.Tx, *model.Transaction) error
-	CreateAddTransaction(*sql.Tx, *model.Transaction) error
-	GetTransaction(*model.Transaction) (*model.Transaction, error)
-	ConfirmReserveTransaction(*sql.Tx, int) error
-	AbortReserveTransaction(*sql.Tx, int) error
-	GetMonthReport(int, int) (map[string]int, error)
-	GetAccountReport(int, string, string, int, int) (*[]model.AccountTransaction, error)
+	CreateReserveTransaction(context.Context, *model.Transaction) error
+	CreateAddTransaction(context.Context, *model.Transaction) error
+	CreateLinkedTransaction(context.Context, *model.Transaction) error
+	GetTransaction(context.Context, *model.Transaction) (*model.Transaction, error)
+	GetReservation(context.Context, int) (*model.Transaction, error)
+	LockReservation(context.Context, int) (*model.Transaction, error)
+	ConfirmReserveTransaction(context.Context, int) error
+	AbortReserveTransaction(context.Context, int) error
+	ReduceReserveTransaction(context.Context, int, model.Amount) error
+	GetExpiredReservations(context.Context, time.Time, int) ([]model.Transaction, error)
+	GetOpenReservations(context.Context, int) ([]model.Transaction, error)
+	GetCharges(context.Context, int, int, int, string) ([]model.Transaction, error)
+	GetRefundedAmount(context.Context, int) (model.Amount, error)
+	GetMonthReport(context.Context, int, int) ([]model.ServiceRevenue, error)
+	GetRevenueReport(context.Context, model.ReportQuery) ([]model.Revenue, error)
+	GetAccountReport(context.Context, int, string, string, string, int, int) (*[]model.AccountTransaction, error)
+	GetAccountReportAfter(context.Context, int, string, string, string, *model.AccountTransaction, int) (*[]model.AccountTransaction, error)
+}
+
+type IdempotencyKeyRepository interface {
+	Create(context.Context, *model.IdempotencyKey) error
+	TakeOver(context.Context, *model.IdempotencyKey, time.Time, time.Time) error
+	FindByKey(context.Context, string) (*model.IdempotencyKey, error)
+	SaveResponse(context.Context, *model.IdempotencyKey) error
+	Release(context.Context, *model.IdempotencyKey) error
+	DeleteExpired(context.Context, time.Time) (int, error)
+}
+
+type LedgerRepository interface {
+	Post(context.Context, *model.JournalEntry) error
+	Balance(context.Context, string) (model.Amount, error)
+}
+
+type ConversionRepository interface {
+	Create(context.Context, *model.Conversion) error
+}
+
+type ReportJobRepository interface {
+	Create(context.Context, *model.ReportJob) error
+	Find(context.Context, int) (*model.ReportJob, error)
+	Claim(context.Context, time.Time) (*model.ReportJob, error)
+	Finish(context.Context, *model.ReportJob) error
+}
+
+type ReconciliationRepository interface {
+	FindDiscrepancies() ([]model.Discrepancy, error)
+	FindLedgerDiscrepancies() ([]model.Discrepancy, error)
+	SaveDiscrepancies([]model.Discrepancy) error
 }
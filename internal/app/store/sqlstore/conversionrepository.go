@@ -0,0 +1,22 @@
+package sqlstore
+
+import (
+	"context"
+	"user_balance_microservice/internal/app/model"
+)
+
+type ConversionRepository struct {
+	store *txStore
+}
+
+func (r *ConversionRepository) Create(ctx context.Context, conversion *model.Conversion) error {
+	return r.store.db.QueryRowContext(ctx,
+		"INSERT INTO conversions (from_currency, from_amount, to_currency, to_amount, rate, spread) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at",
+		conversion.From_currency,
+		conversion.From_amount,
+		conversion.To_currency,
+		conversion.To_amount,
+		conversion.Rate,
+		conversion.Spread,
+	).Scan(&conversion.Id, &conversion.Created_at)
+}
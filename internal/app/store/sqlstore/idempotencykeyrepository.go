@@ -0,0 +1,118 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"time"
+	"user_balance_microservice/internal/app/model"
+	"user_balance_microservice/internal/app/store"
+)
+
+type IdempotencyKeyRepository struct {
+	store *txStore
+}
+
+// Create stores a key without a response, held by its owner. It returns
+// store.RecordExists if the key is already taken.
+func (r *IdempotencyKeyRepository) Create(ctx context.Context, key *model.IdempotencyKey) error {
+	if err := r.store.db.QueryRowContext(ctx,
+		"INSERT INTO idempotency_keys (key, request_hash, owner) VALUES ($1, $2, $3) ON CONFLICT (key) DO NOTHING RETURNING created_at",
+		key.Key,
+		key.Request_hash,
+		key.Owner,
+	).Scan(&key.Created_at); err != nil {
+		if err == sql.ErrNoRows {
+			return store.RecordExists
+		}
+		return err
+	}
+	return nil
+}
+
+// TakeOver gives a stored key to a new owner as a key without a response, if
+// the key was created before expired, or if it is still without a response
+// for the same request since before abandoned. It returns
+// store.RecordExists if the key can't be taken over, for example because
+// another request took it over first.
+func (r *IdempotencyKeyRepository) TakeOver(ctx context.Context, key *model.IdempotencyKey, expired, abandoned time.Time) error {
+	if err := r.store.db.QueryRowContext(ctx,
+		`UPDATE idempotency_keys
+				SET request_hash = $2, owner = $3, status_code = NULL, response = NULL, created_at = now()
+				WHERE key = $1
+					AND (created_at < $4 OR (status_code IS NULL AND request_hash = $2 AND created_at < $5))
+				RETURNING created_at`,
+		key.Key,
+		key.Request_hash,
+		key.Owner,
+		expired,
+		abandoned,
+	).Scan(&key.Created_at); err != nil {
+		if err == sql.ErrNoRows {
+			return store.RecordExists
+		}
+		return err
+	}
+	return nil
+}
+
+func (r *IdempotencyKeyRepository) FindByKey(ctx context.Context, key string) (*model.IdempotencyKey, error) {
+	record := &model.IdempotencyKey{}
+	if err := r.store.db.QueryRowContext(ctx,
+		"SELECT key, request_hash, owner, coalesce(status_code, 0), response, created_at from idempotency_keys where key=$1",
+		key,
+	).Scan(
+		&record.Key,
+		&record.Request_hash,
+		&record.Owner,
+		&record.Status_code,
+		&record.Response,
+		&record.Created_at,
+	); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// SaveResponse stores the response of a key held by its owner. It returns
+// store.RecordNotFound if the owner no longer holds the key.
+func (r *IdempotencyKeyRepository) SaveResponse(ctx context.Context, key *model.IdempotencyKey) error {
+	result, err := r.store.db.ExecContext(ctx,
+		"UPDATE idempotency_keys SET status_code = $1, response = $2 where key = $3 and owner = $4 and status_code is null",
+		key.Status_code,
+		key.Response,
+		key.Key,
+		key.Owner,
+	)
+	if err != nil {
+		return err
+	}
+	if saved, err := result.RowsAffected(); err != nil || saved == 0 {
+		if err == nil {
+			err = store.RecordNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// Release deletes a key without a response, if it is still held by its
+// owner.
+func (r *IdempotencyKeyRepository) Release(ctx context.Context, key *model.IdempotencyKey) error {
+	_, err := r.store.db.ExecContext(ctx,
+		"DELETE FROM idempotency_keys where key = $1 and owner = $2 and status_code is null",
+		key.Key,
+		key.Owner,
+	)
+	return err
+}
+
+// DeleteExpired removes the keys created before the given time and returns
+// how many were removed.
+func (r *IdempotencyKeyRepository) DeleteExpired(ctx context.Context, before time.Time) (int, error) {
+	result, err := r.store.db.ExecContext(ctx, "DELETE FROM idempotency_keys where created_at < $1", before)
+	if err != nil {
+		return 0, err
+	}
+	deleted, err := result.RowsAffected()
+	return int(deleted), err
+}
@@ -0,0 +1,48 @@
+package sqlstore
+
+import (
+	"context"
+	"user_balance_microservice/internal/app/model"
+	"user_balance_microservice/internal/app/store"
+)
+
+type LedgerRepository struct {
+	store *txStore
+}
+
+// Post stores a balanced journal entry with its postings. It returns
+// store.UnbalancedEntry if the postings do not add up to zero.
+func (r *LedgerRepository) Post(ctx context.Context, entry *model.JournalEntry) error {
+	if err := store.CheckEntry(entry); err != nil {
+		return err
+	}
+
+	if err := r.store.db.QueryRowContext(ctx,
+		"INSERT INTO journal_entries (transaction_id, description) VALUES (nullif($1, 0), $2) RETURNING id, created_at",
+		entry.Transaction_id,
+		entry.Description,
+	).Scan(&entry.Id, &entry.Created_at); err != nil {
+		return err
+	}
+	for _, posting := range entry.Postings {
+		if _, err := r.store.db.ExecContext(ctx,
+			"INSERT INTO postings (entry_id, account, amount) VALUES ($1, $2, $3)",
+			entry.Id,
+			posting.Account,
+			posting.Amount,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Balance returns the sum of all postings to the ledger account.
+func (r *LedgerRepository) Balance(ctx context.Context, account string) (model.Amount, error) {
+	var balance model.Amount
+	err := r.store.db.QueryRowContext(ctx,
+		"SELECT coalesce(sum(amount), 0) FROM postings WHERE account = $1",
+		account,
+	).Scan(&balance)
+	return balance, err
+}
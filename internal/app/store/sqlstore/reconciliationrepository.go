@@ -0,0 +1,121 @@
+package sqlstore
+
+import "user_balance_microservice/internal/app/model"
+
+type ReconciliationRepository struct {
+	store *txStore
+}
+
+// FindDiscrepancies recomputes the balances of every account from its
+// transactions and returns the accounts whose stored balances differ.
+// Open reservations hold their current amount; closed ones cost the user what
+// was charged for them, which is the whole amount when they were confirmed
+// and the linked charges otherwise. Releases are already accounted for by
+// the reduced or unsuccessful reservations they are linked to.
+func (r *ReconciliationRepository) FindDiscrepancies() ([]model.Discrepancy, error) {
+	discrepancies := []model.Discrepancy{}
+	rows, err := r.store.db.Query(
+		`select user_id, currency, balance, expected_balance, reserved_balance, expected_reserved
+				from (
+					select a.user_id, a.currency, a.balance, a.reserved_balance,
+						coalesce(sum(case
+							when t.type in ('add', 'transfer_in', 'refund') then t.amount
+							when t.type in ('transfer_out', 'charge') then -t.amount
+							when t.type = 'reserve' and (t.closed_date is null or t.success_flg) then -t.amount
+							else 0
+						end), 0) expected_balance,
+						coalesce(sum(case
+							when t.type = 'reserve' and t.closed_date is null then t.amount
+							else 0
+						end), 0) expected_reserved
+					from user_accounts a
+					left join transactions t
+						on t.user_id = a.user_id
+						and t.currency = a.currency
+					group by a.user_id, a.currency, a.balance, a.reserved_balance
+				) accounts
+				where balance <> expected_balance
+					or reserved_balance <> expected_reserved
+				order by user_id, currency`)
+	if err != nil {
+		return discrepancies, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		discrepancy := model.Discrepancy{}
+		if err := rows.Scan(
+			&discrepancy.User_id,
+			&discrepancy.Currency,
+			&discrepancy.Balance,
+			&discrepancy.Expected_balance,
+			&discrepancy.Reserved_balance,
+			&discrepancy.Expected_reserved,
+		); err != nil {
+			return discrepancies, err
+		}
+		discrepancies = append(discrepancies, discrepancy)
+	}
+
+	return discrepancies, rows.Err()
+}
+
+// FindLedgerDiscrepancies returns the accounts whose stored balances differ
+// from the balances of their user and reserved ledger accounts.
+func (r *ReconciliationRepository) FindLedgerDiscrepancies() ([]model.Discrepancy, error) {
+	discrepancies := []model.Discrepancy{}
+	rows, err := r.store.db.Query(
+		`with ledger as (
+					select account, sum(amount) amount
+					from postings
+					group by account
+				)
+				select a.user_id, a.currency, a.balance, coalesce(u.amount, 0), a.reserved_balance, coalesce(r.amount, 0)
+				from user_accounts a
+				left join ledger u
+					on u.account = 'user:' || a.user_id || ':' || a.currency
+				left join ledger r
+					on r.account = 'reserved:' || a.user_id || ':' || a.currency
+				where a.balance <> coalesce(u.amount, 0)
+					or a.reserved_balance <> coalesce(r.amount, 0)
+				order by a.user_id, a.currency`)
+	if err != nil {
+		return discrepancies, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		discrepancy := model.Discrepancy{}
+		if err := rows.Scan(
+			&discrepancy.User_id,
+			&discrepancy.Currency,
+			&discrepancy.Balance,
+			&discrepancy.Expected_balance,
+			&discrepancy.Reserved_balance,
+			&discrepancy.Expected_reserved,
+		); err != nil {
+			return discrepancies, err
+		}
+		discrepancies = append(discrepancies, discrepancy)
+	}
+
+	return discrepancies, rows.Err()
+}
+
+func (r *ReconciliationRepository) SaveDiscrepancies(discrepancies []model.Discrepancy) error {
+	for _, discrepancy := range discrepancies {
+		if _, err := r.store.db.Exec(
+			`INSERT INTO discrepancies (user_id, currency, balance, expected_balance, reserved_balance, expected_reserved, source, found_at)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+			discrepancy.User_id,
+			discrepancy.Currency,
+			discrepancy.Balance,
+			discrepancy.Expected_balance,
+			discrepancy.Reserved_balance,
+			discrepancy.Expected_reserved,
+			discrepancy.Source,
+			discrepancy.Found_at,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
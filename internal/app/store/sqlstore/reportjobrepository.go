@@ -0,0 +1,84 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"github.com/lib/pq"
+	"time"
+	"user_balance_microservice/internal/app/model"
+)
+
+type ReportJobRepository struct {
+	store *txStore
+}
+
+const reportJobColumns = "id, status, from_date, to_date, group_by, format, bom, delimiter, coalesce(file, ''), coalesce(error, ''), created_at, started_at, finished_at"
+
+func (r *ReportJobRepository) Create(ctx context.Context, job *model.ReportJob) error {
+	return r.store.db.QueryRowContext(ctx,
+		"INSERT INTO report_jobs (from_date, to_date, group_by, format, bom, delimiter) VALUES ($1::date, $2::date, $3, $4, $5, $6) RETURNING id, status, created_at",
+		job.Query.From.Format("2006-01-02"),
+		job.Query.To.Format("2006-01-02"),
+		pq.Array(job.Query.GroupBy),
+		job.Format.Name,
+		job.Format.BOM,
+		job.Format.Delimiter,
+	).Scan(&job.Id, &job.Status, &job.Created_at)
+}
+
+func (r *ReportJobRepository) Find(ctx context.Context, id int) (*model.ReportJob, error) {
+	return scanReportJob(r.store.db.QueryRowContext(ctx,
+		"SELECT "+reportJobColumns+" FROM report_jobs WHERE id = $1",
+		id,
+	))
+}
+
+// Claim marks the oldest pending job, or a job still running since before
+// the given time, as running and returns it. Jobs claimed by other
+// transactions are skipped.
+func (r *ReportJobRepository) Claim(ctx context.Context, staleBefore time.Time) (*model.ReportJob, error) {
+	return scanReportJob(r.store.db.QueryRowContext(ctx,
+		`UPDATE report_jobs SET status = 'running', started_at = now()
+				WHERE id = (
+					SELECT id FROM report_jobs
+					WHERE status = 'pending' OR (status = 'running' AND started_at < $1)
+					ORDER BY created_at, id
+					LIMIT 1
+					FOR UPDATE SKIP LOCKED)
+				RETURNING `+reportJobColumns,
+		staleBefore,
+	))
+}
+
+// Finish records the status, file and error of a job that ran.
+func (r *ReportJobRepository) Finish(ctx context.Context, job *model.ReportJob) error {
+	return r.store.db.QueryRowContext(ctx,
+		"UPDATE report_jobs SET status = $2, file = nullif($3, ''), error = nullif($4, ''), finished_at = now() WHERE id = $1 RETURNING finished_at",
+		job.Id,
+		job.Status,
+		job.File,
+		job.Error,
+	).Scan(&job.Finished_at)
+}
+
+func scanReportJob(row *sql.Row) (*model.ReportJob, error) {
+	job := &model.ReportJob{}
+	if err := row.Scan(
+		&job.Id,
+		&job.Status,
+		&job.Query.From,
+		&job.Query.To,
+		pq.Array(&job.Query.GroupBy),
+		&job.Format.Name,
+		&job.Format.BOM,
+		&job.Format.Delimiter,
+		&job.File,
+		&job.Error,
+		&job.Created_at,
+		&job.Started_at,
+		&job.Finished_at,
+	); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
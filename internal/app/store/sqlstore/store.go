@@ -1,24 +1,71 @@
 package sqlstore
 
 import (
+	"context"
 	"database/sql"
 	_ "github.com/lib/pq"
 	"user_balance_microservice/internal/app/store"
 )
 
+// querier is implemented by both *sql.DB and *sql.Tx, so the same
+// repositories serve plain queries and queries inside a transaction.
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
 type Store struct {
-	db                    *sql.DB
-	userAccountRepository *UserAccountRepository
-	transactionRepository *TransactionRepository
+	db *sql.DB
+	txStore
 }
 
 func New(db *sql.DB) *Store {
-	return &Store{
-		db: db,
+	s := &Store{
+		db:      db,
+		txStore: txStore{db: db},
+	}
+	s.txStore.init()
+	return s
+}
+
+// WithinTx runs fn in a transaction that is committed when fn returns nil
+// and rolled back when it returns an error or panics.
+func (s *Store) WithinTx(ctx context.Context, fn func(store.TxStore) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
 	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(&txStore{db: tx}); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+type txStore struct {
+	db                       querier
+	userAccountRepository    *UserAccountRepository
+	transactionRepository    *TransactionRepository
+	idempotencyKeyRepository *IdempotencyKeyRepository
+	ledgerRepository         *LedgerRepository
+	reconciliationRepository *ReconciliationRepository
+	conversionRepository     *ConversionRepository
+	reportJobRepository      *ReportJobRepository
 }
 
-func (s *Store) UserAccount() store.UserAccountRepository {
+func (s *txStore) UserAccount() store.UserAccountRepository {
 	if s.userAccountRepository != nil {
 		return s.userAccountRepository
 	}
@@ -29,7 +76,7 @@ func (s *Store) UserAccount() store.UserAccountRepository {
 	return s.userAccountRepository
 }
 
-func (s *Store) Transaction() store.TransactionRepository {
+func (s *txStore) Transaction() store.TransactionRepository {
 	if s.transactionRepository != nil {
 		return s.transactionRepository
 	}
@@ -40,6 +87,70 @@ func (s *Store) Transaction() store.TransactionRepository {
 	return s.transactionRepository
 }
 
-func (s *Store) BeginTx() (*sql.Tx, error) {
-	return s.db.Begin()
+func (s *txStore) IdempotencyKey() store.IdempotencyKeyRepository {
+	if s.idempotencyKeyRepository != nil {
+		return s.idempotencyKeyRepository
+	}
+
+	s.idempotencyKeyRepository = &IdempotencyKeyRepository{
+		store: s,
+	}
+	return s.idempotencyKeyRepository
+}
+
+func (s *txStore) Ledger() store.LedgerRepository {
+	if s.ledgerRepository != nil {
+		return s.ledgerRepository
+	}
+
+	s.ledgerRepository = &LedgerRepository{
+		store: s,
+	}
+	return s.ledgerRepository
+}
+
+func (s *txStore) Reconciliation() store.ReconciliationRepository {
+	if s.reconciliationRepository != nil {
+		return s.reconciliationRepository
+	}
+
+	s.reconciliationRepository = &ReconciliationRepository{
+		store: s,
+	}
+	return s.reconciliationRepository
+}
+
+func (s *txStore) Conversion() store.ConversionRepository {
+	if s.conversionRepository != nil {
+		return s.conversionRepository
+	}
+
+	s.conversionRepository = &ConversionRepository{
+		store: s,
+	}
+	return s.conversionRepository
+}
+
+func (s *txStore) ReportJob() store.ReportJobRepository {
+	if s.reportJobRepository != nil {
+		return s.reportJobRepository
+	}
+
+	s.reportJobRepository = &ReportJobRepository{
+		store: s,
+	}
+	return s.reportJobRepository
+}
+
+// init makes every repository up front. The repositories of a Store are
+// shared by concurrent requests and workers, so they can't be made on first
+// use like the ones of a transaction.
+func (s *txStore) init() {
+	s.UserAccount()
+	s.Transaction()
+	s.IdempotencyKey()
+	s.Ledger()
+	s.Reconciliation()
+	s.Conversion()
+	s.ReportJob()
 }
@@ -11,9 +11,6 @@ var (
 
 func TestMain(m *testing.M) {
 	databaseURL = os.Getenv("DATABASE_URL")
-	if databaseURL == "" {
-		databaseURL = "host=localhost database=avito user=avito password=avito sslmode=disable"
-	}
 
 	os.Exit(m.Run())
 }
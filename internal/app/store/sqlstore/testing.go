@@ -2,25 +2,32 @@ package sqlstore
 
 import (
 	"database/sql"
+	"fmt"
 	"strings"
 	"testing"
 )
 
+// TestDB connects to the test database. The test is skipped when no database
+// is configured, but a configured database that can't be reached fails it.
 func TestDB(t *testing.T, databaseURL string) (*sql.DB, func(...string)) {
 	t.Helper()
 
+	if databaseURL == "" {
+		t.Skip("DATABASE_URL is not set")
+	}
+
 	db, err := sql.Open("postgres", databaseURL)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	if err := db.Ping(); err != nil {
-		t.Fatal(err)
+		t.Fatalf("database is not available: %v", err)
 	}
 
 	return db, func(tables ...string) {
 		if len(tables) > 0 {
-			db.Exec("TRUNCATE %s CASCADE", strings.Join(tables, ", "))
+			db.Exec(fmt.Sprintf("TRUNCATE %s CASCADE", strings.Join(tables, ", ")))
 		}
 
 		db.Close()
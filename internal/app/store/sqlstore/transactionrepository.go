@@ -1,77 +1,278 @@
 package sqlstore
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"strings"
+	"time"
 	"user_balance_microservice/internal/app/model"
 )
 
 type TransactionRepository struct {
-	store *Store
+	store *txStore
 }
 
-func (r *TransactionRepository) CreateReserveTransaction(tx *sql.Tx, transaction *model.Transaction) error {
-	return tx.QueryRow(
-		"INSERT INTO transactions (user_id, amount, description, order_id, service_id, type) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id",
+func (r *TransactionRepository) CreateReserveTransaction(ctx context.Context, transaction *model.Transaction) error {
+	return r.store.db.QueryRowContext(ctx,
+		"INSERT INTO transactions (user_id, amount, currency, description, order_id, service_id, expires_at, type) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id, created_at",
 		transaction.User_id,
 		transaction.Amount,
+		transaction.Currency,
 		transaction.Description,
 		transaction.Order_id,
 		transaction.Service_id,
+		transaction.Expires_at,
 		transaction.Type,
-	).Scan(&transaction.Id)
+	).Scan(&transaction.Id, &transaction.Created_at)
 }
 
-func (r *TransactionRepository) CreateAddTransaction(tx *sql.Tx, transaction *model.Transaction) error {
-	return tx.QueryRow(
-		"INSERT INTO transactions (user_id, amount, description, closed_date, success_flg, type) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id",
+func (r *TransactionRepository) CreateAddTransaction(ctx context.Context, transaction *model.Transaction) error {
+	return r.store.db.QueryRowContext(ctx,
+		"INSERT INTO transactions (user_id, amount, currency, description, closed_date, success_flg, type, conversion_id) VALUES ($1, $2, $3, $4, $5, $6, $7, nullif($8, 0)) RETURNING id, created_at",
 		transaction.User_id,
 		transaction.Amount,
+		transaction.Currency,
 		transaction.Description,
 		transaction.Closed_date,
 		transaction.Success_flg,
 		transaction.Type,
-	).Scan(&transaction.Id)
+		transaction.Conversion_id,
+	).Scan(&transaction.Id, &transaction.Created_at)
 }
 
-func (r *TransactionRepository) GetTransaction(transaction *model.Transaction) (*model.Transaction, error) {
-	if err := r.store.db.QueryRow(
-		"select id from transactions where user_id = $1 and order_id=$2 and service_id=$3 and amount=$4 and closed_date is null",
+// CreateLinkedTransaction stores a closed entry that settles a part of the
+// reservation referenced by Parent_id.
+func (r *TransactionRepository) CreateLinkedTransaction(ctx context.Context, transaction *model.Transaction) error {
+	return r.store.db.QueryRowContext(ctx,
+		"INSERT INTO transactions (user_id, amount, currency, description, order_id, service_id, closed_date, success_flg, type, parent_id) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id, created_at",
+		transaction.User_id,
+		transaction.Amount,
+		transaction.Currency,
+		transaction.Description,
+		transaction.Order_id,
+		transaction.Service_id,
+		transaction.Closed_date,
+		transaction.Success_flg,
+		transaction.Type,
+		transaction.Parent_id,
+	).Scan(&transaction.Id, &transaction.Created_at)
+}
+
+func (r *TransactionRepository) GetTransaction(ctx context.Context, transaction *model.Transaction) (*model.Transaction, error) {
+	if err := r.store.db.QueryRowContext(ctx,
+		"select id from transactions where user_id = $1 and order_id=$2 and service_id=$3 and amount=$4 and currency=$5 and closed_date is null",
 		transaction.User_id,
 		transaction.Order_id,
 		transaction.Service_id,
 		transaction.Amount,
+		transaction.Currency,
 	).Scan(&transaction.Id); err != nil {
 		return nil, err
 	}
 	return transaction, nil
 }
 
-func (r *TransactionRepository) ConfirmReserveTransaction(tx *sql.Tx, transactionId int) error {
-	return tx.QueryRow(
-		"update transactions set success_flg = true, closed_date = now() where id = $1 RETURNING id",
+// GetReservation returns the open reservation with the given id.
+func (r *TransactionRepository) GetReservation(ctx context.Context, id int) (*model.Transaction, error) {
+	return r.getReservation(ctx, id, "")
+}
+
+// LockReservation returns the open reservation with the given id and locks
+// its row until the end of the transaction, so that concurrent confirms and
+// aborts of the reservation see the amount left by each other.
+func (r *TransactionRepository) LockReservation(ctx context.Context, id int) (*model.Transaction, error) {
+	return r.getReservation(ctx, id, "for update")
+}
+
+func (r *TransactionRepository) getReservation(ctx context.Context, id int, lock string) (*model.Transaction, error) {
+	reservation := &model.Transaction{Type: "reserve"}
+	if err := r.store.db.QueryRowContext(ctx,
+		fmt.Sprintf(`select id, user_id, amount, currency, description, order_id, service_id, created_at, expires_at
+				from transactions
+				where id = $1 and type = 'reserve' and closed_date is null
+				%s`, lock),
+		id,
+	).Scan(
+		&reservation.Id,
+		&reservation.User_id,
+		&reservation.Amount,
+		&reservation.Currency,
+		&reservation.Description,
+		&reservation.Order_id,
+		&reservation.Service_id,
+		&reservation.Created_at,
+		&reservation.Expires_at,
+	); err != nil {
+		return nil, err
+	}
+	return reservation, nil
+}
+
+func (r *TransactionRepository) ConfirmReserveTransaction(ctx context.Context, transactionId int) error {
+	return r.store.db.QueryRowContext(ctx,
+		"update transactions set success_flg = true, closed_date = now() where id = $1 and closed_date is null RETURNING id",
+		transactionId,
+	).Scan(&transactionId)
+}
+
+func (r *TransactionRepository) AbortReserveTransaction(ctx context.Context, transactionId int) error {
+	return r.store.db.QueryRowContext(ctx,
+		"update transactions set closed_date = now() where id = $1 and closed_date is null RETURNING id",
 		transactionId,
 	).Scan(&transactionId)
 }
 
-func (r *TransactionRepository) AbortReserveTransaction(tx *sql.Tx, transactionId int) error {
-	return tx.QueryRow(
-		"update transactions set closed_date = now() where id = $1 RETURNING id",
+// ReduceReserveTransaction lowers the amount of an open reservation that
+// stays open after a part of it was released.
+func (r *TransactionRepository) ReduceReserveTransaction(ctx context.Context, transactionId int, amount model.Amount) error {
+	return r.store.db.QueryRowContext(ctx,
+		"update transactions set amount = amount - $2 where id = $1 and closed_date is null and amount > $2 RETURNING id",
 		transactionId,
+		amount,
 	).Scan(&transactionId)
 }
 
-func (r *TransactionRepository) GetMonthReport(month int, year int) (map[string]int, error) {
-	var report map[string]int = make(map[string]int)
-	rows, err := r.store.db.Query(
-		`select s.name service, sum(amount) amount
+// GetExpiredReservations returns up to limit open reservations that expired
+// before the given time, oldest first.
+func (r *TransactionRepository) GetExpiredReservations(ctx context.Context, before time.Time, limit int) ([]model.Transaction, error) {
+	reservations := []model.Transaction{}
+	rows, err := r.store.db.QueryContext(ctx,
+		`select id, user_id, amount, currency, order_id, service_id, expires_at
+				from transactions
+				where type = 'reserve'
+					and closed_date is null
+					and expires_at < $1
+				order by expires_at
+				limit $2`,
+		before,
+		limit)
+	if err != nil {
+		return reservations, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		reservation := model.Transaction{Type: "reserve"}
+		if err := rows.Scan(
+			&reservation.Id,
+			&reservation.User_id,
+			&reservation.Amount,
+			&reservation.Currency,
+			&reservation.Order_id,
+			&reservation.Service_id,
+			&reservation.Expires_at,
+		); err != nil {
+			return reservations, err
+		}
+		reservations = append(reservations, reservation)
+	}
+
+	return reservations, rows.Err()
+}
+
+// GetOpenReservations returns the open reservations of the user, oldest
+// first.
+func (r *TransactionRepository) GetOpenReservations(ctx context.Context, userId int) ([]model.Transaction, error) {
+	reservations := []model.Transaction{}
+	rows, err := r.store.db.QueryContext(ctx,
+		`select id, user_id, amount, currency, description, order_id, service_id, created_at, expires_at
+				from transactions
+				where user_id = $1
+					and type = 'reserve'
+					and closed_date is null
+				order by created_at, id`,
+		userId)
+	if err != nil {
+		return reservations, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		reservation := model.Transaction{Type: "reserve"}
+		if err := rows.Scan(
+			&reservation.Id,
+			&reservation.User_id,
+			&reservation.Amount,
+			&reservation.Currency,
+			&reservation.Description,
+			&reservation.Order_id,
+			&reservation.Service_id,
+			&reservation.Created_at,
+			&reservation.Expires_at,
+		); err != nil {
+			return reservations, err
+		}
+		reservations = append(reservations, reservation)
+	}
+	return reservations, rows.Err()
+}
+
+// GetCharges locks and returns the confirmed charges of the user for the
+// order of the service in the currency, oldest first.
+func (r *TransactionRepository) GetCharges(ctx context.Context, userId, serviceId, orderId int, currency string) ([]model.Transaction, error) {
+	charges := []model.Transaction{}
+	rows, err := r.store.db.QueryContext(ctx,
+		`select id, user_id, amount, currency, order_id, service_id, type
+				from transactions
+				where user_id = $1
+					and service_id = $2
+					and order_id = $3
+					and currency = $4
+					and success_flg = true
+					and type in ('reserve', 'charge')
+				order by id
+				for update`,
+		userId,
+		serviceId,
+		orderId,
+		currency)
+	if err != nil {
+		return charges, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		charge := model.Transaction{Success_flg: true}
+		if err := rows.Scan(
+			&charge.Id,
+			&charge.User_id,
+			&charge.Amount,
+			&charge.Currency,
+			&charge.Order_id,
+			&charge.Service_id,
+			&charge.Type,
+		); err != nil {
+			return charges, err
+		}
+		charges = append(charges, charge)
+	}
+
+	return charges, rows.Err()
+}
+
+// GetRefundedAmount returns how much of the charge was already refunded.
+func (r *TransactionRepository) GetRefundedAmount(ctx context.Context, chargeId int) (model.Amount, error) {
+	var refunded model.Amount
+	err := r.store.db.QueryRowContext(ctx,
+		"select coalesce(sum(amount), 0) from transactions where parent_id = $1 and type = 'refund'",
+		chargeId,
+	).Scan(&refunded)
+	return refunded, err
+}
+
+// GetMonthReport returns the revenue of every service per currency, with
+// refunds subtracted, ordered by service and currency.
+func (r *TransactionRepository) GetMonthReport(ctx context.Context, month int, year int) ([]model.ServiceRevenue, error) {
+	report := []model.ServiceRevenue{}
+	rows, err := r.store.db.QueryContext(ctx,
+		`select s.name service, t.currency, sum(case when t.type = 'refund' then -t.amount else t.amount end) amount
 				from transactions t
 				join servicies s
 					on t.service_id = s.id
 				where t.success_flg = true
+					and t.type in ('reserve', 'charge', 'refund')
 					and	extract(month from t.closed_date) = $1
 					and extract(year from t.closed_date) = $2
-				group by s.name`,
+				group by s.name, t.currency
+				order by s.name, t.currency`,
 		month,
 		year)
 	if err != nil {
@@ -79,46 +280,175 @@ func (r *TransactionRepository) GetMonthReport(month int, year int) (map[string]
 	}
 	defer rows.Close()
 	for rows.Next() {
-		var service string
-		var amount int
-		if err := rows.Scan(&service, &amount); err != nil {
+		revenue := model.ServiceRevenue{}
+		if err := rows.Scan(&revenue.Service, &revenue.Currency, &revenue.Amount); err != nil {
+			return report, err
+		}
+		report = append(report, revenue)
+	}
+
+	return report, rows.Err()
+}
+
+// revenueGroups are the columns of the groupings of a revenue report.
+var revenueGroups = map[string]string{
+	model.GroupByService: "s.name",
+	model.GroupByUser:    "t.user_id",
+	model.GroupByDay:     "to_char(t.closed_date, 'YYYY-MM-DD')",
+	model.GroupByWeek:    "to_char(date_trunc('week', t.closed_date), 'YYYY-MM-DD')",
+	model.GroupByMonth:   "to_char(date_trunc('month', t.closed_date), 'YYYY-MM-DD')",
+}
+
+// GetRevenueReport returns the revenue per currency and per the groupings
+// of the query, with refunds subtracted, ordered by the groupings and
+// currency.
+func (r *TransactionRepository) GetRevenueReport(ctx context.Context, query model.ReportQuery) ([]model.Revenue, error) {
+	report := []model.Revenue{}
+	columns := []string{}
+	for _, grouping := range query.GroupBy {
+		column, ok := revenueGroups[grouping]
+		if !ok {
+			return report, fmt.Errorf("unknown report grouping %q", grouping)
+		}
+		columns = append(columns, column)
+	}
+	groups := strings.Join(append(columns, "t.currency"), ", ")
+	rows, err := r.store.db.QueryContext(ctx, fmt.Sprintf(
+		`select %s, sum(case when t.type = 'refund' then -t.amount else t.amount end) amount
+				from transactions t
+				join servicies s
+					on t.service_id = s.id
+				where t.success_flg = true
+					and t.type in ('reserve', 'charge', 'refund')
+					and t.closed_date >= $1::date
+					and t.closed_date < $2::date
+				group by %s
+				order by %s`, groups, groups, groups),
+		query.From.Format("2006-01-02"),
+		query.To.Format("2006-01-02"))
+	if err != nil {
+		return report, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		revenue := model.Revenue{}
+		dest := []interface{}{}
+		for _, grouping := range query.GroupBy {
+			switch grouping {
+			case model.GroupByService:
+				dest = append(dest, &revenue.Service)
+			case model.GroupByUser:
+				dest = append(dest, &revenue.User_id)
+			default:
+				dest = append(dest, &revenue.Period)
+			}
+		}
+		if err := rows.Scan(append(dest, &revenue.Currency, &revenue.Amount)...); err != nil {
 			return report, err
 		}
-		report[service] = amount
+		report = append(report, revenue)
 	}
 
-	return report, err
+	return report, rows.Err()
 }
 
-func (r *TransactionRepository) GetAccountReport(userId int, orderCol, orderDir string, page, pageSize int) (*[]model.AccountTransaction, error) {
+// accountReportColumns are the columns the history can be sorted by.
+var accountReportColumns = map[string]string{
+	"amount":      "t.amount",
+	"closed_date": "t.closed_date",
+}
+
+// GetAccountReport returns a page of the user's history, only in the given
+// currency unless it is empty. The rows are sorted by orderCol in orderDir,
+// ASC or DESC, then by id, so that pages neither repeat nor skip rows.
+func (r *TransactionRepository) GetAccountReport(ctx context.Context, userId int, currency, orderCol, orderDir string, page, pageSize int) (*[]model.AccountTransaction, error) {
+	return r.accountReport(ctx, userId, currency, orderCol, orderDir, nil, (page-1)*pageSize, pageSize)
+}
+
+// GetAccountReportAfter returns up to limit records of the user's history
+// that follow after in the order of GetAccountReport. Unlike the pages, the
+// records don't shift when transactions are added meanwhile.
+func (r *TransactionRepository) GetAccountReportAfter(ctx context.Context, userId int, currency, orderCol, orderDir string, after *model.AccountTransaction, limit int) (*[]model.AccountTransaction, error) {
+	return r.accountReport(ctx, userId, currency, orderCol, orderDir, after, 0, limit)
+}
+
+func (r *TransactionRepository) accountReport(ctx context.Context, userId int, currency, orderCol, orderDir string, after *model.AccountTransaction, offset, limit int) (*[]model.AccountTransaction, error) {
 	report := []model.AccountTransaction{}
-	query_str := fmt.Sprintf(`select 	amount, 
+	column, ok := accountReportColumns[orderCol]
+	if !ok {
+		return &report, fmt.Errorf("unknown sort column %q", orderCol)
+	}
+	if orderDir != "DESC" {
+		orderDir = "ASC"
+	}
+	args := []interface{}{userId, offset, limit, currency}
+	keyset := ""
+	if after != nil {
+		op := ">"
+		if orderDir == "DESC" {
+			op = "<"
+		}
+		var value interface{} = after.Amount
+		if orderCol == "closed_date" {
+			value = after.Closed_date
+		}
+		keyset = fmt.Sprintf("and (%s, t.id) %s ($5, $6)", column, op)
+		args = append(args, value, after.Id)
+	}
+	query_str := fmt.Sprintf(`select 	t.id,
+						amount, 
+						currency, 
 						description, 
 						coalesce(order_id, 0) order_id, 
 						coalesce(s.name, 'n/d') service,
-						closed_date 
+						closed_date,
+						c.id,
+						c.from_currency,
+						c.from_amount,
+						c.to_currency,
+						c.to_amount,
+						c.rate,
+						c.spread
 				from transactions t
 				left join servicies s
 				on t.service_id = s.id
+				left join conversions c
+				on t.conversion_id = c.id
 				where success_flg=true
 				and user_id = $1
-				order by $2 %s
-				offset $3 rows
-				fetch next $4 rows only`, orderDir)
-	rows, err := r.store.db.Query(query_str,
-		userId,
-		orderCol,
-		(page-1)*pageSize,
-		pageSize)
+				and ($4::text = '' or currency = $4::text)
+				%[3]s
+				order by %[1]s %[2]s, t.id %[2]s
+				offset $2 rows
+				fetch next $3 rows only`, column, orderDir, keyset)
+	rows, err := r.store.db.QueryContext(ctx, query_str, args...)
 	if err != nil {
 		return &report, err
 	}
 	defer rows.Close()
 	for rows.Next() {
 		record := model.AccountTransaction{}
-		if err := rows.Scan(&record.Amount, &record.Description, &record.Order_id, &record.Service, &record.Closed_date); err != nil {
+		var (
+			conversionId             sql.NullInt64
+			fromCurrency, toCurrency sql.NullString
+			fromAmount, toAmount     sql.NullInt64
+			rate, spread             sql.NullFloat64
+		)
+		if err := rows.Scan(&record.Id, &record.Amount, &record.Currency, &record.Description, &record.Order_id, &record.Service, &record.Closed_date,
+			&conversionId, &fromCurrency, &fromAmount, &toCurrency, &toAmount, &rate, &spread); err != nil {
 			return &report, err
 		}
+		if conversionId.Valid {
+			record.Conversion = &model.Conversion{
+				Id:            int(conversionId.Int64),
+				From_currency: fromCurrency.String,
+				From_amount:   model.Amount(fromAmount.Int64),
+				To_currency:   toCurrency.String,
+				To_amount:     model.Amount(toAmount.Int64),
+				Rate:          rate.Float64,
+				Spread:        spread.Float64,
+			}
+		}
 		report = append(report, record)
 	}
 
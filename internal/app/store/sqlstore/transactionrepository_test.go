@@ -0,0 +1,69 @@
+package sqlstore_test
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"strconv"
+	"testing"
+	"time"
+	"user_balance_microservice/internal/app/model"
+	"user_balance_microservice/internal/app/store/sqlstore"
+)
+
+func TestTransactionRepository_GetAccountReport(t *testing.T) {
+	ctx := context.Background()
+	db, teardown := sqlstore.TestDB(t, databaseURL)
+	defer teardown("transactions", "user_accounts")
+
+	s := sqlstore.New(db)
+	assert.NoError(t, s.UserAccount().Create(ctx, &model.UserAccount{User_id: 1, Currency: "RUB", Balance: 500}))
+	closed := time.Now()
+	for i := 1; i <= 5; i++ {
+		assert.NoError(t, s.Transaction().CreateAddTransaction(ctx, &model.Transaction{
+			User_id:     1,
+			Amount:      100,
+			Currency:    "RUB",
+			Description: strconv.Itoa(i),
+			Closed_date: closed,
+			Success_flg: true,
+			Type:        "add",
+		}))
+	}
+
+	// The rows tie on both columns, so only the order by id keeps the
+	// pages apart.
+	for _, tc := range []struct {
+		orderCol, orderDir string
+		expected           []string
+	}{
+		{"amount", "ASC", []string{"1", "2", "3", "4", "5"}},
+		{"closed_date", "DESC", []string{"5", "4", "3", "2", "1"}},
+	} {
+		descriptions := []string{}
+		for page := 1; page <= 3; page++ {
+			report, err := s.Transaction().GetAccountReport(ctx, 1, "", tc.orderCol, tc.orderDir, page, 2)
+			assert.NoError(t, err)
+			for _, record := range *report {
+				descriptions = append(descriptions, record.Description)
+			}
+		}
+		assert.Equal(t, tc.expected, descriptions, tc.orderCol)
+	}
+
+	_, err := s.Transaction().GetAccountReport(ctx, 1, "", "description", "ASC", 1, 2)
+	assert.Error(t, err)
+
+	// A record added before the cursor doesn't shift the next batch.
+	first, err := s.Transaction().GetAccountReportAfter(ctx, 1, "", "closed_date", "DESC", nil, 2)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Transaction().CreateAddTransaction(ctx, &model.Transaction{
+		User_id: 1, Amount: 100, Currency: "RUB", Description: "6", Closed_date: closed.Add(time.Hour), Success_flg: true, Type: "add",
+	}))
+	next, err := s.Transaction().GetAccountReportAfter(ctx, 1, "", "closed_date", "DESC", &(*first)[1], 2)
+	assert.NoError(t, err)
+	descriptions := []string{}
+	for _, record := range append(*first, *next...) {
+		descriptions = append(descriptions, record.Description)
+	}
+	assert.Equal(t, []string{"5", "4", "3", "2"}, descriptions)
+}
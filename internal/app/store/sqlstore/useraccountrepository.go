@@ -1,28 +1,32 @@
 package sqlstore
 
 import (
+	"context"
 	"database/sql"
+	"sort"
 	"user_balance_microservice/internal/app/model"
 )
 
 type UserAccountRepository struct {
-	store *Store
+	store *txStore
 }
 
-func (r *UserAccountRepository) Create(tx *sql.Tx, account *model.UserAccount) error {
-	return tx.QueryRow(
-		"INSERT INTO user_accounts (user_id, balance, reserved_balance) VALUES ($1, $2, $3) RETURNING user_id",
+func (r *UserAccountRepository) Create(ctx context.Context, account *model.UserAccount) error {
+	return r.store.db.QueryRowContext(ctx,
+		"INSERT INTO user_accounts (user_id, currency, balance, reserved_balance) VALUES ($1, $2, $3, $4) RETURNING user_id",
 		account.User_id,
+		account.Currency,
 		account.Balance,
 		0,
 	).Scan(&account.User_id)
 }
 
-func (r *UserAccountRepository) Add(tx *sql.Tx, account *model.UserAccount) (*model.UserAccount, error) {
-	if err := tx.QueryRow(
-		"UPDATE user_accounts SET balance = balance + $1 where user_id = $2 RETURNING user_id, balance",
+func (r *UserAccountRepository) Add(ctx context.Context, account *model.UserAccount) (*model.UserAccount, error) {
+	if err := r.store.db.QueryRowContext(ctx,
+		"UPDATE user_accounts SET balance = balance + $1 where user_id = $2 and currency = $3 RETURNING user_id, balance",
 		account.Balance,
 		account.User_id,
+		account.Currency,
 	).Scan(
 		&account.User_id,
 		&account.Balance,
@@ -32,30 +36,35 @@ func (r *UserAccountRepository) Add(tx *sql.Tx, account *model.UserAccount) (*mo
 	return account, nil
 }
 
-func (r *UserAccountRepository) Transfer(tx *sql.Tx, idFrom, idTo, amount int) (*model.UserAccount, error) {
+// Transfer takes debit from one wallet and gives credit to the other, which
+// differ only when the wallets are in different currencies.
+func (r *UserAccountRepository) Transfer(ctx context.Context, from, to model.Wallet, debit, credit model.Amount) (*model.UserAccount, error) {
 	account := &model.UserAccount{}
-	if _, err := tx.Exec(
-		"UPDATE user_accounts SET balance = balance - $1 where user_id = $2",
-		amount,
-		idFrom,
+	if _, err := r.store.db.ExecContext(ctx,
+		"UPDATE user_accounts SET balance = balance - $1 where user_id = $2 and currency = $3",
+		debit,
+		from.User_id,
+		from.Currency,
 	); err != nil {
 		return nil, err
 	}
-	if _, err := tx.Exec(
-		"UPDATE user_accounts SET balance = balance + $1 where user_id = $2 ",
-		amount,
-		idTo,
+	if _, err := r.store.db.ExecContext(ctx,
+		"UPDATE user_accounts SET balance = balance + $1 where user_id = $2 and currency = $3",
+		credit,
+		to.User_id,
+		to.Currency,
 	); err != nil {
 		return nil, err
 	}
 	return account, nil
 }
 
-func (r *UserAccountRepository) Reserve(tx *sql.Tx, account *model.UserAccount) (*model.UserAccount, error) {
-	if err := tx.QueryRow(
-		"UPDATE user_accounts SET balance = balance - $1, reserved_balance = reserved_balance + $1 where user_id = $2 RETURNING user_id, balance",
+func (r *UserAccountRepository) Reserve(ctx context.Context, account *model.UserAccount) (*model.UserAccount, error) {
+	if err := r.store.db.QueryRowContext(ctx,
+		"UPDATE user_accounts SET balance = balance - $1, reserved_balance = reserved_balance + $1 where user_id = $2 and currency = $3 RETURNING user_id, balance",
 		account.Balance,
 		account.User_id,
+		account.Currency,
 	).Scan(
 		&account.User_id,
 		&account.Balance,
@@ -65,11 +74,12 @@ func (r *UserAccountRepository) Reserve(tx *sql.Tx, account *model.UserAccount)
 	return account, nil
 }
 
-func (r *UserAccountRepository) ConfirmReserve(tx *sql.Tx, account *model.UserAccount) (*model.UserAccount, error) {
-	if err := tx.QueryRow(
-		"UPDATE user_accounts SET reserved_balance = reserved_balance - $1 where user_id = $2 RETURNING user_id, balance",
+func (r *UserAccountRepository) ConfirmReserve(ctx context.Context, account *model.UserAccount) (*model.UserAccount, error) {
+	if err := r.store.db.QueryRowContext(ctx,
+		"UPDATE user_accounts SET reserved_balance = reserved_balance - $1 where user_id = $2 and currency = $3 RETURNING user_id, balance",
 		account.Balance,
 		account.User_id,
+		account.Currency,
 	).Scan(
 		&account.User_id,
 		&account.Balance,
@@ -79,11 +89,12 @@ func (r *UserAccountRepository) ConfirmReserve(tx *sql.Tx, account *model.UserAc
 	return account, nil
 }
 
-func (r *UserAccountRepository) AbortReserve(tx *sql.Tx, account *model.UserAccount) (*model.UserAccount, error) {
-	if err := tx.QueryRow(
-		"UPDATE user_accounts SET balance = balance + $1, reserved_balance = reserved_balance - $1 where user_id = $2 RETURNING user_id, balance",
+func (r *UserAccountRepository) AbortReserve(ctx context.Context, account *model.UserAccount) (*model.UserAccount, error) {
+	if err := r.store.db.QueryRowContext(ctx,
+		"UPDATE user_accounts SET balance = balance + $1, reserved_balance = reserved_balance - $1 where user_id = $2 and currency = $3 RETURNING user_id, balance",
 		account.Balance,
 		account.User_id,
+		account.Currency,
 	).Scan(
 		&account.User_id,
 		&account.Balance,
@@ -93,16 +104,91 @@ func (r *UserAccountRepository) AbortReserve(tx *sql.Tx, account *model.UserAcco
 	return account, nil
 }
 
-func (r *UserAccountRepository) FindById(id int) (*model.UserAccount, error) {
+func (r *UserAccountRepository) FindById(ctx context.Context, id int, currency string) (*model.UserAccount, error) {
 	account := &model.UserAccount{}
-	if err := r.store.db.QueryRow(
-		"SELECT user_id, balance from user_accounts where user_id=$1",
+	if err := r.store.db.QueryRowContext(ctx,
+		"SELECT user_id, currency, balance, reserved_balance from user_accounts where user_id=$1 and currency=$2",
 		id,
+		currency,
 	).Scan(
 		&account.User_id,
+		&account.Currency,
 		&account.Balance,
+		&account.Reserved_balance,
 	); err != nil {
 		return nil, err
 	}
 	return account, nil
 }
+
+// FindByUser returns all wallets of the user ordered by currency.
+func (r *UserAccountRepository) FindByUser(ctx context.Context, id int) ([]model.UserAccount, error) {
+	accounts := []model.UserAccount{}
+	rows, err := r.store.db.QueryContext(ctx,
+		"SELECT user_id, currency, balance, reserved_balance from user_accounts where user_id=$1 order by currency",
+		id,
+	)
+	if err != nil {
+		return accounts, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		account := model.UserAccount{}
+		if err := rows.Scan(
+			&account.User_id,
+			&account.Currency,
+			&account.Balance,
+			&account.Reserved_balance,
+		); err != nil {
+			return accounts, err
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts, rows.Err()
+}
+
+// FindForUpdate locks the given wallets until the end of the transaction.
+// Rows are locked one by one in ascending (user id, currency) order, so two
+// transactions locking the same wallets can't deadlock. Wallets that don't
+// exist are missing from the result.
+func (r *UserAccountRepository) FindForUpdate(ctx context.Context, wallets ...model.Wallet) (map[model.Wallet]*model.UserAccount, error) {
+	accounts := make(map[model.Wallet]*model.UserAccount, len(wallets))
+	for _, wallet := range sortedWallets(wallets) {
+		account := &model.UserAccount{}
+		if err := r.store.db.QueryRowContext(ctx,
+			"SELECT user_id, currency, balance, reserved_balance from user_accounts where user_id=$1 and currency=$2 FOR UPDATE",
+			wallet.User_id,
+			wallet.Currency,
+		).Scan(
+			&account.User_id,
+			&account.Currency,
+			&account.Balance,
+			&account.Reserved_balance,
+		); err != nil {
+			if err == sql.ErrNoRows {
+				continue
+			}
+			return nil, err
+		}
+		accounts[wallet] = account
+	}
+	return accounts, nil
+}
+
+func sortedWallets(wallets []model.Wallet) []model.Wallet {
+	sorted := make([]model.Wallet, 0, len(wallets))
+	seen := make(map[model.Wallet]bool, len(wallets))
+	for _, wallet := range wallets {
+		if !seen[wallet] {
+			seen[wallet] = true
+			sorted = append(sorted, wallet)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].User_id != sorted[j].User_id {
+			return sorted[i].User_id < sorted[j].User_id
+		}
+		return sorted[i].Currency < sorted[j].Currency
+	})
+	return sorted
+}
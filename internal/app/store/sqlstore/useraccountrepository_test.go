@@ -0,0 +1,74 @@
+package sqlstore_test
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"user_balance_microservice/internal/app/model"
+	"user_balance_microservice/internal/app/store"
+	"user_balance_microservice/internal/app/store/sqlstore"
+)
+
+func TestUserAccountRepository_FindForUpdate(t *testing.T) {
+	ctx := context.Background()
+	db, teardown := sqlstore.TestDB(t, databaseURL)
+	defer teardown("transactions", "user_accounts")
+
+	s := sqlstore.New(db)
+	assert.NoError(t, s.UserAccount().Create(ctx, &model.UserAccount{User_id: 1, Currency: "RUB", Balance: 1000}))
+	assert.NoError(t, s.UserAccount().Create(ctx, &model.UserAccount{User_id: 2, Currency: "RUB", Balance: 1000}))
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		reserved model.Amount
+	)
+	for i := 0; i < 200; i++ {
+		from := model.Wallet{User_id: 1 + i%2, Currency: "RUB"}
+		to := model.Wallet{User_id: 2 - i%2, Currency: "RUB"}
+		reserve := i%3 == 0
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := s.WithinTx(context.Background(), func(tx store.TxStore) error {
+				accounts, err := tx.UserAccount().FindForUpdate(ctx, from, to)
+				if err != nil {
+					return err
+				}
+				if accounts[from].Balance < 30 {
+					return nil
+				}
+				if reserve {
+					_, err = tx.UserAccount().Reserve(ctx, &model.UserAccount{User_id: from.User_id, Currency: from.Currency, Balance: 30})
+					if err == nil {
+						mu.Lock()
+						reserved += 30
+						mu.Unlock()
+					}
+					return err
+				}
+				_, err = tx.UserAccount().Transfer(ctx, from, to, 30, 30)
+				return err
+			})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	accounts, err := s.UserAccount().FindForUpdate(ctx,
+		model.Wallet{User_id: 1, Currency: "RUB"},
+		model.Wallet{User_id: 2, Currency: "RUB"},
+	)
+	assert.NoError(t, err)
+	var total, totalReserved model.Amount
+	for _, account := range accounts {
+		assert.GreaterOrEqual(t, account.Balance, model.Amount(0))
+		total += account.Balance + account.Reserved_balance
+		totalReserved += account.Reserved_balance
+	}
+	assert.Equal(t, model.Amount(2000), total)
+	assert.Equal(t, reserved, totalReserved)
+}
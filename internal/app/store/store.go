@@ -1,9 +1,19 @@
 package store
 
-import "database/sql"
+import "context"
 
 type Store interface {
+	TxStore
+	WithinTx(context.Context, func(TxStore) error) error
+}
+
+// TxStore gives access to repositories bound to a single transaction.
+type TxStore interface {
 	UserAccount() UserAccountRepository
 	Transaction() TransactionRepository
-	BeginTx() (*sql.Tx, error)
+	IdempotencyKey() IdempotencyKeyRepository
+	Ledger() LedgerRepository
+	Reconciliation() ReconciliationRepository
+	Conversion() ConversionRepository
+	ReportJob() ReportJobRepository
 }
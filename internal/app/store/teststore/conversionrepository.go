@@ -0,0 +1,27 @@
+package teststore
+
+import (
+	"context"
+	"time"
+	"user_balance_microservice/internal/app/model"
+)
+
+type ConversionRepository struct {
+	store *txStore
+}
+
+func (r *ConversionRepository) Create(ctx context.Context, conversion *model.Conversion) error {
+	record := *conversion
+	record.Id = r.store.nextId()
+	record.Created_at = time.Now()
+	if _, err := r.store.execContext(ctx, func(d *data) error {
+		d.conversions[record.Id] = record
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	conversion.Id = record.Id
+	conversion.Created_at = record.Created_at
+	return nil
+}
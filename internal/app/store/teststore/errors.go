@@ -0,0 +1,25 @@
+package teststore
+
+import "github.com/lib/pq"
+
+// The errors follow the ones Postgres returns for the constraints in the
+// schema migrations, so handlers see the same kind of errors as with sqlstore.
+var (
+	errBalanceCheck               = constraintError("23514", "user_accounts", "user_accounts_balance_check", `new row for relation "user_accounts" violates check constraint "user_accounts_balance_check"`)
+	errReservedCheck              = constraintError("23514", "user_accounts", "user_accounts_reserved_balance_check", `new row for relation "user_accounts" violates check constraint "user_accounts_reserved_balance_check"`)
+	errAccountExists              = constraintError("23505", "user_accounts", "user_accounts_pkey", `duplicate key value violates unique constraint "user_accounts_pkey"`)
+	errReserveExists              = constraintError("23505", "transactions", "transactions_reserve_key", `duplicate key value violates unique constraint "transactions_reserve_key"`)
+	errTypeCheck                  = constraintError("23514", "transactions", "transactions_type_check", `new row for relation "transactions" violates check constraint "transactions_type_check"`)
+	errAccountForeignKey          = constraintError("23503", "transactions", "transactions_account_fkey", `insert or update on table "transactions" violates foreign key constraint "transactions_account_fkey"`)
+	errServiceForeignKey          = constraintError("23503", "transactions", "transactions_service_id_fkey", `insert or update on table "transactions" violates foreign key constraint "transactions_service_id_fkey"`)
+	errParentForeignKey           = constraintError("23503", "transactions", "transactions_parent_id_fkey", `insert or update on table "transactions" violates foreign key constraint "transactions_parent_id_fkey"`)
+	errConversionForeignKey       = constraintError("23503", "transactions", "transactions_conversion_id_fkey", `insert or update on table "transactions" violates foreign key constraint "transactions_conversion_id_fkey"`)
+	errEntryTransactionForeignKey = constraintError("23503", "journal_entries", "journal_entries_transaction_id_fkey", `insert or update on table "journal_entries" violates foreign key constraint "journal_entries_transaction_id_fkey"`)
+	errNegativeOffset             = &pq.Error{Severity: "ERROR", Code: "2201X", Message: "OFFSET must not be negative"}
+	errBigintOutOfRange           = &pq.Error{Severity: "ERROR", Code: "22003", Message: "bigint out of range"}
+	errNegativeFetchFirst         = &pq.Error{Severity: "ERROR", Code: "2201W", Message: "FETCH FIRST must not be negative"}
+)
+
+func constraintError(code pq.ErrorCode, table, constraint, message string) *pq.Error {
+	return &pq.Error{Severity: "ERROR", Code: code, Message: message, Table: table, Constraint: constraint}
+}
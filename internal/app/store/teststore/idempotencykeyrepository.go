@@ -0,0 +1,110 @@
+package teststore
+
+import (
+	"context"
+	"database/sql"
+	"time"
+	"user_balance_microservice/internal/app/model"
+	"user_balance_microservice/internal/app/store"
+)
+
+type IdempotencyKeyRepository struct {
+	store *txStore
+}
+
+func (r *IdempotencyKeyRepository) Create(ctx context.Context, key *model.IdempotencyKey) error {
+	record := model.IdempotencyKey{
+		Key:          key.Key,
+		Request_hash: key.Request_hash,
+		Owner:        key.Owner,
+		Created_at:   time.Now(),
+	}
+	if _, err := r.store.execContext(ctx, func(d *data) error {
+		if _, ok := d.idempotencyKeys[record.Key]; ok {
+			return store.RecordExists
+		}
+		d.idempotencyKeys[record.Key] = record
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	key.Created_at = record.Created_at
+	return nil
+}
+
+func (r *IdempotencyKeyRepository) TakeOver(ctx context.Context, key *model.IdempotencyKey, expired, abandoned time.Time) error {
+	record := model.IdempotencyKey{
+		Key:          key.Key,
+		Request_hash: key.Request_hash,
+		Owner:        key.Owner,
+		Created_at:   time.Now(),
+	}
+	if _, err := r.store.execContext(ctx, func(d *data) error {
+		stored, ok := d.idempotencyKeys[record.Key]
+		if !ok || !stored.Created_at.Before(expired) &&
+			(stored.Status_code != 0 || stored.Request_hash != record.Request_hash || !stored.Created_at.Before(abandoned)) {
+			return store.RecordExists
+		}
+		d.idempotencyKeys[record.Key] = record
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	key.Created_at = record.Created_at
+	return nil
+}
+
+func (r *IdempotencyKeyRepository) FindByKey(ctx context.Context, key string) (*model.IdempotencyKey, error) {
+	d, err := r.store.snapshotContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	record, ok := d.idempotencyKeys[key]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &record, nil
+}
+
+func (r *IdempotencyKeyRepository) SaveResponse(ctx context.Context, key *model.IdempotencyKey) error {
+	name, owner, status, response := key.Key, key.Owner, key.Status_code, key.Response
+	_, err := r.store.execContext(ctx, func(d *data) error {
+		record, ok := d.idempotencyKeys[name]
+		if !ok || record.Owner != owner || record.Status_code != 0 {
+			return store.RecordNotFound
+		}
+		record.Status_code = status
+		record.Response = response
+		d.idempotencyKeys[name] = record
+		return nil
+	})
+	return err
+}
+
+func (r *IdempotencyKeyRepository) Release(ctx context.Context, key *model.IdempotencyKey) error {
+	name, owner := key.Key, key.Owner
+	_, err := r.store.execContext(ctx, func(d *data) error {
+		if record, ok := d.idempotencyKeys[name]; ok && record.Owner == owner && record.Status_code == 0 {
+			delete(d.idempotencyKeys, name)
+		}
+		return nil
+	})
+	return err
+}
+
+func (r *IdempotencyKeyRepository) DeleteExpired(ctx context.Context, before time.Time) (int, error) {
+	deleted := 0
+	_, err := r.store.execContext(ctx, func(d *data) error {
+		deleted = 0
+		for key, record := range d.idempotencyKeys {
+			if record.Created_at.Before(before) {
+				delete(d.idempotencyKeys, key)
+				deleted++
+			}
+		}
+		return nil
+	})
+	return deleted, err
+}
@@ -0,0 +1,55 @@
+package teststore
+
+import (
+	"context"
+	"time"
+	"user_balance_microservice/internal/app/model"
+	"user_balance_microservice/internal/app/store"
+)
+
+type LedgerRepository struct {
+	store *txStore
+}
+
+func (r *LedgerRepository) Post(ctx context.Context, entry *model.JournalEntry) error {
+	if err := store.CheckEntry(entry); err != nil {
+		return err
+	}
+
+	record := model.JournalEntry{
+		Id:             r.store.nextId(),
+		Transaction_id: entry.Transaction_id,
+		Description:    entry.Description,
+		Created_at:     time.Now(),
+		Postings:       append([]model.Posting(nil), entry.Postings...),
+	}
+	if _, err := r.store.execContext(ctx, func(d *data) error {
+		if _, ok := d.transactions[record.Transaction_id]; record.Transaction_id != 0 && !ok {
+			return errEntryTransactionForeignKey
+		}
+		d.journalEntries[record.Id] = record
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	entry.Id = record.Id
+	entry.Created_at = record.Created_at
+	return nil
+}
+
+func (r *LedgerRepository) Balance(ctx context.Context, account string) (model.Amount, error) {
+	d, err := r.store.snapshotContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+	balance := model.Amount(0)
+	for _, entry := range d.journalEntries {
+		for _, posting := range entry.Postings {
+			if posting.Account == account {
+				balance += posting.Amount
+			}
+		}
+	}
+	return balance, nil
+}
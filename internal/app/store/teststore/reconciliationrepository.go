@@ -0,0 +1,109 @@
+package teststore
+
+import (
+	"sort"
+	"user_balance_microservice/internal/app/model"
+)
+
+type ReconciliationRepository struct {
+	store *txStore
+}
+
+func (r *ReconciliationRepository) FindDiscrepancies() ([]model.Discrepancy, error) {
+	discrepancies := []model.Discrepancy{}
+	d, err := r.store.snapshot()
+	if err != nil {
+		return discrepancies, err
+	}
+
+	expected := make(map[model.Wallet]*model.Discrepancy, len(d.accounts))
+	for wallet, account := range d.accounts {
+		expected[wallet] = &model.Discrepancy{
+			User_id:          wallet.User_id,
+			Currency:         wallet.Currency,
+			Balance:          account.Balance,
+			Reserved_balance: account.Reserved_balance,
+		}
+	}
+	for _, row := range d.transactions {
+		discrepancy, ok := expected[model.Wallet{User_id: row.User_id, Currency: row.Currency}]
+		if !ok {
+			continue
+		}
+		switch {
+		case row.Type == "add" || row.Type == "transfer_in" || row.Type == "refund":
+			discrepancy.Expected_balance += row.Amount
+		case row.Type == "transfer_out" || row.Type == "charge":
+			discrepancy.Expected_balance -= row.Amount
+		case row.Type == "reserve" && row.Closed_date.IsZero():
+			discrepancy.Expected_balance -= row.Amount
+			discrepancy.Expected_reserved += row.Amount
+		case row.Type == "reserve" && row.Success_flg:
+			discrepancy.Expected_balance -= row.Amount
+		}
+	}
+
+	return differing(expected), nil
+}
+
+func (r *ReconciliationRepository) FindLedgerDiscrepancies() ([]model.Discrepancy, error) {
+	d, err := r.store.snapshot()
+	if err != nil {
+		return []model.Discrepancy{}, err
+	}
+
+	balances := map[string]model.Amount{}
+	for _, entry := range d.journalEntries {
+		for _, posting := range entry.Postings {
+			balances[posting.Account] += posting.Amount
+		}
+	}
+	expected := make(map[model.Wallet]*model.Discrepancy, len(d.accounts))
+	for wallet, account := range d.accounts {
+		expected[wallet] = &model.Discrepancy{
+			User_id:           wallet.User_id,
+			Currency:          wallet.Currency,
+			Balance:           account.Balance,
+			Expected_balance:  balances[model.UserLedgerAccount(wallet)],
+			Reserved_balance:  account.Reserved_balance,
+			Expected_reserved: balances[model.ReservedLedgerAccount(wallet)],
+		}
+	}
+	return differing(expected), nil
+}
+
+// differing returns the accounts whose balances differ from the expected
+// ones, sorted like sqlstore sorts them.
+func differing(expected map[model.Wallet]*model.Discrepancy) []model.Discrepancy {
+	discrepancies := []model.Discrepancy{}
+	for _, discrepancy := range expected {
+		if discrepancy.Balance != discrepancy.Expected_balance ||
+			discrepancy.Reserved_balance != discrepancy.Expected_reserved {
+			discrepancies = append(discrepancies, *discrepancy)
+		}
+	}
+	sort.Slice(discrepancies, func(i, j int) bool {
+		if discrepancies[i].User_id != discrepancies[j].User_id {
+			return discrepancies[i].User_id < discrepancies[j].User_id
+		}
+		return discrepancies[i].Currency < discrepancies[j].Currency
+	})
+	return discrepancies
+}
+
+func (r *ReconciliationRepository) SaveDiscrepancies(discrepancies []model.Discrepancy) error {
+	_, err := r.store.exec(func(d *data) error {
+		d.discrepancies = append(d.discrepancies, discrepancies...)
+		return nil
+	})
+	return err
+}
+
+// Discrepancies returns the saved discrepancies, which sqlstore keeps only in
+// the database.
+func (s *Store) Discrepancies() []model.Discrepancy {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]model.Discrepancy(nil), s.data.discrepancies...)
+}
@@ -0,0 +1,101 @@
+package teststore
+
+import (
+	"context"
+	"database/sql"
+	"time"
+	"user_balance_microservice/internal/app/model"
+)
+
+type ReportJobRepository struct {
+	store *txStore
+}
+
+func (r *ReportJobRepository) Create(ctx context.Context, job *model.ReportJob) error {
+	record := model.ReportJob{
+		Id:         r.store.nextId(),
+		Status:     model.ReportPending,
+		Query:      job.Query,
+		Format:     job.Format,
+		Created_at: time.Now(),
+	}
+	record.Query.GroupBy = append([]string(nil), job.Query.GroupBy...)
+	if _, err := r.store.execContext(ctx, func(d *data) error {
+		d.reportJobs[record.Id] = record
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	job.Id = record.Id
+	job.Status = record.Status
+	job.Created_at = record.Created_at
+	return nil
+}
+
+func (r *ReportJobRepository) Find(ctx context.Context, id int) (*model.ReportJob, error) {
+	d, err := r.store.snapshotContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	job, ok := d.reportJobs[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &job, nil
+}
+
+func (r *ReportJobRepository) Claim(ctx context.Context, staleBefore time.Time) (*model.ReportJob, error) {
+	var claimed *model.ReportJob
+	if _, err := r.store.execContext(ctx, func(d *data) error {
+		// The operation runs again on commit, so the job is picked anew.
+		claimed = nil
+		for _, job := range d.reportJobs {
+			job := job
+			stale := job.Status == model.ReportRunning && job.Started_at.Before(staleBefore)
+			if job.Status != model.ReportPending && !stale {
+				continue
+			}
+			if claimed == nil || job.Created_at.Before(claimed.Created_at) ||
+				job.Created_at.Equal(claimed.Created_at) && job.Id < claimed.Id {
+				claimed = &job
+			}
+		}
+		if claimed == nil {
+			return nil
+		}
+		now := time.Now()
+		claimed.Status = model.ReportRunning
+		claimed.Started_at = &now
+		d.reportJobs[claimed.Id] = *claimed
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if claimed == nil {
+		return nil, sql.ErrNoRows
+	}
+	return claimed, nil
+}
+
+func (r *ReportJobRepository) Finish(ctx context.Context, job *model.ReportJob) error {
+	id, status, file, reason := job.Id, job.Status, job.File, job.Error
+	now := time.Now()
+	if _, err := r.store.execContext(ctx, func(d *data) error {
+		record, ok := d.reportJobs[id]
+		if !ok {
+			return sql.ErrNoRows
+		}
+		record.Status = status
+		record.File = file
+		record.Error = reason
+		record.Finished_at = &now
+		d.reportJobs[id] = record
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	job.Finished_at = &now
+	return nil
+}
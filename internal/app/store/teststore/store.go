@@ -0,0 +1,380 @@
+package teststore
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"sync"
+	"user_balance_microservice/internal/app/model"
+	"user_balance_microservice/internal/app/store"
+)
+
+type Store struct {
+	mu                sync.Mutex
+	data              *data
+	locks             map[model.Wallet]*sync.Mutex
+	nextTransactionId int
+	txStore
+}
+
+func New() *Store {
+	s := &Store{
+		data: &data{
+			accounts:        make(map[model.Wallet]model.UserAccount),
+			transactions:    make(map[int]transactionRow),
+			idempotencyKeys: make(map[string]model.IdempotencyKey),
+			journalEntries:  make(map[int]model.JournalEntry),
+			conversions:     make(map[int]model.Conversion),
+			reportJobs:      make(map[int]model.ReportJob),
+			services: map[int]string{
+				1: "услуга 1",
+				2: "услуга 2",
+			},
+		},
+		locks:             make(map[model.Wallet]*sync.Mutex),
+		nextTransactionId: 1,
+	}
+	s.txStore = txStore{store: s}
+	s.txStore.init()
+	return s
+}
+
+// WithinTx runs fn in a transaction whose writes become visible to others
+// only when fn returns nil. On error or panic the writes are dropped.
+func (s *Store) WithinTx(ctx context.Context, fn func(store.TxStore) error) error {
+	t := &tx{store: s}
+	defer s.finish(t)
+
+	if err := fn(&txStore{store: s, tx: t}); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.commit(t)
+}
+
+// exec applies op to the committed data plus the writes already made in t
+// and records it on success. The returned view is a private copy and may be
+// read without holding the lock.
+func (s *Store) exec(t *tx, op func(*data) error) (*data, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if t.done {
+		return nil, sql.ErrTxDone
+	}
+
+	d, err := t.view()
+	if err != nil {
+		return nil, err
+	}
+	if err := op(d); err != nil {
+		return nil, err
+	}
+
+	t.ops = append(t.ops, op)
+	return d, nil
+}
+
+func (s *Store) commit(t *tx) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d, err := t.view()
+	if err != nil {
+		return err
+	}
+	s.data = d
+	return nil
+}
+
+func (s *Store) finish(t *tx) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t.done = true
+	for _, lock := range t.locks {
+		lock.Unlock()
+	}
+	t.locks = nil
+}
+
+// lock takes the row locks of the given wallets for t in ascending (user
+// id, currency) order, waiting for other transactions to release them.
+func (s *Store) lock(t *tx, wallets []model.Wallet) {
+	sort.Slice(wallets, func(i, j int) bool {
+		if wallets[i].User_id != wallets[j].User_id {
+			return wallets[i].User_id < wallets[j].User_id
+		}
+		return wallets[i].Currency < wallets[j].Currency
+	})
+	for _, wallet := range wallets {
+		s.mu.Lock()
+		if _, ok := t.locks[wallet]; ok {
+			s.mu.Unlock()
+			continue
+		}
+		lock, ok := s.locks[wallet]
+		if !ok {
+			lock = &sync.Mutex{}
+			s.locks[wallet] = lock
+		}
+		s.mu.Unlock()
+
+		lock.Lock()
+
+		s.mu.Lock()
+		if t.locks == nil {
+			t.locks = make(map[model.Wallet]*sync.Mutex)
+		}
+		t.locks[wallet] = lock
+		s.mu.Unlock()
+	}
+}
+
+// snapshot returns a copy of the data visible to t, or of the committed
+// data when t is nil.
+func (s *Store) snapshot(t *tx) (*data, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if t == nil {
+		return s.data.clone(), nil
+	}
+	if t.done {
+		return nil, sql.ErrTxDone
+	}
+	return t.view()
+}
+
+func (s *Store) nextId() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := s.nextTransactionId
+	s.nextTransactionId++
+	return id
+}
+
+type txStore struct {
+	store                    *Store
+	tx                       *tx
+	userAccountRepository    *UserAccountRepository
+	transactionRepository    *TransactionRepository
+	idempotencyKeyRepository *IdempotencyKeyRepository
+	ledgerRepository         *LedgerRepository
+	reconciliationRepository *ReconciliationRepository
+	conversionRepository     *ConversionRepository
+	reportJobRepository      *ReportJobRepository
+}
+
+func (s *txStore) UserAccount() store.UserAccountRepository {
+	if s.userAccountRepository != nil {
+		return s.userAccountRepository
+	}
+
+	s.userAccountRepository = &UserAccountRepository{
+		store: s,
+	}
+	return s.userAccountRepository
+}
+
+func (s *txStore) Transaction() store.TransactionRepository {
+	if s.transactionRepository != nil {
+		return s.transactionRepository
+	}
+
+	s.transactionRepository = &TransactionRepository{
+		store: s,
+	}
+	return s.transactionRepository
+}
+
+func (s *txStore) IdempotencyKey() store.IdempotencyKeyRepository {
+	if s.idempotencyKeyRepository != nil {
+		return s.idempotencyKeyRepository
+	}
+
+	s.idempotencyKeyRepository = &IdempotencyKeyRepository{
+		store: s,
+	}
+	return s.idempotencyKeyRepository
+}
+
+func (s *txStore) Ledger() store.LedgerRepository {
+	if s.ledgerRepository != nil {
+		return s.ledgerRepository
+	}
+
+	s.ledgerRepository = &LedgerRepository{
+		store: s,
+	}
+	return s.ledgerRepository
+}
+
+func (s *txStore) Reconciliation() store.ReconciliationRepository {
+	if s.reconciliationRepository != nil {
+		return s.reconciliationRepository
+	}
+
+	s.reconciliationRepository = &ReconciliationRepository{
+		store: s,
+	}
+	return s.reconciliationRepository
+}
+
+func (s *txStore) Conversion() store.ConversionRepository {
+	if s.conversionRepository != nil {
+		return s.conversionRepository
+	}
+
+	s.conversionRepository = &ConversionRepository{
+		store: s,
+	}
+	return s.conversionRepository
+}
+
+func (s *txStore) ReportJob() store.ReportJobRepository {
+	if s.reportJobRepository != nil {
+		return s.reportJobRepository
+	}
+
+	s.reportJobRepository = &ReportJobRepository{
+		store: s,
+	}
+	return s.reportJobRepository
+}
+
+// init makes every repository up front. The repositories of a Store are
+// shared by concurrent requests and workers, so they can't be made on first
+// use like the ones of a transaction.
+func (s *txStore) init() {
+	s.UserAccount()
+	s.Transaction()
+	s.IdempotencyKey()
+	s.Ledger()
+	s.Reconciliation()
+	s.Conversion()
+	s.ReportJob()
+}
+
+// exec runs op inside the bound transaction, or in its own one when the
+// repositories are used outside of WithinTx.
+func (s *txStore) exec(op func(*data) error) (*data, error) {
+	if s.tx != nil {
+		return s.store.exec(s.tx, op)
+	}
+
+	t := &tx{store: s.store}
+	d, err := s.store.exec(t, op)
+	if err != nil {
+		return nil, err
+	}
+	return d, s.store.commit(t)
+}
+
+// lock takes row locks that are held until the end of the bound
+// transaction. Outside of WithinTx it is a no-op, as in autocommit mode.
+func (s *txStore) lock(wallets ...model.Wallet) {
+	if s.tx != nil {
+		s.store.lock(s.tx, append([]model.Wallet(nil), wallets...))
+	}
+}
+
+func (s *txStore) snapshot() (*data, error) {
+	return s.store.snapshot(s.tx)
+}
+
+// execContext and snapshotContext are exec and snapshot that fail with the
+// error of ctx once it is done, as the queries of sqlstore do.
+func (s *txStore) execContext(ctx context.Context, op func(*data) error) (*data, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.exec(op)
+}
+
+func (s *txStore) snapshotContext(ctx context.Context) (*data, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.snapshot()
+}
+
+func (s *txStore) nextId() int {
+	return s.store.nextId()
+}
+
+// data holds the tables; services and the postings of journal entries are
+// never written and are shared between copies.
+type data struct {
+	accounts        map[model.Wallet]model.UserAccount
+	transactions    map[int]transactionRow
+	idempotencyKeys map[string]model.IdempotencyKey
+	journalEntries  map[int]model.JournalEntry
+	discrepancies   []model.Discrepancy
+	conversions     map[int]model.Conversion
+	reportJobs      map[int]model.ReportJob
+	services        map[int]string
+}
+
+// transactionRow is a stored transaction. keyed is false when order_id and
+// service_id are NULL, which keeps the row out of the unique key like in
+// Postgres.
+type transactionRow struct {
+	model.Transaction
+	keyed bool
+}
+
+func (d *data) clone() *data {
+	c := &data{
+		accounts:        make(map[model.Wallet]model.UserAccount, len(d.accounts)),
+		transactions:    make(map[int]transactionRow, len(d.transactions)),
+		idempotencyKeys: make(map[string]model.IdempotencyKey, len(d.idempotencyKeys)),
+		journalEntries:  make(map[int]model.JournalEntry, len(d.journalEntries)),
+		discrepancies:   append([]model.Discrepancy(nil), d.discrepancies...),
+		conversions:     make(map[int]model.Conversion, len(d.conversions)),
+		reportJobs:      make(map[int]model.ReportJob, len(d.reportJobs)),
+		services:        d.services,
+	}
+	for wallet, account := range d.accounts {
+		c.accounts[wallet] = account
+	}
+	for id, row := range d.transactions {
+		c.transactions[id] = row
+	}
+	for key, record := range d.idempotencyKeys {
+		c.idempotencyKeys[key] = record
+	}
+	for id, entry := range d.journalEntries {
+		c.journalEntries[id] = entry
+	}
+	for id, conversion := range d.conversions {
+		c.conversions[id] = conversion
+	}
+	for id, job := range d.reportJobs {
+		c.reportJobs[id] = job
+	}
+	return c
+}
+
+// tx buffers the writes of a transaction as operations that are replayed on
+// top of the latest committed data, so concurrent transactions never lose
+// each other's updates and constraints are checked again on commit.
+type tx struct {
+	store *Store
+	ops   []func(*data) error
+	locks map[model.Wallet]*sync.Mutex
+	done  bool
+}
+
+func (t *tx) view() (*data, error) {
+	d := t.store.data.clone()
+	for _, op := range t.ops {
+		if err := op(d); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
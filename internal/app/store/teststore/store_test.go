@@ -0,0 +1,450 @@
+package teststore_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+	"time"
+	"user_balance_microservice/internal/app/model"
+	"user_balance_microservice/internal/app/store"
+	"user_balance_microservice/internal/app/store/teststore"
+)
+
+func TestStore_WithinTx(t *testing.T) {
+	ctx := context.Background()
+	s := teststore.New()
+	errFailed := errors.New("failed")
+
+	err := s.WithinTx(context.Background(), func(tx store.TxStore) error {
+		assert.NoError(t, tx.UserAccount().Create(ctx, &model.UserAccount{User_id: 1, Currency: "RUB", Balance: 100}))
+
+		account, err := tx.UserAccount().FindById(ctx, 1, "RUB")
+		assert.NoError(t, err)
+		assert.Equal(t, model.Amount(100), account.Balance)
+
+		_, err = s.UserAccount().FindById(ctx, 1, "RUB")
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+		return errFailed
+	})
+	assert.ErrorIs(t, err, errFailed)
+
+	_, err = s.UserAccount().FindById(ctx, 1, "RUB")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	assert.Panics(t, func() {
+		s.WithinTx(context.Background(), func(tx store.TxStore) error {
+			tx.UserAccount().Create(ctx, &model.UserAccount{User_id: 1, Currency: "RUB", Balance: 100})
+			panic("failed")
+		})
+	})
+
+	_, err = s.UserAccount().FindById(ctx, 1, "RUB")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	var leaked store.TxStore
+	assert.NoError(t, s.WithinTx(context.Background(), func(tx store.TxStore) error {
+		leaked = tx
+		return tx.UserAccount().Create(ctx, &model.UserAccount{User_id: 1, Currency: "RUB", Balance: 100})
+	}))
+
+	account, err := s.UserAccount().FindById(ctx, 1, "RUB")
+	assert.NoError(t, err)
+	assert.Equal(t, model.Amount(100), account.Balance)
+	assert.ErrorIs(t, leaked.UserAccount().Create(ctx, &model.UserAccount{User_id: 2, Currency: "RUB"}), sql.ErrTxDone)
+}
+
+func TestStore_WithinTxCanceled(t *testing.T) {
+	s := teststore.New()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	err := s.WithinTx(ctx, func(tx store.TxStore) error {
+		cancel()
+		return tx.UserAccount().Create(ctx, &model.UserAccount{User_id: 1, Currency: "RUB", Balance: 100})
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+
+	_, err = s.UserAccount().FindById(context.Background(), 1, "RUB")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func TestUserAccountRepository_Create(t *testing.T) {
+	ctx := context.Background()
+	s := teststore.New()
+
+	assert.NoError(t, s.UserAccount().Create(ctx, &model.UserAccount{User_id: 1, Currency: "RUB", Balance: 100}))
+	assert.Error(t, s.UserAccount().Create(ctx, &model.UserAccount{User_id: 1, Currency: "RUB", Balance: 100}))
+	assert.Error(t, s.UserAccount().Create(ctx, &model.UserAccount{User_id: 2, Currency: "RUB", Balance: -1}))
+
+	account, err := s.UserAccount().FindById(ctx, 1, "RUB")
+	assert.NoError(t, err)
+	assert.Equal(t, model.Amount(100), account.Balance)
+}
+
+func TestUserAccountRepository_Wallets(t *testing.T) {
+	ctx := context.Background()
+	s := teststore.New()
+	assert.NoError(t, s.UserAccount().Create(ctx, &model.UserAccount{User_id: 1, Currency: "USD", Balance: 10}))
+	assert.NoError(t, s.UserAccount().Create(ctx, &model.UserAccount{User_id: 1, Currency: "RUB", Balance: 100}))
+	assert.NoError(t, s.UserAccount().Create(ctx, &model.UserAccount{User_id: 2, Currency: "RUB", Balance: 0}))
+
+	_, err := s.UserAccount().Transfer(ctx,
+		model.Wallet{User_id: 1, Currency: "USD"},
+		model.Wallet{User_id: 2, Currency: "RUB"},
+		5, 450,
+	)
+	assert.NoError(t, err)
+
+	accounts, err := s.UserAccount().FindByUser(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []model.UserAccount{
+		{User_id: 1, Currency: "RUB", Balance: 100},
+		{User_id: 1, Currency: "USD", Balance: 5},
+	}, accounts)
+
+	account, err := s.UserAccount().FindById(ctx, 2, "RUB")
+	assert.NoError(t, err)
+	assert.Equal(t, model.Amount(450), account.Balance)
+
+	_, err = s.UserAccount().FindById(ctx, 2, "USD")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func TestUserAccountRepository_Reserve(t *testing.T) {
+	ctx := context.Background()
+	s := teststore.New()
+	assert.NoError(t, s.UserAccount().Create(ctx, &model.UserAccount{User_id: 1, Currency: "RUB", Balance: 100}))
+
+	assert.NoError(t, s.WithinTx(context.Background(), func(tx store.TxStore) error {
+		account, err := tx.UserAccount().Reserve(ctx, &model.UserAccount{User_id: 1, Currency: "RUB", Balance: 70})
+		assert.NoError(t, err)
+		assert.Equal(t, model.Amount(30), account.Balance)
+
+		_, err = tx.UserAccount().Reserve(ctx, &model.UserAccount{User_id: 1, Currency: "RUB", Balance: 70})
+		assert.Error(t, err)
+		_, err = tx.UserAccount().ConfirmReserve(ctx, &model.UserAccount{User_id: 1, Currency: "RUB", Balance: 100})
+		assert.Error(t, err)
+		_, err = tx.UserAccount().AbortReserve(ctx, &model.UserAccount{User_id: 2, Currency: "RUB", Balance: 70})
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+		return nil
+	}))
+
+	account, _ := s.UserAccount().FindById(ctx, 1, "RUB")
+	assert.Equal(t, model.Amount(30), account.Balance)
+	assert.Equal(t, model.Amount(70), account.Reserved_balance)
+}
+
+func TestUserAccountRepository_AddOverflow(t *testing.T) {
+	ctx := context.Background()
+	s := teststore.New()
+	assert.NoError(t, s.UserAccount().Create(ctx, &model.UserAccount{User_id: 1, Currency: "RUB", Balance: 100}))
+
+	_, err := s.UserAccount().Add(ctx, &model.UserAccount{User_id: 1, Currency: "RUB", Balance: math.MaxInt64})
+	var pqErr *pq.Error
+	if assert.ErrorAs(t, err, &pqErr) {
+		assert.Equal(t, pq.ErrorCode("22003"), pqErr.Code)
+	}
+	account, _ := s.UserAccount().FindById(ctx, 1, "RUB")
+	assert.Equal(t, model.Amount(100), account.Balance)
+}
+
+func TestUserAccountRepository_ConcurrentCommits(t *testing.T) {
+	ctx := context.Background()
+	s := teststore.New()
+	assert.NoError(t, s.UserAccount().Create(ctx, &model.UserAccount{User_id: 1, Currency: "RUB", Balance: 100}))
+
+	err := s.WithinTx(context.Background(), func(tx store.TxStore) error {
+		_, err := tx.UserAccount().Reserve(ctx, &model.UserAccount{User_id: 1, Currency: "RUB", Balance: 60})
+		assert.NoError(t, err)
+
+		assert.NoError(t, s.WithinTx(context.Background(), func(tx store.TxStore) error {
+			_, err := tx.UserAccount().Reserve(ctx, &model.UserAccount{User_id: 1, Currency: "RUB", Balance: 60})
+			return err
+		}))
+		return nil
+	})
+	assert.Error(t, err)
+
+	account, _ := s.UserAccount().FindById(ctx, 1, "RUB")
+	assert.Equal(t, model.Amount(40), account.Balance)
+}
+
+func TestTransactionRepository_CreateReserveTransaction(t *testing.T) {
+	ctx := context.Background()
+	s := teststore.New()
+	assert.NoError(t, s.UserAccount().Create(ctx, &model.UserAccount{User_id: 1, Currency: "RUB", Balance: 100}))
+
+	reserve := &model.Transaction{User_id: 1, Amount: 50, Currency: "RUB", Order_id: 10, Service_id: 1, Type: "reserve"}
+	assert.NoError(t, s.Transaction().CreateReserveTransaction(ctx, reserve))
+	assert.NotZero(t, reserve.Id)
+
+	testCases := []struct {
+		name        string
+		transaction *model.Transaction
+	}{
+		{
+			name:        "duplicate",
+			transaction: &model.Transaction{User_id: 1, Amount: 50, Currency: "RUB", Order_id: 10, Service_id: 1, Type: "reserve"},
+		}, {
+			name:        "unknown user",
+			transaction: &model.Transaction{User_id: 2, Amount: 50, Currency: "RUB", Order_id: 10, Service_id: 1, Type: "reserve"},
+		}, {
+			name:        "unknown service",
+			transaction: &model.Transaction{User_id: 1, Amount: 50, Currency: "RUB", Order_id: 10, Service_id: 3, Type: "reserve"},
+		}, {
+			name:        "unknown type",
+			transaction: &model.Transaction{User_id: 1, Amount: 50, Currency: "RUB", Order_id: 11, Service_id: 1, Type: "transfer"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Error(t, s.Transaction().CreateReserveTransaction(ctx, tc.transaction))
+		})
+	}
+}
+
+func TestTransactionRepository_GetReservation(t *testing.T) {
+	ctx := context.Background()
+	s := teststore.New()
+	assert.NoError(t, s.UserAccount().Create(ctx, &model.UserAccount{User_id: 1, Currency: "RUB", Balance: 100}))
+	reserve := &model.Transaction{User_id: 1, Amount: 50, Currency: "RUB", Order_id: 10, Service_id: 1, Type: "reserve"}
+	assert.NoError(t, s.Transaction().CreateReserveTransaction(ctx, reserve))
+	deposit := &model.Transaction{User_id: 1, Amount: 50, Currency: "RUB", Closed_date: time.Now(), Success_flg: true, Type: "add"}
+	assert.NoError(t, s.Transaction().CreateAddTransaction(ctx, deposit))
+
+	reservation, err := s.Transaction().GetReservation(ctx, reserve.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, reserve.Amount, reservation.Amount)
+	assert.Equal(t, reserve.Order_id, reservation.Order_id)
+
+	_, err = s.Transaction().GetReservation(ctx, deposit.Id)
+	assert.Equal(t, sql.ErrNoRows, err)
+
+	assert.NoError(t, s.Transaction().AbortReserveTransaction(ctx, reserve.Id))
+	_, err = s.Transaction().GetReservation(ctx, reserve.Id)
+	assert.Equal(t, sql.ErrNoRows, err)
+}
+
+func TestTransactionRepository_GetOpenReservations(t *testing.T) {
+	ctx := context.Background()
+	s := teststore.New()
+	assert.NoError(t, s.UserAccount().Create(ctx, &model.UserAccount{User_id: 1, Currency: "RUB", Balance: 100}))
+	assert.NoError(t, s.UserAccount().Create(ctx, &model.UserAccount{User_id: 2, Currency: "RUB", Balance: 100}))
+	for i, reserve := range []*model.Transaction{
+		{User_id: 1, Amount: 10, Currency: "RUB", Order_id: 1, Service_id: 1, Type: "reserve"},
+		{User_id: 1, Amount: 20, Currency: "RUB", Order_id: 2, Service_id: 1, Type: "reserve"},
+		{User_id: 1, Amount: 30, Currency: "RUB", Order_id: 3, Service_id: 1, Type: "reserve"},
+		{User_id: 2, Amount: 40, Currency: "RUB", Order_id: 4, Service_id: 1, Type: "reserve"},
+	} {
+		assert.NoError(t, s.Transaction().CreateReserveTransaction(ctx, reserve))
+		assert.False(t, reserve.Created_at.IsZero())
+		if i == 1 {
+			assert.NoError(t, s.Transaction().ConfirmReserveTransaction(ctx, reserve.Id))
+		}
+	}
+
+	reservations, err := s.Transaction().GetOpenReservations(ctx, 1)
+	assert.NoError(t, err)
+	amounts := []model.Amount{}
+	for _, reservation := range reservations {
+		amounts = append(amounts, reservation.Amount)
+	}
+	assert.Equal(t, []model.Amount{10, 30}, amounts)
+}
+
+func TestTransactionRepository_Reports(t *testing.T) {
+	ctx := context.Background()
+	s := teststore.New()
+
+	reserve := &model.Transaction{User_id: 1, Amount: 40, Currency: "RUB", Order_id: 10, Service_id: 2, Type: "reserve"}
+	assert.NoError(t, s.WithinTx(context.Background(), func(tx store.TxStore) error {
+		assert.NoError(t, tx.UserAccount().Create(ctx, &model.UserAccount{User_id: 1, Currency: "RUB", Balance: 100}))
+		assert.NoError(t, tx.Transaction().CreateAddTransaction(ctx, &model.Transaction{
+			User_id: 1, Amount: 100, Currency: "RUB", Closed_date: time.Now(), Success_flg: true, Type: "add",
+		}))
+		return tx.Transaction().CreateReserveTransaction(ctx, reserve)
+	}))
+
+	found, err := s.Transaction().GetTransaction(ctx, &model.Transaction{User_id: 1, Amount: 40, Currency: "RUB", Order_id: 10, Service_id: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, reserve.Id, found.Id)
+
+	assert.NoError(t, s.Transaction().ConfirmReserveTransaction(ctx, reserve.Id))
+
+	_, err = s.Transaction().GetTransaction(ctx, &model.Transaction{User_id: 1, Amount: 40, Currency: "RUB", Order_id: 10, Service_id: 2})
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	now := time.Now()
+	report, err := s.Transaction().GetMonthReport(ctx, int(now.Month()), now.Year())
+	assert.NoError(t, err)
+	assert.Equal(t, []model.ServiceRevenue{{Service: "услуга 2", Currency: "RUB", Amount: 40}}, report)
+
+	history, err := s.Transaction().GetAccountReport(ctx, 1, "", "amount", "DESC", 1, 3)
+	assert.NoError(t, err)
+	assert.Len(t, *history, 2)
+	assert.Equal(t, model.Amount(100), (*history)[0].Amount)
+	assert.Equal(t, "n/d", (*history)[0].Service)
+	assert.Equal(t, "услуга 2", (*history)[1].Service)
+
+	history, err = s.Transaction().GetAccountReport(ctx, 1, "USD", "amount", "DESC", 1, 3)
+	assert.NoError(t, err)
+	assert.Empty(t, *history)
+
+	_, err = s.Transaction().GetAccountReport(ctx, 1, "", "amount", "ASC", 0, 3)
+	assert.Error(t, err)
+
+	first, err := s.Transaction().GetAccountReportAfter(ctx, 1, "", "amount", "DESC", nil, 1)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Transaction().CreateAddTransaction(ctx, &model.Transaction{
+		User_id: 1, Amount: 500, Currency: "RUB", Closed_date: time.Now(), Success_flg: true, Type: "add",
+	}))
+	next, err := s.Transaction().GetAccountReportAfter(ctx, 1, "", "amount", "DESC", &(*first)[0], 3)
+	assert.NoError(t, err)
+	if assert.Len(t, *next, 1) {
+		assert.Equal(t, model.Amount(40), (*next)[0].Amount)
+	}
+}
+
+func TestLedgerRepository_Post(t *testing.T) {
+	ctx := context.Background()
+	s := teststore.New()
+
+	assert.ErrorIs(t, s.Ledger().Post(ctx, &model.JournalEntry{}), store.UnbalancedEntry)
+	assert.ErrorIs(t, s.Ledger().Post(ctx, &model.JournalEntry{Postings: []model.Posting{
+		{Account: "external:RUB", Amount: -100},
+		{Account: "user:1:RUB", Amount: 90},
+	}}), store.UnbalancedEntry)
+	assert.ErrorIs(t, s.Ledger().Post(ctx, &model.JournalEntry{Postings: []model.Posting{
+		{Account: "user:1:RUB", Amount: -100},
+		{Account: "user:1:USD", Amount: 100},
+	}}), store.UnbalancedEntry)
+	assert.Error(t, s.Ledger().Post(ctx, &model.JournalEntry{Transaction_id: 1, Postings: []model.Posting{
+		{Account: "external:RUB", Amount: -100},
+		{Account: "user:1:RUB", Amount: 100},
+	}}))
+
+	entry := &model.JournalEntry{Postings: []model.Posting{
+		{Account: "external:RUB", Amount: -100},
+		{Account: "user:1:RUB", Amount: 100},
+	}}
+	assert.NoError(t, s.Ledger().Post(ctx, entry))
+	assert.NotZero(t, entry.Id)
+	assert.NoError(t, s.Ledger().Post(ctx, &model.JournalEntry{Postings: []model.Posting{
+		{Account: "user:1:RUB", Amount: -30},
+		{Account: "reserved:1:RUB", Amount: 30},
+	}}))
+
+	balance, err := s.Ledger().Balance(ctx, "user:1:RUB")
+	assert.NoError(t, err)
+	assert.Equal(t, model.Amount(70), balance)
+	balance, err = s.Ledger().Balance(ctx, "user:2:RUB")
+	assert.NoError(t, err)
+	assert.Equal(t, model.Amount(0), balance)
+}
+
+func TestTransactionRepository_GetRevenueReport(t *testing.T) {
+	ctx := context.Background()
+	s := teststore.New()
+
+	for _, wallet := range []model.Wallet{{User_id: 1, Currency: "RUB"}, {User_id: 1, Currency: "USD"}, {User_id: 2, Currency: "RUB"}} {
+		assert.NoError(t, s.UserAccount().Create(ctx, &model.UserAccount{User_id: wallet.User_id, Currency: wallet.Currency}))
+	}
+	day := func(d int) time.Time { return time.Date(2022, time.November, d, 12, 0, 0, 0, time.Local) }
+	for _, charge := range []*model.Transaction{
+		{User_id: 2, Amount: 10, Currency: "RUB", Order_id: 1, Service_id: 2, Closed_date: day(1), Type: "charge"},
+		{User_id: 1, Amount: 20, Currency: "RUB", Order_id: 2, Service_id: 1, Closed_date: day(7), Type: "charge"},
+		{User_id: 1, Amount: 30, Currency: "USD", Order_id: 3, Service_id: 2, Closed_date: day(8), Type: "charge"},
+		{User_id: 2, Amount: 5, Currency: "RUB", Order_id: 1, Service_id: 2, Closed_date: day(8), Type: "refund"},
+		{User_id: 1, Amount: 40, Currency: "RUB", Order_id: 4, Service_id: 1, Closed_date: day(30), Type: "charge"},
+	} {
+		charge.Success_flg = true
+		assert.NoError(t, s.Transaction().CreateLinkedTransaction(ctx, charge))
+	}
+
+	from := time.Date(2022, time.November, 1, 0, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		name     string
+		query    model.ReportQuery
+		expected []model.Revenue
+	}{
+		{
+			name:  "service",
+			query: model.ReportQuery{From: from, To: from.AddDate(0, 0, 29), GroupBy: []string{model.GroupByService}},
+			expected: []model.Revenue{
+				{Service: "услуга 1", Currency: "RUB", Amount: 20},
+				{Service: "услуга 2", Currency: "RUB", Amount: 5},
+				{Service: "услуга 2", Currency: "USD", Amount: 30},
+			},
+		}, {
+			name:  "week and user",
+			query: model.ReportQuery{From: from, To: from.AddDate(0, 1, 0), GroupBy: []string{model.GroupByWeek, model.GroupByUser}},
+			expected: []model.Revenue{
+				{Period: "2022-10-31", User_id: 2, Currency: "RUB", Amount: 10},
+				{Period: "2022-11-07", User_id: 1, Currency: "RUB", Amount: 20},
+				{Period: "2022-11-07", User_id: 1, Currency: "USD", Amount: 30},
+				{Period: "2022-11-07", User_id: 2, Currency: "RUB", Amount: -5},
+				{Period: "2022-11-28", User_id: 1, Currency: "RUB", Amount: 40},
+			},
+		}, {
+			name:  "service and day",
+			query: model.ReportQuery{From: from.AddDate(0, 0, 7), To: from.AddDate(0, 0, 8), GroupBy: []string{model.GroupByService, model.GroupByDay}},
+			expected: []model.Revenue{
+				{Service: "услуга 2", Period: "2022-11-08", Currency: "RUB", Amount: -5},
+				{Service: "услуга 2", Period: "2022-11-08", Currency: "USD", Amount: 30},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			report, err := s.Transaction().GetRevenueReport(ctx, tc.query)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, report)
+		})
+	}
+
+	_, err := s.Transaction().GetRevenueReport(ctx, model.ReportQuery{From: from, To: from, GroupBy: []string{"year"}})
+	assert.Error(t, err)
+}
+
+func TestReportJobRepository_Claim(t *testing.T) {
+	ctx := context.Background()
+	s := teststore.New()
+
+	query := model.ReportQuery{From: time.Now(), To: time.Now().AddDate(0, 0, 1), GroupBy: []string{model.GroupByService}}
+	jobs := []*model.ReportJob{{Query: query}, {Query: query}}
+	for _, job := range jobs {
+		assert.NoError(t, s.ReportJob().Create(ctx, job))
+		assert.Equal(t, model.ReportPending, job.Status)
+	}
+
+	for _, job := range jobs {
+		claimed, err := s.ReportJob().Claim(ctx, time.Now().Add(-time.Minute))
+		assert.NoError(t, err)
+		assert.Equal(t, job.Id, claimed.Id)
+		assert.Equal(t, model.ReportRunning, claimed.Status)
+		assert.NotNil(t, claimed.Started_at)
+	}
+	_, err := s.ReportJob().Claim(ctx, time.Now().Add(-time.Minute))
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	jobs[0].Status = model.ReportDone
+	jobs[0].File = "report.csv"
+	assert.NoError(t, s.ReportJob().Finish(ctx, jobs[0]))
+	found, err := s.ReportJob().Find(ctx, jobs[0].Id)
+	assert.NoError(t, err)
+	assert.Equal(t, model.ReportDone, found.Status)
+	assert.Equal(t, "report.csv", found.File)
+	assert.NotNil(t, found.Finished_at)
+
+	// The second job has run for too long and is claimed again.
+	claimed, err := s.ReportJob().Claim(ctx, time.Now().Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, jobs[1].Id, claimed.Id)
+
+	_, err = s.ReportJob().Find(ctx, 100)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+}
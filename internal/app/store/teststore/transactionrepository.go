@@ -0,0 +1,500 @@
+package teststore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+	"user_balance_microservice/internal/app/model"
+)
+
+type TransactionRepository struct {
+	store *txStore
+}
+
+func (r *TransactionRepository) CreateReserveTransaction(ctx context.Context, transaction *model.Transaction) error {
+	row := transactionRow{
+		Transaction: model.Transaction{
+			Id:          r.store.nextId(),
+			User_id:     transaction.User_id,
+			Amount:      transaction.Amount,
+			Currency:    transaction.Currency,
+			Description: transaction.Description,
+			Order_id:    transaction.Order_id,
+			Service_id:  transaction.Service_id,
+			Created_at:  time.Now(),
+			Expires_at:  transaction.Expires_at,
+			Type:        transaction.Type,
+		},
+		keyed: true,
+	}
+	if _, err := r.store.execContext(ctx, func(d *data) error {
+		return d.insertTransaction(row)
+	}); err != nil {
+		return err
+	}
+
+	transaction.Id = row.Id
+	transaction.Created_at = row.Created_at
+	return nil
+}
+
+func (r *TransactionRepository) CreateAddTransaction(ctx context.Context, transaction *model.Transaction) error {
+	row := transactionRow{
+		Transaction: model.Transaction{
+			Id:            r.store.nextId(),
+			User_id:       transaction.User_id,
+			Amount:        transaction.Amount,
+			Currency:      transaction.Currency,
+			Description:   transaction.Description,
+			Closed_date:   transaction.Closed_date,
+			Created_at:    time.Now(),
+			Success_flg:   transaction.Success_flg,
+			Type:          transaction.Type,
+			Conversion_id: transaction.Conversion_id,
+		},
+	}
+	if _, err := r.store.execContext(ctx, func(d *data) error {
+		return d.insertTransaction(row)
+	}); err != nil {
+		return err
+	}
+
+	transaction.Id = row.Id
+	return nil
+}
+
+func (r *TransactionRepository) CreateLinkedTransaction(ctx context.Context, transaction *model.Transaction) error {
+	row := transactionRow{
+		Transaction: model.Transaction{
+			Id:          r.store.nextId(),
+			User_id:     transaction.User_id,
+			Amount:      transaction.Amount,
+			Currency:    transaction.Currency,
+			Description: transaction.Description,
+			Order_id:    transaction.Order_id,
+			Service_id:  transaction.Service_id,
+			Closed_date: transaction.Closed_date,
+			Created_at:  time.Now(),
+			Success_flg: transaction.Success_flg,
+			Type:        transaction.Type,
+			Parent_id:   transaction.Parent_id,
+		},
+		keyed: true,
+	}
+	if _, err := r.store.execContext(ctx, func(d *data) error {
+		return d.insertTransaction(row)
+	}); err != nil {
+		return err
+	}
+
+	transaction.Id = row.Id
+	return nil
+}
+
+func (r *TransactionRepository) GetTransaction(ctx context.Context, transaction *model.Transaction) (*model.Transaction, error) {
+	d, err := r.store.snapshotContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range d.transactions {
+		if row.keyed &&
+			row.User_id == transaction.User_id &&
+			row.Order_id == transaction.Order_id &&
+			row.Service_id == transaction.Service_id &&
+			row.Amount == transaction.Amount &&
+			row.Currency == transaction.Currency &&
+			row.Closed_date.IsZero() {
+			transaction.Id = row.Id
+			return transaction, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (r *TransactionRepository) GetReservation(ctx context.Context, id int) (*model.Transaction, error) {
+	d, err := r.store.snapshotContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	row, ok := d.transactions[id]
+	if !ok || row.Type != "reserve" || !row.Closed_date.IsZero() {
+		return nil, sql.ErrNoRows
+	}
+	reservation := row.Transaction
+	return &reservation, nil
+}
+
+// LockReservation is GetReservation that holds the lock of the wallet of
+// the reservation, which stands in for its row lock, until the end of the
+// transaction.
+func (r *TransactionRepository) LockReservation(ctx context.Context, id int) (*model.Transaction, error) {
+	reservation, err := r.GetReservation(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	r.store.lock(reservation.Wallet())
+	return r.GetReservation(ctx, id)
+}
+
+func (r *TransactionRepository) ConfirmReserveTransaction(ctx context.Context, transactionId int) error {
+	closed := time.Now()
+	_, err := r.store.execContext(ctx, func(d *data) error {
+		row, ok := d.transactions[transactionId]
+		if !ok || !row.Closed_date.IsZero() {
+			return sql.ErrNoRows
+		}
+		row.Success_flg = true
+		row.Closed_date = closed
+		d.transactions[transactionId] = row
+		return nil
+	})
+	return err
+}
+
+func (r *TransactionRepository) AbortReserveTransaction(ctx context.Context, transactionId int) error {
+	closed := time.Now()
+	_, err := r.store.execContext(ctx, func(d *data) error {
+		row, ok := d.transactions[transactionId]
+		if !ok || !row.Closed_date.IsZero() {
+			return sql.ErrNoRows
+		}
+		row.Closed_date = closed
+		d.transactions[transactionId] = row
+		return nil
+	})
+	return err
+}
+
+func (r *TransactionRepository) ReduceReserveTransaction(ctx context.Context, transactionId int, amount model.Amount) error {
+	_, err := r.store.execContext(ctx, func(d *data) error {
+		row, ok := d.transactions[transactionId]
+		if !ok || !row.Closed_date.IsZero() || row.Amount <= amount {
+			return sql.ErrNoRows
+		}
+		row.Amount -= amount
+		if d.reserveExists(row) {
+			return errReserveExists
+		}
+		d.transactions[transactionId] = row
+		return nil
+	})
+	return err
+}
+
+func (r *TransactionRepository) GetExpiredReservations(ctx context.Context, before time.Time, limit int) ([]model.Transaction, error) {
+	reservations := []model.Transaction{}
+	d, err := r.store.snapshotContext(ctx)
+	if err != nil {
+		return reservations, err
+	}
+	for _, row := range d.transactions {
+		if row.Type == "reserve" && row.Closed_date.IsZero() && row.Expires_at != nil && row.Expires_at.Before(before) {
+			reservations = append(reservations, row.Transaction)
+		}
+	}
+	sort.Slice(reservations, func(i, j int) bool {
+		return reservations[i].Expires_at.Before(*reservations[j].Expires_at)
+	})
+	if len(reservations) > limit {
+		reservations = reservations[:limit]
+	}
+
+	return reservations, nil
+}
+
+func (r *TransactionRepository) GetOpenReservations(ctx context.Context, userId int) ([]model.Transaction, error) {
+	reservations := []model.Transaction{}
+	d, err := r.store.snapshotContext(ctx)
+	if err != nil {
+		return reservations, err
+	}
+	for _, row := range d.transactions {
+		if row.User_id == userId && row.Type == "reserve" && row.Closed_date.IsZero() {
+			reservations = append(reservations, row.Transaction)
+		}
+	}
+	sort.Slice(reservations, func(i, j int) bool {
+		if !reservations[i].Created_at.Equal(reservations[j].Created_at) {
+			return reservations[i].Created_at.Before(reservations[j].Created_at)
+		}
+		return reservations[i].Id < reservations[j].Id
+	})
+
+	return reservations, nil
+}
+
+// GetCharges takes the lock of the user's wallet, which serializes the
+// refunds of a user just like the row locks of sqlstore do.
+func (r *TransactionRepository) GetCharges(ctx context.Context, userId, serviceId, orderId int, currency string) ([]model.Transaction, error) {
+	r.store.lock(model.Wallet{User_id: userId, Currency: currency})
+
+	charges := []model.Transaction{}
+	d, err := r.store.snapshotContext(ctx)
+	if err != nil {
+		return charges, err
+	}
+	for _, row := range d.transactions {
+		if row.keyed &&
+			row.User_id == userId &&
+			row.Service_id == serviceId &&
+			row.Order_id == orderId &&
+			row.Currency == currency &&
+			row.Success_flg &&
+			(row.Type == "reserve" || row.Type == "charge") {
+			charges = append(charges, row.Transaction)
+		}
+	}
+	sort.Slice(charges, func(i, j int) bool {
+		return charges[i].Id < charges[j].Id
+	})
+
+	return charges, nil
+}
+
+func (r *TransactionRepository) GetRefundedAmount(ctx context.Context, chargeId int) (model.Amount, error) {
+	d, err := r.store.snapshotContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+	refunded := model.Amount(0)
+	for _, row := range d.transactions {
+		if row.Parent_id == chargeId && row.Type == "refund" {
+			refunded += row.Amount
+		}
+	}
+	return refunded, nil
+}
+
+func (r *TransactionRepository) GetMonthReport(ctx context.Context, month int, year int) ([]model.ServiceRevenue, error) {
+	report := []model.ServiceRevenue{}
+	d, err := r.store.snapshotContext(ctx)
+	if err != nil {
+		return report, err
+	}
+	type key struct{ service, currency string }
+	revenue := make(map[key]model.Amount)
+	for _, row := range d.transactions {
+		service, ok := d.services[row.Service_id]
+		if !row.keyed || !ok || !row.Success_flg {
+			continue
+		}
+		if int(row.Closed_date.Month()) != month || row.Closed_date.Year() != year {
+			continue
+		}
+		switch row.Type {
+		case "reserve", "charge":
+			revenue[key{service, row.Currency}] += row.Amount
+		case "refund":
+			revenue[key{service, row.Currency}] -= row.Amount
+		}
+	}
+
+	for k, amount := range revenue {
+		report = append(report, model.ServiceRevenue{Service: k.service, Currency: k.currency, Amount: amount})
+	}
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].Service != report[j].Service {
+			return report[i].Service < report[j].Service
+		}
+		return report[i].Currency < report[j].Currency
+	})
+	return report, nil
+}
+
+func (r *TransactionRepository) GetRevenueReport(ctx context.Context, query model.ReportQuery) ([]model.Revenue, error) {
+	report := []model.Revenue{}
+	d, err := r.store.snapshotContext(ctx)
+	if err != nil {
+		return report, err
+	}
+	for _, grouping := range query.GroupBy {
+		switch grouping {
+		case model.GroupByService, model.GroupByUser, model.GroupByDay, model.GroupByWeek, model.GroupByMonth:
+		default:
+			return report, fmt.Errorf("unknown report grouping %q", grouping)
+		}
+	}
+	revenue := make(map[model.Revenue]model.Amount)
+	for _, row := range d.transactions {
+		service, ok := d.services[row.Service_id]
+		if !row.keyed || !ok || !row.Success_flg {
+			continue
+		}
+		day := model.PeriodStart(row.Closed_date, model.GroupByDay)
+		if day.Before(query.From) || !day.Before(query.To) {
+			continue
+		}
+		group := model.Revenue{Currency: row.Currency}
+		for _, grouping := range query.GroupBy {
+			switch grouping {
+			case model.GroupByService:
+				group.Service = service
+			case model.GroupByUser:
+				group.User_id = row.User_id
+			default:
+				group.Period = model.PeriodStart(day, grouping).Format("2006-01-02")
+			}
+		}
+		switch row.Type {
+		case "reserve", "charge":
+			revenue[group] += row.Amount
+		case "refund":
+			revenue[group] -= row.Amount
+		}
+	}
+
+	for group, amount := range revenue {
+		group.Amount = amount
+		report = append(report, group)
+	}
+	sort.Slice(report, func(i, j int) bool {
+		a, b := report[i], report[j]
+		for _, grouping := range query.GroupBy {
+			switch grouping {
+			case model.GroupByService:
+				if a.Service != b.Service {
+					return a.Service < b.Service
+				}
+			case model.GroupByUser:
+				if a.User_id != b.User_id {
+					return a.User_id < b.User_id
+				}
+			default:
+				if a.Period != b.Period {
+					return a.Period < b.Period
+				}
+			}
+		}
+		return a.Currency < b.Currency
+	})
+	return report, nil
+}
+
+func (r *TransactionRepository) GetAccountReport(ctx context.Context, userId int, currency, orderCol, orderDir string, page, pageSize int) (*[]model.AccountTransaction, error) {
+	return r.accountReport(ctx, userId, currency, orderCol, orderDir, nil, (page-1)*pageSize, pageSize)
+}
+
+func (r *TransactionRepository) GetAccountReportAfter(ctx context.Context, userId int, currency, orderCol, orderDir string, after *model.AccountTransaction, limit int) (*[]model.AccountTransaction, error) {
+	return r.accountReport(ctx, userId, currency, orderCol, orderDir, after, 0, limit)
+}
+
+func (r *TransactionRepository) accountReport(ctx context.Context, userId int, currency, orderCol, orderDir string, after *model.AccountTransaction, offset, pageSize int) (*[]model.AccountTransaction, error) {
+	report := []model.AccountTransaction{}
+	if orderCol != "amount" && orderCol != "closed_date" {
+		return &report, fmt.Errorf("unknown sort column %q", orderCol)
+	}
+	if offset < 0 {
+		return &report, errNegativeOffset
+	}
+	if pageSize < 0 {
+		return &report, errNegativeFetchFirst
+	}
+
+	d, err := r.store.snapshotContext(ctx)
+	if err != nil {
+		return &report, err
+	}
+	less := func(a, b transactionRow) bool {
+		if orderDir == "DESC" {
+			a, b = b, a
+		}
+		if orderCol == "closed_date" && !a.Closed_date.Equal(b.Closed_date) {
+			return a.Closed_date.Before(b.Closed_date)
+		}
+		if orderCol == "amount" && a.Amount != b.Amount {
+			return a.Amount < b.Amount
+		}
+		return a.Id < b.Id
+	}
+	rows := []transactionRow{}
+	for _, row := range d.transactions {
+		if !row.Success_flg || row.User_id != userId || (currency != "" && row.Currency != currency) {
+			continue
+		}
+		if after != nil && !less(transactionRow{Transaction: model.Transaction{Id: after.Id, Amount: after.Amount, Closed_date: after.Closed_date}}, row) {
+			continue
+		}
+		rows = append(rows, row)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		return less(rows[i], rows[j])
+	})
+
+	for i := offset; i < len(rows) && i < offset+pageSize; i++ {
+		record := model.AccountTransaction{
+			Id:          rows[i].Id,
+			Amount:      rows[i].Amount,
+			Currency:    rows[i].Currency,
+			Description: rows[i].Description,
+			Service:     "n/d",
+			Closed_date: rows[i].Closed_date,
+		}
+		if rows[i].keyed {
+			record.Order_id = rows[i].Order_id
+			record.Service = d.services[rows[i].Service_id]
+		}
+		if conversion, ok := d.conversions[rows[i].Conversion_id]; ok {
+			record.Conversion = &conversion
+		}
+		report = append(report, record)
+	}
+
+	return &report, nil
+}
+
+var transactionTypes = map[string]bool{
+	"add":          true,
+	"reserve":      true,
+	"charge":       true,
+	"release":      true,
+	"refund":       true,
+	"transfer_in":  true,
+	"transfer_out": true,
+}
+
+func (d *data) insertTransaction(row transactionRow) error {
+	if !transactionTypes[row.Type] {
+		return errTypeCheck
+	}
+	if _, ok := d.accounts[model.Wallet{User_id: row.User_id, Currency: row.Currency}]; !ok {
+		return errAccountForeignKey
+	}
+	if row.keyed {
+		if _, ok := d.services[row.Service_id]; !ok {
+			return errServiceForeignKey
+		}
+	}
+	if _, ok := d.transactions[row.Parent_id]; row.Parent_id != 0 && !ok {
+		return errParentForeignKey
+	}
+	if _, ok := d.conversions[row.Conversion_id]; row.Conversion_id != 0 && !ok {
+		return errConversionForeignKey
+	}
+	if d.reserveExists(row) {
+		return errReserveExists
+	}
+	d.transactions[row.Id] = row
+	return nil
+}
+
+// reserveExists checks the unique index on reservations for row.
+func (d *data) reserveExists(row transactionRow) bool {
+	if !row.keyed || row.Type != "reserve" {
+		return false
+	}
+	for _, other := range d.transactions {
+		if other.Id != row.Id &&
+			other.keyed &&
+			other.Type == "reserve" &&
+			other.User_id == row.User_id &&
+			other.Currency == row.Currency &&
+			other.Amount == row.Amount &&
+			other.Order_id == row.Order_id &&
+			other.Service_id == row.Service_id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,148 @@
+package teststore
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"sort"
+	"user_balance_microservice/internal/app/model"
+)
+
+type UserAccountRepository struct {
+	store *txStore
+}
+
+func (r *UserAccountRepository) Create(ctx context.Context, account *model.UserAccount) error {
+	row := model.UserAccount{
+		User_id:  account.User_id,
+		Currency: account.Currency,
+		Balance:  account.Balance,
+	}
+	_, err := r.store.execContext(ctx, func(d *data) error {
+		if _, ok := d.accounts[row.Wallet()]; ok {
+			return errAccountExists
+		}
+		return d.putAccount(row)
+	})
+	return err
+}
+
+func (r *UserAccountRepository) Add(ctx context.Context, account *model.UserAccount) (*model.UserAccount, error) {
+	return r.update(ctx, account, account.Balance, 0)
+}
+
+func (r *UserAccountRepository) Transfer(ctx context.Context, from, to model.Wallet, debit, credit model.Amount) (*model.UserAccount, error) {
+	if _, err := r.store.execContext(ctx, func(d *data) error {
+		if err := d.addToAccount(from, -debit, 0); err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		if err := d.addToAccount(to, credit, 0); err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return &model.UserAccount{}, nil
+}
+
+func (r *UserAccountRepository) Reserve(ctx context.Context, account *model.UserAccount) (*model.UserAccount, error) {
+	return r.update(ctx, account, -account.Balance, account.Balance)
+}
+
+func (r *UserAccountRepository) ConfirmReserve(ctx context.Context, account *model.UserAccount) (*model.UserAccount, error) {
+	return r.update(ctx, account, 0, -account.Balance)
+}
+
+func (r *UserAccountRepository) AbortReserve(ctx context.Context, account *model.UserAccount) (*model.UserAccount, error) {
+	return r.update(ctx, account, account.Balance, -account.Balance)
+}
+
+func (r *UserAccountRepository) FindById(ctx context.Context, id int, currency string) (*model.UserAccount, error) {
+	d, err := r.store.snapshotContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	account, ok := d.accounts[model.Wallet{User_id: id, Currency: currency}]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &account, nil
+}
+
+func (r *UserAccountRepository) FindByUser(ctx context.Context, id int) ([]model.UserAccount, error) {
+	accounts := []model.UserAccount{}
+	d, err := r.store.snapshotContext(ctx)
+	if err != nil {
+		return accounts, err
+	}
+	for wallet, account := range d.accounts {
+		if wallet.User_id == id {
+			accounts = append(accounts, account)
+		}
+	}
+	sort.Slice(accounts, func(i, j int) bool {
+		return accounts[i].Currency < accounts[j].Currency
+	})
+	return accounts, nil
+}
+
+func (r *UserAccountRepository) FindForUpdate(ctx context.Context, wallets ...model.Wallet) (map[model.Wallet]*model.UserAccount, error) {
+	r.store.lock(wallets...)
+
+	d, err := r.store.snapshotContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	accounts := make(map[model.Wallet]*model.UserAccount, len(wallets))
+	for _, wallet := range wallets {
+		if account, ok := d.accounts[wallet]; ok {
+			accounts[wallet] = &account
+		}
+	}
+	return accounts, nil
+}
+
+// update changes the balances of a single wallet the same way the
+// "UPDATE ... RETURNING user_id, balance" statements of sqlstore do.
+func (r *UserAccountRepository) update(ctx context.Context, account *model.UserAccount, balance, reserved model.Amount) (*model.UserAccount, error) {
+	wallet := account.Wallet()
+	d, err := r.store.execContext(ctx, func(d *data) error {
+		return d.addToAccount(wallet, balance, reserved)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	account.Balance = d.accounts[wallet].Balance
+	return account, nil
+}
+
+func (d *data) addToAccount(wallet model.Wallet, balance, reserved model.Amount) error {
+	account, ok := d.accounts[wallet]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if overflows(account.Balance, balance) || overflows(account.Reserved_balance, reserved) {
+		return errBigintOutOfRange
+	}
+	account.Balance += balance
+	account.Reserved_balance += reserved
+	return d.putAccount(account)
+}
+
+// overflows tells if a+b is out of the range of an int64.
+func overflows(a, b model.Amount) bool {
+	return b > 0 && a > math.MaxInt64-b || b < 0 && a < math.MinInt64-b
+}
+
+func (d *data) putAccount(account model.UserAccount) error {
+	if account.Balance < 0 {
+		return errBalanceCheck
+	}
+	if account.Reserved_balance < 0 {
+		return errReservedCheck
+	}
+	d.accounts[account.Wallet()] = account
+	return nil
+}
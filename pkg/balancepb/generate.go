@@ -0,0 +1,5 @@
+// Package balancepb is the generated code of the gRPC API described in
+// api/balance.proto.
+package balancepb
+
+//go:generate protoc -I ../../api --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative balance.proto